@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"error-handling-demo/config"
+	"error-handling-demo/dbops"
+	"error-handling-demo/errors"
+)
+
+// runCommand dispatches a maintenance subcommand such as "migrate"
+func runCommand(cfg *config.Config, log *logrus.Logger, args []string) error {
+	// Cancel the command cleanly on Ctrl+C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch args[0] {
+	case "migrate":
+		return runMigrateCommand(ctx, cfg, log, args[1:])
+	case "import":
+		return runImportCommand(ctx, cfg, log, args[1:])
+	case "export":
+		return runExportCommand(ctx, cfg, log, args[1:])
+	case "health":
+		return runHealthCommand(ctx, cfg, log, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q (available: migrate, import, export, health)", args[0])
+	}
+}
+
+// runMigrateCommand implements "migrate [-dry-run] [-steps N] up|down|status"
+func runMigrateCommand(ctx context.Context, cfg *config.Config, log *logrus.Logger, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "show which migrations would run without applying them")
+	steps := flags.Int("steps", 1, "number of migrations to revert with down")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: migrate [-dry-run] [-steps N] up|down|status")
+	}
+
+	// Open the database without migrating it, that's our job here
+	db, err := openDatabase(cfg, log, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := dbops.DefaultMigrator(db)
+	if err != nil {
+		return err
+	}
+	migrator.DryRun = *dryRun
+
+	prefix := ""
+	if *dryRun {
+		prefix = "[dry run] "
+	}
+
+	switch flags.Arg(0) {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Printf("%sapplied %s\n", prefix, m.ID())
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("database is up to date")
+		}
+	case "down":
+		reverted, err := migrator.Down(ctx, *steps)
+		for _, m := range reverted {
+			fmt.Printf("%sreverted %s\n", prefix, m.ID())
+		}
+		if err != nil {
+			return err
+		}
+		if len(reverted) == 0 {
+			fmt.Println("no migrations to revert")
+		}
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if s.Modified {
+				state += " (MODIFIED since it was applied)"
+			}
+			fmt.Printf("%-40s %s\n", s.ID(), state)
+		}
+	default:
+		return fmt.Errorf("unknown migrate action %q (expected up, down or status)", flags.Arg(0))
+	}
+
+	return nil
+}
+
+// runImportCommand implements "import [-format F] [-chunk N] [-upsert] [-all-or-nothing] FILE"
+func runImportCommand(ctx context.Context, cfg *config.Config, log *logrus.Logger, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", "", "input format: jsonl or csv (default: from the file extension)")
+	chunk := flags.Int("chunk", dbops.DefaultChunkSize, "rows per INSERT statement")
+	upsert := flags.Bool("upsert", false, "update users whose id already exists")
+	allOrNothing := flags.Bool("all-or-nothing", false, "import nothing if any row is rejected")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: import [-format jsonl|csv] [-chunk N] [-upsert] [-all-or-nothing] FILE")
+	}
+
+	fileName := flags.Arg(0)
+	bulkFormat, err := bulkFormat(*format, fileName)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		return errors.Wrap(err, "failed to open import file")
+	}
+	defer file.Close()
+
+	db, err := openDatabase(cfg, log, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	report, err := dbops.ImportUsers(ctx, db, file, dbops.ImportOptions{
+		Format:       bulkFormat,
+		ChunkSize:    *chunk,
+		Upsert:       *upsert,
+		AllOrNothing: *allOrNothing,
+	})
+
+	// Print the report even when the import failed, it says why
+	if report != nil {
+		fmt.Printf("read %d rows, imported %d, rejected %d\n", report.Total, report.Imported, len(report.Rejected))
+		for _, rejected := range report.Rejected {
+			for _, detail := range rejected.Details() {
+				fmt.Printf("  line %d: %s\n", rejected.Line, detail)
+			}
+		}
+	}
+
+	return err
+}
+
+// runExportCommand implements "export [-format F] [FILE]", writing to stdout without a FILE
+func runExportCommand(ctx context.Context, cfg *config.Config, log *logrus.Logger, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", "", "output format: jsonl or csv (default: from the file extension, jsonl for stdout)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		return errors.New("usage: export [-format jsonl|csv] [FILE]")
+	}
+
+	db, err := openDatabase(cfg, log, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if flags.NArg() == 0 {
+		bulkFormat := dbops.FormatJSONLines
+		if *format != "" {
+			bulkFormat = dbops.BulkFormat(*format)
+		}
+		_, err := dbops.ExportUsers(ctx, db, os.Stdout, bulkFormat)
+		return err
+	}
+
+	fileName := flags.Arg(0)
+	bulkFormat, err := bulkFormat(*format, fileName)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(fileName)
+	if err != nil {
+		return errors.Wrap(err, "failed to create export file")
+	}
+
+	count, err := dbops.ExportUsers(ctx, db, file, bulkFormat)
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = errors.Wrap(closeErr, "failed to close export file")
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("exported %d users to %s\n", count, fileName)
+	return nil
+}
+
+// runHealthCommand implements "health", printing the health status and
+// connection pool statistics as JSON
+func runHealthCommand(ctx context.Context, cfg *config.Config, log *logrus.Logger, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: health")
+	}
+
+	db, err := openDatabase(cfg, log, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	checker := dbops.NewHealthChecker(db, time.Duration(cfg.DatabasePool.HealthCheckInterval)*time.Second, nil)
+	status := checker.Check(ctx)
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(status); err != nil {
+		return errors.Wrap(err, "failed to encode health status")
+	}
+
+	if !status.Healthy {
+		return status.Err
+	}
+	return nil
+}
+
+// openDatabase opens the configured database with the configured pool and
+// slow-query settings, applying pending migrations if migrate is set
+func openDatabase(cfg *config.Config, log *logrus.Logger, migrate bool) (*dbops.DB, error) {
+	open := dbops.OpenDatabase
+	if migrate {
+		open = dbops.InitDatabase
+	}
+
+	db, err := open(cfg.DatabasePath)
+	if err != nil {
+		return nil, err
+	}
+	db.Configure(cfg, log)
+	return db, nil
+}
+
+// bulkFormat returns the explicitly requested format or guesses it from the file name
+func bulkFormat(format, fileName string) (dbops.BulkFormat, error) {
+	if format != "" {
+		return dbops.BulkFormat(format), nil
+	}
+	return dbops.FormatFromFileName(fileName)
+}
@@ -9,9 +9,59 @@ import (
 
 // Config represents the application configuration
 type Config struct {
-	DatabasePath string `json:"database_path"`
-	LogLevel     string `json:"log_level"`
-	APITimeout   int    `json:"api_timeout"` // in seconds
+	DatabasePath       string       `json:"database_path"` // SQLite file path or a sqlite://, postgres:// or mysql:// URL
+	DatabasePool       DatabasePool `json:"database_pool"`
+	SlowQueryThreshold int          `json:"slow_query_threshold"` // in milliseconds, 0 disables the slow-query log
+	QueryTimeout       int          `json:"query_timeout"`        // in seconds, for single-row lookups
+	LogLevel           string       `json:"log_level"`
+	APITimeout         int          `json:"api_timeout"` // in seconds
+	// FaultInjection makes netops, dbops and fileops fail on purpose for chaos testing
+	FaultInjection FaultInjection `json:"fault_injection"`
+}
+
+// FaultInjection configures injected failures, see package faults
+type FaultInjection struct {
+	Enabled bool        `json:"enabled"`
+	Seed    int64       `json:"seed"` // 0 picks a random seed
+	Rules   []FaultRule `json:"rules"`
+}
+
+// FaultRule describes one injected failure
+type FaultRule struct {
+	Op          string   `json:"op"`          // Operation name or pattern, e.g. "dbops.*"
+	Probability *float64 `json:"probability"` // 0 (never) to 1 (always), required
+	Error       string   `json:"error"`       // Registered error name, e.g. "timeout"
+	Latency     int      `json:"latency"`     // in milliseconds
+	Panic       bool     `json:"panic"`
+	Times       int      `json:"times"` // 0 means no limit
+}
+
+// Validate checks a fault rule. Rules from the FAULT_INJECTION environment
+// variable bypass the configuration file, so package faults checks them too.
+func (rule FaultRule) Validate() error {
+	if rule.Op == "" {
+		return errors.New("invalid fault rule: op must not be empty")
+	}
+	if rule.Probability == nil {
+		return errors.Errorf("invalid fault rule for %s: probability is required (1 always fires, 0 never does)", rule.Op)
+	}
+	if *rule.Probability < 0 || *rule.Probability > 1 {
+		return errors.Errorf("invalid fault rule for %s: probability must be between 0 and 1", rule.Op)
+	}
+	if rule.Latency < 0 || rule.Times < 0 {
+		return errors.Errorf("invalid fault rule for %s: latency and times must not be negative", rule.Op)
+	}
+	return nil
+}
+
+// DatabasePool configures the database connection pool.
+// A value of 0 leaves the database/sql default in place.
+type DatabasePool struct {
+	MaxOpenConns        int `json:"max_open_conns"`
+	MaxIdleConns        int `json:"max_idle_conns"`
+	ConnMaxLifetime     int `json:"conn_max_lifetime"`     // in seconds
+	ConnMaxIdleTime     int `json:"conn_max_idle_time"`    // in seconds
+	HealthCheckInterval int `json:"health_check_interval"` // in seconds
 }
 
 // Load reads the configuration from a file and returns a Config struct
@@ -19,8 +69,17 @@ func Load(filename string) (*Config, error) {
 	// Default configuration values
 	config := &Config{
 		DatabasePath: ":memory:", // SQLite in-memory database by default
-		LogLevel:     "info",
-		APITimeout:   30,
+		DatabasePool: DatabasePool{
+			MaxOpenConns:        10,
+			MaxIdleConns:        5,
+			ConnMaxLifetime:     30 * 60,
+			ConnMaxIdleTime:     5 * 60,
+			HealthCheckInterval: 30,
+		},
+		SlowQueryThreshold: 200,
+		QueryTimeout:       5,
+		LogLevel:           "info",
+		APITimeout:         30,
 	}
 
 	// Check if the configuration file exists
@@ -71,5 +130,30 @@ func validateConfig(config *Config) error {
 		return errors.New("invalid API timeout: must be greater than 0")
 	}
 
+	// Validate database query settings
+	if config.SlowQueryThreshold < 0 {
+		return errors.New("invalid slow query threshold: must not be negative")
+	}
+	if config.QueryTimeout < 0 {
+		return errors.New("invalid query timeout: must not be negative")
+	}
+
+	// Validate fault injection rules
+	for _, rule := range config.FaultInjection.Rules {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// Validate database pool settings
+	pool := config.DatabasePool
+	if pool.MaxOpenConns < 0 || pool.MaxIdleConns < 0 || pool.ConnMaxLifetime < 0 ||
+		pool.ConnMaxIdleTime < 0 || pool.HealthCheckInterval < 0 {
+		return errors.New("invalid database pool settings: values must not be negative")
+	}
+	if pool.MaxOpenConns > 0 && pool.MaxIdleConns > pool.MaxOpenConns {
+		return errors.New("invalid database pool settings: max_idle_conns must not exceed max_open_conns")
+	}
+
 	return nil
 }
@@ -0,0 +1,110 @@
+package dbops
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Actions recorded in the user audit trail
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	AuditImport  = "import"
+)
+
+// defaultActor is recorded when the context doesn't name who made a change
+const defaultActor = "system"
+
+// actorKey is the context key for the actor making changes
+type actorKey struct{}
+
+// WithActor returns a context that records actor as the author of any user
+// changes made with it
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor set by WithActor, or "system"
+func ActorFromContext(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return defaultActor
+}
+
+// FieldChange records the old and new value of a changed field
+type FieldChange struct {
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+// AuditEntry is one change recorded in the user audit trail
+type AuditEntry struct {
+	ID        int
+	UserID    int
+	Action    string
+	Actor     string
+	Changes   map[string]FieldChange
+	CreatedAt time.Time
+}
+
+// recordAudit writes an audit entry for a user change within tx
+func recordAudit(ctx context.Context, tx *Tx, userID int, action string, changes map[string]FieldChange) error {
+	var changesJSON interface{}
+	if len(changes) > 0 {
+		encoded, err := json.Marshal(changes)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode audit changes")
+		}
+		changesJSON = string(encoded)
+	}
+
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO user_audit (user_id, action, actor, changes) VALUES (?, ?, ?, ?)`,
+		userID, action, ActorFromContext(ctx), changesJSON)
+	if err != nil {
+		return wrapDBError("record audit", "user_audit", err)
+	}
+	return nil
+}
+
+// UserAuditTrail returns the recorded changes for a user, oldest first
+func UserAuditTrail(ctx context.Context, db *DB, userID int) ([]AuditEntry, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT id, user_id, action, actor, changes, created_at FROM user_audit WHERE user_id = ? ORDER BY id`,
+		userID)
+	if err != nil {
+		return nil, wrapDBError("query audit trail", "user_audit", err)
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		var changes sql.NullString
+		err := rows.Scan(&entry.ID, &entry.UserID, &entry.Action, &entry.Actor, &changes,
+			scanTimestamp("created_at", &entry.CreatedAt))
+		if err != nil {
+			return nil, wrapDBError("query audit trail", "user_audit", err)
+		}
+
+		if changes.Valid {
+			if err := json.Unmarshal([]byte(changes.String), &entry.Changes); err != nil {
+				return nil, wrapDBError("query audit trail", "user_audit",
+					&ScanError{Column: "changes", Raw: changes.String, Err: err})
+			}
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, wrapDBError("query audit trail", "user_audit", err)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,492 @@
+package dbops
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	apperrors "error-handling-demo/errors"
+	"error-handling-demo/models"
+)
+
+// DefaultChunkSize is the number of rows written per INSERT statement
+const DefaultChunkSize = 100
+
+// Errors reported by bulk operations
+var (
+	ErrUnknownFormat  = errors.New("unknown import/export format")
+	ErrImportRejected = errors.New("import rejected: one or more rows failed")
+)
+
+// BulkFormat is a file format understood by ImportUsers and ExportUsers
+type BulkFormat string
+
+// Supported bulk formats
+const (
+	FormatJSONLines BulkFormat = "jsonl"
+	FormatCSV       BulkFormat = "csv"
+)
+
+// csvHeader is the header row written by ExportUsers and expected by ImportUsers
+var csvHeader = []string{"id", "username", "email", "created_at"}
+
+// ImportOptions configures ImportUsers
+type ImportOptions struct {
+	Format    BulkFormat
+	ChunkSize int  // Rows per INSERT statement (defaults to DefaultChunkSize)
+	Upsert    bool // Update users whose ID already exists instead of rejecting them
+	// AllOrNothing rejects the whole import if any row is invalid or can't be
+	// written. Otherwise valid rows are committed and failures are reported.
+	AllOrNothing bool
+}
+
+// RowError describes why a single input row was rejected
+type RowError struct {
+	Line int // Line number in the input (1-based)
+	Err  error
+}
+
+// Error implements the error interface
+func (e *RowError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// Unwrap returns the reason the row was rejected
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// Details returns the individual problems with the row: one entry per
+// failed validation, or the error message for any other failure
+func (e *RowError) Details() []string {
+	var validationErr *apperrors.ValidationError
+	if errors.As(e.Err, &validationErr) && len(validationErr.Errors) > 0 {
+		details := make([]string, len(validationErr.Errors))
+		for i, err := range validationErr.Errors {
+			details[i] = err.Error()
+		}
+		return details
+	}
+	return []string{e.Err.Error()}
+}
+
+// ImportReport summarizes the result of ImportUsers
+type ImportReport struct {
+	Total    int // Number of data rows read
+	Imported int // Number of rows written to the database
+	Rejected []*RowError
+}
+
+// importRow is a parsed input row together with its position in the input
+type importRow struct {
+	line int
+	user *models.User
+}
+
+// ImportUsers reads users in the given format, validates each one with
+// models.User.Validate and writes the valid rows in a single transaction.
+// Rejected rows are listed in the report. In AllOrNothing mode nothing is
+// written if any row is rejected, and ErrImportRejected is returned.
+func ImportUsers(ctx context.Context, db *DB, r io.Reader, opts ImportOptions) (*ImportReport, error) {
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = DefaultChunkSize
+	}
+
+	// Parse the input
+	var rows []importRow
+	var rejected []*RowError
+	var err error
+	switch opts.Format {
+	case FormatJSONLines:
+		rows, rejected, err = parseJSONLines(r)
+	case FormatCSV:
+		rows, rejected, err = parseCSV(r)
+	default:
+		return nil, errors.Wrapf(ErrUnknownFormat, "%q", opts.Format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ImportReport{Total: len(rows) + len(rejected), Rejected: rejected}
+
+	// Validate every row before touching the database
+	valid := make([]importRow, 0, len(rows))
+	for _, row := range rows {
+		if err := row.user.Validate(); err != nil {
+			report.Rejected = append(report.Rejected, &RowError{Line: row.line, Err: err})
+			continue
+		}
+		valid = append(valid, row)
+	}
+
+	if opts.AllOrNothing && len(report.Rejected) > 0 {
+		sortRowErrors(report.Rejected)
+		return report, ErrImportRejected
+	}
+
+	// Write the valid rows in one transaction
+	var writeRejected []*RowError
+	err = ExecuteInTransaction(ctx, db, func(tx *Tx) error {
+		// The transaction may be retried, so start each attempt afresh
+		writeRejected = nil
+		for start := 0; start < len(valid); start += opts.ChunkSize {
+			end := start + opts.ChunkSize
+			if end > len(valid) {
+				end = len(valid)
+			}
+
+			failed, err := writeChunk(ctx, tx, valid[start:end], opts)
+			if err != nil {
+				return err
+			}
+			writeRejected = append(writeRejected, failed...)
+		}
+
+		if opts.AllOrNothing && len(writeRejected) > 0 {
+			return ErrImportRejected
+		}
+		return nil
+	})
+
+	report.Rejected = append(report.Rejected, writeRejected...)
+	sortRowErrors(report.Rejected)
+	if err != nil {
+		return report, err
+	}
+
+	report.Imported = len(valid) - len(writeRejected)
+	return report, nil
+}
+
+// writeChunk writes a chunk of rows with a single statement. If that fails
+// because of a problem with the data, each row is retried on its own so the
+// offending rows can be identified and reported.
+func writeChunk(ctx context.Context, tx *Tx, chunk []importRow, opts ImportOptions) ([]*RowError, error) {
+	err := WithSavepoint(ctx, tx, func(tx *Tx) error {
+		return insertUsers(ctx, tx, chunk, opts.Upsert)
+	})
+	if err == nil {
+		return nil, nil
+	}
+	if !isRowError(err) {
+		return nil, err
+	}
+	if len(chunk) == 1 {
+		return []*RowError{{Line: chunk[0].line, Err: err}}, nil
+	}
+
+	// Find the rows that caused the failure
+	var rejected []*RowError
+	for _, row := range chunk {
+		failed, err := writeChunk(ctx, tx, []importRow{row}, opts)
+		if err != nil {
+			return nil, err
+		}
+		rejected = append(rejected, failed...)
+	}
+	return rejected, nil
+}
+
+// isRowError reports whether err was caused by the data being written
+// (a constraint violation) rather than by the database itself
+func isRowError(err error) bool {
+	return errors.Is(err, ErrDuplicateUser) || errors.Is(err, ErrConstraintViolation)
+}
+
+// insertUsers writes rows with multi-row INSERT statements: one for rows
+// with an ID and one for rows whose ID the database should assign
+func insertUsers(ctx context.Context, tx *Tx, rows []importRow, upsert bool) error {
+	var withID, withoutID []importRow
+	for _, row := range rows {
+		if row.user.ID != 0 {
+			withID = append(withID, row)
+		} else {
+			withoutID = append(withoutID, row)
+		}
+	}
+
+	if len(withID) > 0 {
+		if err := insertUserRows(ctx, tx, withID, true, upsert); err != nil {
+			return err
+		}
+		if err := syncUserSequence(ctx, tx); err != nil {
+			return err
+		}
+	}
+	if len(withoutID) > 0 {
+		// Rows without an ID can't conflict on it, so there's nothing to upsert
+		if err := insertUserRows(ctx, tx, withoutID, false, false); err != nil {
+			return err
+		}
+	}
+
+	// Record an audit entry for every imported user. Usernames are unique, so
+	// they identify the rows even when the database assigned the IDs.
+	auditArgs := make([]interface{}, 0, len(rows)+1)
+	auditArgs = append(auditArgs, ActorFromContext(ctx))
+	for _, row := range rows {
+		auditArgs = append(auditArgs, row.user.Username)
+	}
+	auditSQL := `INSERT INTO user_audit (user_id, action, actor)
+		SELECT id, '` + AuditImport + `', ? FROM users WHERE username IN (` +
+		strings.TrimSuffix(strings.Repeat("?, ", len(rows)), ", ") + `)`
+	if _, err := tx.ExecContext(ctx, auditSQL, auditArgs...); err != nil {
+		return wrapDBError("record audit", "user_audit", err)
+	}
+
+	return nil
+}
+
+// insertUserRows writes rows with a single INSERT statement
+func insertUserRows(ctx context.Context, tx *Tx, rows []importRow, withID, upsert bool) error {
+	columns := "username, email"
+	rowPlaceholder := "(?, ?)"
+	if withID {
+		columns = "id, username, email"
+		rowPlaceholder = "(?, ?, ?)"
+	}
+
+	placeholders := make([]string, len(rows))
+	args := make([]interface{}, 0, len(rows)*3)
+	for i, row := range rows {
+		placeholders[i] = rowPlaceholder
+		if withID {
+			args = append(args, row.user.ID)
+		}
+		args = append(args, row.user.Username, row.user.Email)
+	}
+
+	query := `INSERT INTO users (` + columns + `) VALUES ` + strings.Join(placeholders, ", ")
+	if upsert {
+		query += tx.Dialect().UpsertClause("users", "id", []string{"username", "email"}) +
+			", version = users.version + 1"
+	}
+
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return wrapDBError("batch insert users", "users", err)
+	}
+	return nil
+}
+
+// syncUserSequence moves the users ID sequence past IDs inserted
+// explicitly, on dialects that need it
+func syncUserSequence(ctx context.Context, tx *Tx) error {
+	syncSQL := tx.Dialect().SyncSequence("users", "id")
+	if syncSQL == "" {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, syncSQL); err != nil {
+		return wrapDBError("sync id sequence", "users", err)
+	}
+	return nil
+}
+
+// BatchInsertUsers inserts (or with upsert, inserts or updates) users in a
+// single transaction, chunkSize rows per statement. Either all users are
+// written or none are.
+func BatchInsertUsers(ctx context.Context, db *DB, users []*models.User, chunkSize int, upsert bool) error {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	rows := make([]importRow, len(users))
+	for i, user := range users {
+		rows[i] = importRow{line: i + 1, user: user}
+	}
+
+	return ExecuteInTransaction(ctx, db, func(tx *Tx) error {
+		for start := 0; start < len(rows); start += chunkSize {
+			end := start + chunkSize
+			if end > len(rows) {
+				end = len(rows)
+			}
+			if err := insertUsers(ctx, tx, rows[start:end], upsert); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// parseJSONLines reads one JSON-encoded user per line. Blank lines are skipped.
+func parseJSONLines(r io.Reader) ([]importRow, []*RowError, error) {
+	var rows []importRow
+	var rejected []*RowError
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var user models.User
+		if err := json.Unmarshal([]byte(text), &user); err != nil {
+			rejected = append(rejected, &RowError{Line: line, Err: errors.Wrap(err, "invalid JSON")})
+			continue
+		}
+		rows = append(rows, importRow{line: line, user: &user})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to read input after line %d", line)
+	}
+
+	return rows, rejected, nil
+}
+
+// parseCSV reads users from CSV with a header row naming the columns.
+// The id and created_at columns are optional.
+func parseCSV(r io.Reader) ([]importRow, []*RowError, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1 // Report bad rows ourselves instead of aborting
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read CSV header")
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"username", "email"} {
+		if _, ok := columns[required]; !ok {
+			return nil, nil, errors.Errorf("CSV header is missing the %q column", required)
+		}
+	}
+
+	var rows []importRow
+	var rejected []*RowError
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				rejected = append(rejected, &RowError{Line: parseErr.StartLine, Err: err})
+				continue
+			}
+			return nil, nil, errors.Wrap(err, "failed to read CSV")
+		}
+		// Only valid after a successful Read
+		line, _ := reader.FieldPos(0)
+		if len(record) != len(header) {
+			rejected = append(rejected, &RowError{
+				Line: line,
+				Err:  errors.Errorf("expected %d fields, got %d", len(header), len(record)),
+			})
+			continue
+		}
+
+		user := &models.User{
+			Username: strings.TrimSpace(record[columns["username"]]),
+			Email:    strings.TrimSpace(record[columns["email"]]),
+		}
+		if i, ok := columns["id"]; ok && strings.TrimSpace(record[i]) != "" {
+			user.ID, err = strconv.Atoi(strings.TrimSpace(record[i]))
+			if err != nil {
+				rejected = append(rejected, &RowError{Line: line, Err: errors.Wrap(err, "invalid id")})
+				continue
+			}
+		}
+		rows = append(rows, importRow{line: line, user: user})
+	}
+
+	return rows, rejected, nil
+}
+
+// sortRowErrors orders row errors by line number
+func sortRowErrors(rowErrs []*RowError) {
+	sort.SliceStable(rowErrs, func(i, j int) bool {
+		return rowErrs[i].Line < rowErrs[j].Line
+	})
+}
+
+// ExportUsers writes every user to w in the given format and returns the
+// number of users written. Users are streamed rather than loaded at once.
+func ExportUsers(ctx context.Context, db *DB, w io.Writer, format BulkFormat) (int, error) {
+	var write func(*User) error
+	var flush func() error
+
+	switch format {
+	case FormatJSONLines:
+		encoder := json.NewEncoder(w)
+		write = func(user *User) error {
+			return encoder.Encode(models.User{
+				ID:        user.ID,
+				Username:  user.Username,
+				Email:     user.Email,
+				CreatedAt: user.CreatedAt,
+			})
+		}
+		flush = func() error { return nil }
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(csvHeader); err != nil {
+			return 0, errors.Wrap(err, "failed to write CSV header")
+		}
+		write = func(user *User) error {
+			return writer.Write([]string{
+				strconv.Itoa(user.ID),
+				user.Username,
+				user.Email,
+				user.CreatedAt.Format(time.RFC3339),
+			})
+		}
+		flush = func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+	default:
+		return 0, errors.Wrapf(ErrUnknownFormat, "%q", format)
+	}
+
+	count := 0
+	err := StreamUsers(ctx, db, ListOptions{}, func(user *User) error {
+		if err := write(user); err != nil {
+			return errors.Wrapf(err, "failed to write user %d", user.ID)
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+
+	if err := flush(); err != nil {
+		return count, errors.Wrap(err, "failed to flush export")
+	}
+
+	return count, nil
+}
+
+// FormatFromFileName guesses the bulk format from a file extension
+func FormatFromFileName(name string) (BulkFormat, error) {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".csv"):
+		return FormatCSV, nil
+	case strings.HasSuffix(lower, ".jsonl"), strings.HasSuffix(lower, ".ndjson"), strings.HasSuffix(lower, ".json"):
+		return FormatJSONLines, nil
+	default:
+		return "", errors.Wrapf(ErrUnknownFormat, "can't tell the format of %q", name)
+	}
+}
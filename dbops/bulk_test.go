@@ -0,0 +1,232 @@
+package dbops_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"error-handling-demo/dbops"
+	apperrors "error-handling-demo/errors"
+)
+
+// newBulkDB returns a migrated SQLite database in a temporary directory
+func newBulkDB(t *testing.T) *dbops.DB {
+	t.Helper()
+	db, err := dbops.InitDatabase(filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatalf("InitDatabase failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// storedUsers returns every live user as "id username email", in id order
+func storedUsers(t *testing.T, db *dbops.DB) []string {
+	t.Helper()
+	page, err := dbops.ListUsers(context.Background(), db, dbops.ListOptions{Limit: dbops.MaxPageSize})
+	if err != nil {
+		t.Fatalf("ListUsers failed: %v", err)
+	}
+	users := make([]string, len(page.Users))
+	for i, user := range page.Users {
+		users[i] = fmt.Sprintf("%d %s %s", user.ID, user.Username, user.Email)
+	}
+	return users
+}
+
+// rejectedLines returns the line numbers of the rejected rows
+func rejectedLines(report *dbops.ImportReport) []int {
+	lines := make([]int, len(report.Rejected))
+	for i, rowErr := range report.Rejected {
+		lines[i] = rowErr.Line
+	}
+	return lines
+}
+
+func TestImportUsersRejectsMalformedCSV(t *testing.T) {
+	db := newBulkDB(t)
+
+	// A parse error in the first field leaves no field positions to ask for
+	input := strings.Join([]string{
+		"username,email",
+		"alice,alice@example.com",
+		`"bob"x,bob@example.com`,
+		"carol,carol@example.com",
+		`"dave,dave@example.com`,
+	}, "\n") + "\n"
+
+	report, err := dbops.ImportUsers(context.Background(), db, strings.NewReader(input), dbops.ImportOptions{Format: dbops.FormatCSV})
+	if err != nil {
+		t.Fatalf("ImportUsers failed: %v", err)
+	}
+	if report.Imported != 2 || len(report.Rejected) != 2 {
+		t.Fatalf("imported %d, rejected %v; want 2 and the malformed rows", report.Imported, report.Rejected)
+	}
+	for i, line := range []int{3, 5} {
+		var parseErr *csv.ParseError
+		if rowErr := report.Rejected[i]; rowErr.Line != line || !errors.As(rowErr, &parseErr) {
+			t.Errorf("rejected %v, want a CSV parse error on line %d", rowErr, line)
+		}
+	}
+}
+
+func TestImportUsersJSONLines(t *testing.T) {
+	db := newBulkDB(t)
+	input := strings.Join([]string{
+		`{"id": 10, "username": "alice", "email": "alice@example.com"}`,
+		``,
+		`{"username": "bob", "email": "bob@example.com"}`,
+		`{"username": "carol", "email": `,
+		`{"username": "x", "email": "not an email"}`,
+		`{"id": 12, "username": "dave", "email": "dave@example.com"}`,
+	}, "\n")
+
+	report, err := dbops.ImportUsers(context.Background(), db, strings.NewReader(input), dbops.ImportOptions{Format: dbops.FormatJSONLines})
+	if err != nil {
+		t.Fatalf("ImportUsers failed: %v", err)
+	}
+	if report.Total != 5 || report.Imported != 3 || fmt.Sprint(rejectedLines(report)) != "[4 5]" {
+		t.Errorf("report = %d read, %d imported, rejected %v; want 5, 3, lines 4 and 5", report.Total, report.Imported, report.Rejected)
+	}
+
+	// The invalid user is rejected with every problem listed
+	var validationErr *apperrors.ValidationError
+	if len(report.Rejected) == 2 && (!errors.As(report.Rejected[1], &validationErr) || len(report.Rejected[1].Details()) != 2) {
+		t.Errorf("invalid user rejected with %v", report.Rejected[1].Details())
+	}
+
+	// Explicit IDs are kept; the others are assigned after them, so they
+	// don't collide
+	got := storedUsers(t, db)
+	if want := "[10 alice alice@example.com 12 dave dave@example.com 13 bob bob@example.com]"; fmt.Sprint(got) != want {
+		t.Errorf("stored %v, want %s", got, want)
+	}
+}
+
+func TestImportUsersAllOrNothing(t *testing.T) {
+	ctx := context.Background()
+	db := newBulkDB(t)
+	if _, err := dbops.ImportUsers(ctx, db, strings.NewReader("username,email\nalice,alice@example.com\n"), dbops.ImportOptions{Format: dbops.FormatCSV}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name, input string
+		line        int
+	}{
+		{"invalid row", "username,email\nbob,bob@example.com\ncarol,carol\n", 3},
+		// A duplicate is only found while writing, after bob was inserted
+		{"duplicate row", "username,email\nbob,bob@example.com\nalice,other@example.com\n", 3},
+	} {
+		report, err := dbops.ImportUsers(ctx, db, strings.NewReader(tc.input), dbops.ImportOptions{Format: dbops.FormatCSV, AllOrNothing: true})
+		if !errors.Is(err, dbops.ErrImportRejected) {
+			t.Errorf("%s: ImportUsers returned %v, want ErrImportRejected", tc.name, err)
+		}
+		if report == nil || report.Imported != 0 || fmt.Sprint(rejectedLines(report)) != fmt.Sprint([]int{tc.line}) {
+			t.Errorf("%s: report = %+v, want line %d rejected and nothing imported", tc.name, report, tc.line)
+		}
+		if got := storedUsers(t, db); len(got) != 1 {
+			t.Errorf("%s: a rejected import left %v", tc.name, got)
+		}
+	}
+}
+
+func TestImportUsersChunkRejections(t *testing.T) {
+	ctx := context.Background()
+	db := newBulkDB(t)
+	if _, err := dbops.ImportUsers(ctx, db, strings.NewReader("id,username,email\n5,alice,alice@example.com\n"), dbops.ImportOptions{Format: dbops.FormatCSV}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each chunk of three holds one row the database refuses
+	input := strings.Join([]string{
+		"id,username,email",
+		"1,bob,bob@example.com",
+		"2,alice,other@example.com", // Duplicate username
+		"3,carol,carol@example.com",
+		"4,dave,dave@example.com",
+		"5,erin,erin@example.com", // Duplicate ID
+		"6,frank,frank@example.com",
+	}, "\n")
+	report, err := dbops.ImportUsers(ctx, db, strings.NewReader(input), dbops.ImportOptions{Format: dbops.FormatCSV, ChunkSize: 3})
+	if err != nil {
+		t.Fatalf("ImportUsers failed: %v", err)
+	}
+	if report.Imported != 4 || fmt.Sprint(rejectedLines(report)) != "[3 6]" {
+		t.Fatalf("imported %d, rejected %v; want 4 and lines 3 and 6", report.Imported, report.Rejected)
+	}
+	if !errors.Is(report.Rejected[0], dbops.ErrDuplicateUser) || !errors.Is(report.Rejected[1], dbops.ErrConstraintViolation) {
+		t.Errorf("rejected with %v", report.Rejected)
+	}
+
+	got := storedUsers(t, db)
+	want := "[1 bob bob@example.com 3 carol carol@example.com 4 dave dave@example.com 5 alice alice@example.com 6 frank frank@example.com]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("stored %v, want %s", got, want)
+	}
+}
+
+func TestImportUsersUpsert(t *testing.T) {
+	ctx := context.Background()
+	db := newBulkDB(t)
+	opts := dbops.ImportOptions{Format: dbops.FormatCSV, Upsert: true}
+	if _, err := dbops.ImportUsers(ctx, db, strings.NewReader("id,username,email\n1,alice,alice@example.com\n2,bob,bob@example.com\n"), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := dbops.ImportUsers(ctx, db, strings.NewReader("id,username,email\n1,alice,alice@new.example.com\n3,carol,carol@example.com\n"), opts)
+	if err != nil || report.Imported != 2 || len(report.Rejected) != 0 {
+		t.Fatalf("upsert import = %+v, %v", report, err)
+	}
+
+	for _, tc := range []struct {
+		id      int
+		email   string
+		version int
+	}{
+		{1, "alice@new.example.com", 2},
+		{2, "bob@example.com", 1},
+		{3, "carol@example.com", 1},
+	} {
+		user, err := dbops.GetUser(ctx, db, tc.id)
+		if err != nil {
+			t.Fatalf("GetUser(%d) failed: %v", tc.id, err)
+		}
+		if user.Email != tc.email || user.Version != tc.version {
+			t.Errorf("user %d = %s version %d, want %s version %d", tc.id, user.Email, user.Version, tc.email, tc.version)
+		}
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	source := newBulkDB(t)
+	input := "id,username,email\n3,alice,alice@example.com\n7,\"bob, jr\",bob@example.com\n9,carol,carol@example.com\n"
+	if _, err := dbops.ImportUsers(ctx, source, strings.NewReader(input), dbops.ImportOptions{Format: dbops.FormatCSV}); err != nil {
+		t.Fatal(err)
+	}
+	want := storedUsers(t, source)
+
+	for _, format := range []dbops.BulkFormat{dbops.FormatCSV, dbops.FormatJSONLines} {
+		var buf bytes.Buffer
+		count, err := dbops.ExportUsers(ctx, source, &buf, format)
+		if err != nil || count != 3 {
+			t.Fatalf("%s: ExportUsers = %d, %v", format, count, err)
+		}
+
+		target := newBulkDB(t)
+		report, err := dbops.ImportUsers(ctx, target, &buf, dbops.ImportOptions{Format: format})
+		if err != nil || report.Imported != 3 || len(report.Rejected) != 0 {
+			t.Fatalf("%s: ImportUsers = %+v, %v", format, report, err)
+		}
+		if got := storedUsers(t, target); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s: round trip stored %v, want %v", format, got, want)
+		}
+	}
+}
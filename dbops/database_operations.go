@@ -3,16 +3,20 @@ package dbops
 import (
 	"context"
 	"database/sql"
-	"fmt"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3" // SQLite driver
 	"github.com/pkg/errors"
+
+	apperrors "error-handling-demo/errors"
+	"error-handling-demo/models"
 )
 
 // Custom errors for database operations
 var (
-	ErrUserNotFound      = errors.New("user not found")
+	ErrUserNotFound      = models.ErrUserNotFound // Same error as the UserRepository contract
+	ErrUserNotDeleted    = errors.New("user is not deleted")
+	ErrConflict          = errors.New("user was modified concurrently")
 	ErrDatabaseOperation = errors.New("database operation failed")
 )
 
@@ -22,25 +26,30 @@ type User struct {
 	Username  string
 	Email     string
 	CreatedAt time.Time
+	Version   int       // Incremented on every change, used for optimistic locking
+	DeletedAt time.Time // Zero unless the user has been soft-deleted
 }
 
-// InitDatabase initializes the database connection and sets up tables
-func InitDatabase(dbPath string) (*sql.DB, error) {
-	// Open the database connection
-	db, err := sql.Open("sqlite3", dbPath)
+// IsDeleted reports whether the user has been soft-deleted
+func (u *User) IsDeleted() bool {
+	return !u.DeletedAt.IsZero()
+}
+
+// InitDatabase initializes the database connection and applies any pending migrations.
+// dsn is a SQLite file path or a sqlite://, postgres:// or mysql:// URL, see ParseDSN.
+func InitDatabase(dsn string) (*DB, error) {
+	db, err := OpenDatabase(dsn)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to open database connection")
+		return nil, err
 	}
 
-	// Test the connection
-	if err := db.Ping(); err != nil {
-		// Make sure to close the database if we can't connect
+	// Bring the schema up to date
+	migrator, err := DefaultMigrator(db)
+	if err != nil {
 		db.Close()
-		return nil, errors.Wrap(err, "failed to ping database")
+		return nil, err
 	}
-
-	// Create tables if they don't exist
-	if err := createTables(db); err != nil {
+	if _, err := migrator.Up(context.Background()); err != nil {
 		db.Close()
 		return nil, err
 	}
@@ -48,217 +57,230 @@ func InitDatabase(dbPath string) (*sql.DB, error) {
 	return db, nil
 }
 
-// createTables creates the necessary tables in the database
-func createTables(db *sql.DB) error {
-	// Define the users table
-	createUsersTableSQL := `
-	CREATE TABLE IF NOT EXISTS users (
-		id INTEGER PRIMARY KEY,
-		username TEXT NOT NULL UNIQUE,
-		email TEXT NOT NULL,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);`
-
-	// Execute the table creation statement
-	_, err := db.Exec(createUsersTableSQL)
+// OpenDatabase opens and verifies the database connection without touching the schema
+func OpenDatabase(dsn string) (*DB, error) {
+	// Work out which database we are talking to
+	dialect, driverDSN, err := ParseDSN(dsn)
 	if err != nil {
-		return errors.Wrap(err, "failed to create users table")
+		return nil, err
 	}
 
-	return nil
-}
-
-// InsertUser inserts a new user into the database with transaction support
-func InsertUser(ctx context.Context, db *sql.DB, user struct {
-	ID       int
-	Username string
-	Email    string
-}) error {
-	// Start a transaction
-	tx, err := db.BeginTx(ctx, nil)
+	// Open the database connection
+	sqlDB, err := sql.Open(dialect.DriverName(), driverDSN)
 	if err != nil {
-		return errors.Wrap(err, "failed to begin transaction")
+		return nil, wrapDBError("open", "", err)
 	}
 
-	// Defer a rollback in case anything fails
-	// If the transaction completes successfully, this rollback will be a no-op
-	defer tx.Rollback()
+	db := NewDB(sqlDB, dialect)
+	db.driverDSN = driverDSN
 
-	// Insert the user
-	insertSQL := `INSERT INTO users (id, username, email) VALUES (?, ?, ?)`
-	_, err = tx.ExecContext(ctx, insertSQL, user.ID, user.Username, user.Email)
-	if err != nil {
-		return errors.Wrap(err, "failed to insert user")
+	// Every connection to an in-memory SQLite database gets its own empty
+	// database, so all work has to go through a single connection
+	if dialect.Name() == "sqlite" && isSQLiteMemory(driverDSN) {
+		db.useSingleConnection()
 	}
 
-	// Commit the transaction
-	if err := tx.Commit(); err != nil {
-		return errors.Wrap(err, "failed to commit transaction")
+	// Test the connection
+	if err := db.Ping(); err != nil {
+		// Make sure to close the database if we can't connect
+		db.Close()
+		return nil, wrapDBError("ping", "", err)
 	}
 
-	return nil
+	return db, nil
+}
+
+// InsertUser inserts a new user into the database with transaction support
+func InsertUser(ctx context.Context, db *DB, user struct {
+	ID       int
+	Username string
+	Email    string
+}) error {
+	// Run the insert in a transaction that is retried if the database is busy
+	return ExecuteInTransaction(ctx, db, func(tx *Tx) error {
+		// Insert the user
+		// A duplicate username is reported as a DatabaseError matching ErrDuplicateUser
+		insertSQL := `INSERT INTO users (id, username, email) VALUES (?, ?, ?)`
+		_, err := tx.ExecContext(ctx, insertSQL, user.ID, user.Username, user.Email)
+		if err != nil {
+			return wrapDBError("insert user", "users", err)
+		}
+		if err := syncUserSequence(ctx, tx); err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, user.ID, AuditCreate, map[string]FieldChange{
+			"username": {To: user.Username},
+			"email":    {To: user.Email},
+		})
+	})
 }
 
-// GetUser retrieves a user from the database by ID
-func GetUser(ctx context.Context, db *sql.DB, id int) (*User, error) {
+// GetUser retrieves a user from the database by ID.
+// Soft-deleted users are reported as ErrUserNotFound.
+func GetUser(ctx context.Context, db *DB, id int) (*User, error) {
 	// Create a context with timeout for the query
-	queryCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	queryCtx, cancel := context.WithTimeout(ctx, db.queryTimeout)
 	defer cancel()
 
 	// Query the user
-	row := db.QueryRowContext(queryCtx, `SELECT id, username, email, created_at FROM users WHERE id = ?`, id)
+	row := db.QueryRowContext(queryCtx,
+		`SELECT `+userColumns+` FROM users WHERE id = ? AND deleted_at IS NULL`, id)
 
 	// Scan the result into a User struct
-	var user User
-	var createdAtStr string
-	err := row.Scan(&user.ID, &user.Username, &user.Email, &createdAtStr)
+	// A created_at value that can't be parsed is reported as a *ScanError
+	user, err := scanUser(row)
 	if err != nil {
 		// Check for no rows error
 		if err == sql.ErrNoRows {
 			return nil, ErrUserNotFound
 		}
-		return nil, errors.Wrap(err, "failed to scan user row")
+		return nil, wrapDBError("get user", "users", err)
 	}
 
-	// Parse the created_at timestamp
-	user.CreatedAt, err = time.Parse("2006-01-02 15:04:05", createdAtStr)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to parse created_at timestamp")
-	}
-
-	return &user, nil
+	return user, nil
 }
 
-// UpdateUser updates an existing user in the database
-func UpdateUser(ctx context.Context, db *sql.DB, user User) error {
-	// Start a transaction
-	tx, err := db.BeginTx(ctx, nil)
-	if err != nil {
-		return errors.Wrap(err, "failed to begin transaction")
-	}
-	defer tx.Rollback()
-
-	// First check if the user exists
-	var exists bool
-	err = tx.QueryRowContext(ctx, "SELECT 1 FROM users WHERE id = ?", user.ID).Scan(&exists)
-	if err != nil {
-		if err == sql.ErrNoRows {
+// UpdateUser updates an existing user in the database using optimistic
+// locking: user.Version must match the stored version, otherwise the user
+// was changed by someone else since it was read and ErrConflict is returned.
+// On success the stored version is incremented; reload the user with
+// GetUser before updating it again.
+func UpdateUser(ctx context.Context, db *DB, user User) error {
+	return ExecuteInTransaction(ctx, db, func(tx *Tx) error {
+		// First load the current state of the user
+		current, err := scanUser(tx.QueryRowContext(ctx,
+			`SELECT `+userColumns+` FROM users WHERE id = ?`, user.ID))
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrUserNotFound
+			}
+			return wrapDBError("check user exists", "users", err)
+		}
+		if current.IsDeleted() {
 			return ErrUserNotFound
 		}
-		return errors.Wrap(err, "failed to check if user exists")
-	}
-
-	// Update the user
-	updateSQL := `UPDATE users SET username = ?, email = ? WHERE id = ?`
-	result, err := tx.ExecContext(ctx, updateSQL, user.Username, user.Email, user.ID)
-	if err != nil {
-		return errors.Wrap(err, "failed to update user")
-	}
+		if current.Version != user.Version {
+			return conflictError("update user", user.ID, user.Version)
+		}
 
-	// Check if any rows were affected
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return errors.Wrap(err, "failed to get rows affected")
-	}
-	if rowsAffected == 0 {
-		return ErrUserNotFound
-	}
+		// Update the user, guarding against a concurrent change since we read it
+		updateSQL := `UPDATE users SET username = ?, email = ?, version = version + 1
+			WHERE id = ? AND version = ? AND deleted_at IS NULL`
+		result, err := tx.ExecContext(ctx, updateSQL, user.Username, user.Email, user.ID, user.Version)
+		if err != nil {
+			return wrapDBError("update user", "users", err)
+		}
 
-	// Commit the transaction
-	if err := tx.Commit(); err != nil {
-		return errors.Wrap(err, "failed to commit transaction")
-	}
+		// Check if any rows were affected
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return errors.Wrap(err, "failed to get rows affected")
+		}
+		if rowsAffected == 0 {
+			return conflictError("update user", user.ID, user.Version)
+		}
 
-	return nil
+		// Record what changed
+		changes := map[string]FieldChange{
+			"version": {From: current.Version, To: current.Version + 1},
+		}
+		if current.Username != user.Username {
+			changes["username"] = FieldChange{From: current.Username, To: user.Username}
+		}
+		if current.Email != user.Email {
+			changes["email"] = FieldChange{From: current.Email, To: user.Email}
+		}
+		return recordAudit(ctx, tx, user.ID, AuditUpdate, changes)
+	})
 }
 
-// DeleteUser deletes a user from the database
-func DeleteUser(ctx context.Context, db *sql.DB, id int) error {
-	// Execute the delete with context
-	result, err := db.ExecContext(ctx, `DELETE FROM users WHERE id = ?`, id)
-	if err != nil {
-		return errors.Wrap(err, "failed to delete user")
-	}
-
-	// Check if any rows were affected
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return errors.Wrap(err, "failed to get rows affected")
-	}
-	if rowsAffected == 0 {
-		return ErrUserNotFound
-	}
-
-	return nil
+// conflictError reports an optimistic locking failure
+func conflictError(op string, id, expected int) error {
+	err := apperrors.NewDatabaseError(op, "users",
+		errors.Errorf("user %d is no longer at version %d", id, expected))
+	err.Reason = apperrors.ReasonConflict
+	err.Sentinel = ErrConflict
+	return err
 }
 
-// QueryUsersWithCancellation demonstrates query cancellation with context
-func QueryUsersWithCancellation(ctx context.Context, db *sql.DB) ([]*User, error) {
-	// Execute query with context
-	rows, err := db.QueryContext(ctx, `SELECT id, username, email, created_at FROM users`)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to query users")
-	}
-	defer rows.Close()
-
-	// Collect users
-	var users []*User
-	for rows.Next() {
-		// Check for context cancellation periodically
-		select {
-		case <-ctx.Done():
-			return nil, errors.Wrap(ctx.Err(), "context cancelled during user query")
-		default:
-			// Continue processing
+// DeleteUser soft-deletes a user: the row is kept with deleted_at set so it
+// can be brought back with RestoreUser
+func DeleteUser(ctx context.Context, db *DB, id int) error {
+	return ExecuteInTransaction(ctx, db, func(tx *Tx) error {
+		// Mark the user as deleted
+		result, err := tx.ExecContext(ctx,
+			`UPDATE users SET deleted_at = CURRENT_TIMESTAMP, version = version + 1
+			WHERE id = ? AND deleted_at IS NULL`, id)
+		if err != nil {
+			return wrapDBError("delete user", "users", err)
 		}
 
-		var user User
-		var createdAtStr string
-		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &createdAtStr); err != nil {
-			return nil, errors.Wrap(err, "failed to scan user row")
+		// Check if any rows were affected
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return errors.Wrap(err, "failed to get rows affected")
+		}
+		if rowsAffected == 0 {
+			return ErrUserNotFound
 		}
 
-		// Parse created_at timestamp
-		user.CreatedAt, err = time.Parse("2006-01-02 15:04:05", createdAtStr)
+		return recordAudit(ctx, tx, id, AuditDelete, nil)
+	})
+}
+
+// RestoreUser undoes a soft delete. It returns ErrUserNotFound if the user
+// doesn't exist and ErrUserNotDeleted if it was never deleted.
+func RestoreUser(ctx context.Context, db *DB, id int) error {
+	return ExecuteInTransaction(ctx, db, func(tx *Tx) error {
+		result, err := tx.ExecContext(ctx,
+			`UPDATE users SET deleted_at = NULL, version = version + 1
+			WHERE id = ? AND deleted_at IS NOT NULL`, id)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to parse created_at timestamp")
+			return wrapDBError("restore user", "users", err)
 		}
 
-		users = append(users, &user)
-	}
-
-	// Check for errors from iterating over rows
-	if err := rows.Err(); err != nil {
-		return nil, errors.Wrap(err, "error iterating over user rows")
-	}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return errors.Wrap(err, "failed to get rows affected")
+		}
+		if rowsAffected == 0 {
+			// Find out whether the user is missing or simply not deleted
+			var exists int
+			err := tx.QueryRowContext(ctx, `SELECT 1 FROM users WHERE id = ?`, id).Scan(&exists)
+			if err == sql.ErrNoRows {
+				return ErrUserNotFound
+			}
+			if err != nil {
+				return wrapDBError("restore user", "users", err)
+			}
+			return ErrUserNotDeleted
+		}
 
-	return users, nil
+		return recordAudit(ctx, tx, id, AuditRestore, nil)
+	})
 }
 
-// ExecuteInTransaction executes a function within a database transaction
-func ExecuteInTransaction(ctx context.Context, db *sql.DB, fn func(*sql.Tx) error) error {
-	// Start a transaction
-	tx, err := db.BeginTx(ctx, nil)
+// QueryUsersWithCancellation demonstrates query cancellation with context.
+// It loads every user into memory; use ListUsers or StreamUsers for large tables.
+func QueryUsersWithCancellation(ctx context.Context, db *DB) ([]*User, error) {
+	// Collect users, checking for context cancellation on every row
+	var users []*User
+	err := StreamUsers(ctx, db, ListOptions{}, func(user *User) error {
+		users = append(users, user)
+		return nil
+	})
 	if err != nil {
-		return errors.Wrap(err, "failed to begin transaction")
-	}
-
-	// Defer a rollback in case anything fails
-	defer func() {
-		// If the transaction was already committed, this will be a no-op
-		tx.Rollback()
-	}()
-
-	// Execute the function
-	if err := fn(tx); err != nil {
-		// Transaction will be rolled back by the deferred function
-		return err
+		return nil, errors.Wrap(err, "failed to query users")
 	}
 
-	// Commit the transaction
-	if err := tx.Commit(); err != nil {
-		return errors.Wrap(err, "failed to commit transaction")
-	}
+	return users, nil
+}
 
-	return nil
+// ExecuteInTransaction executes a function within a database transaction.
+// Busy and locked errors cause the whole function to be retried using
+// DefaultTxOptions; use RunInTransaction to control isolation, read-only
+// mode and retry behavior.
+func ExecuteInTransaction(ctx context.Context, db *DB, fn func(*Tx) error) error {
+	return RunInTransaction(ctx, db, DefaultTxOptions(), fn)
 }
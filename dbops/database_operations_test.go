@@ -0,0 +1,186 @@
+package dbops
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	apperrors "error-handling-demo/errors"
+)
+
+// insertTestUser stores a user with InsertUser
+func insertTestUser(t *testing.T, ctx context.Context, db *DB, id int, username string) {
+	t.Helper()
+	err := InsertUser(ctx, db, struct {
+		ID       int
+		Username string
+		Email    string
+	}{id, username, username + "@example.com"})
+	if err != nil {
+		t.Fatalf("InsertUser failed: %v", err)
+	}
+}
+
+func TestUpdateUserVersion(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	insertTestUser(t, ctx, db, 1, "jane")
+
+	user, err := GetUser(ctx, db, 1)
+	if err != nil || user.Version != 1 {
+		t.Fatalf("GetUser = %+v, %v; want version 1", user, err)
+	}
+
+	// Two writers read version 1; the first one wins
+	first, second := *user, *user
+	first.Email = "jane@first.example.com"
+	second.Email = "jane@second.example.com"
+	if err := UpdateUser(ctx, db, first); err != nil {
+		t.Fatalf("UpdateUser failed: %v", err)
+	}
+	err = UpdateUser(ctx, db, second)
+	var dbErr *apperrors.DatabaseError
+	if !errors.Is(err, ErrConflict) || !errors.As(err, &dbErr) || dbErr.Reason != apperrors.ReasonConflict {
+		t.Errorf("UpdateUser with a stale version returned %v, want ErrConflict", err)
+	}
+
+	stored, err := GetUser(ctx, db, 1)
+	if err != nil || stored.Email != first.Email || stored.Version != 2 {
+		t.Errorf("stored user = %+v, %v; want the first update at version 2", stored, err)
+	}
+
+	// Reloading gets the current version and the update goes through
+	second.Version = stored.Version
+	if err := UpdateUser(ctx, db, second); err != nil {
+		t.Errorf("UpdateUser with a fresh version failed: %v", err)
+	}
+
+	if err := UpdateUser(ctx, db, User{ID: 42, Username: "nobody", Email: "nobody@example.com", Version: 1}); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("UpdateUser of a missing user returned %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestDeleteAndRestoreUser(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	insertTestUser(t, ctx, db, 1, "jane")
+	insertTestUser(t, ctx, db, 2, "john")
+
+	if err := DeleteUser(ctx, db, 1); err != nil {
+		t.Fatalf("DeleteUser failed: %v", err)
+	}
+
+	// A deleted user is gone for readers and writers
+	if _, err := GetUser(ctx, db, 1); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("GetUser of a deleted user returned %v", err)
+	}
+	page, err := ListUsers(ctx, db, ListOptions{})
+	if err != nil || !equalIDs(userIDs(page.Users), []int{2}) {
+		t.Errorf("ListUsers after DeleteUser = %v, %v; want [2]", userIDs(page.Users), err)
+	}
+	if err := UpdateUser(ctx, db, User{ID: 1, Username: "jane", Email: "jane@example.com", Version: 2}); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("UpdateUser of a deleted user returned %v", err)
+	}
+	if err := DeleteUser(ctx, db, 1); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("deleting twice returned %v", err)
+	}
+
+	// The row is kept, so the username stays taken
+	insertErr := InsertUser(ctx, db, struct {
+		ID       int
+		Username string
+		Email    string
+	}{3, "jane", "other@example.com"})
+	if !errors.Is(insertErr, ErrDuplicateUser) {
+		t.Errorf("reusing a deleted user's name returned %v, want ErrDuplicateUser", insertErr)
+	}
+
+	// Restoring brings the user back with a new version
+	if err := RestoreUser(ctx, db, 1); err != nil {
+		t.Fatalf("RestoreUser failed: %v", err)
+	}
+	user, err := GetUser(ctx, db, 1)
+	if err != nil || user.IsDeleted() || user.Version != 3 {
+		t.Errorf("GetUser after RestoreUser = %+v, %v; want version 3", user, err)
+	}
+	page, err = ListUsers(ctx, db, ListOptions{})
+	if err != nil || !equalIDs(userIDs(page.Users), []int{1, 2}) {
+		t.Errorf("ListUsers after RestoreUser = %v, %v; want [1 2]", userIDs(page.Users), err)
+	}
+
+	if err := RestoreUser(ctx, db, 1); !errors.Is(err, ErrUserNotDeleted) {
+		t.Errorf("restoring a live user returned %v, want ErrUserNotDeleted", err)
+	}
+	if err := RestoreUser(ctx, db, 42); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("restoring a missing user returned %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestUserAuditTrail(t *testing.T) {
+	db := openTestDB(t)
+	ctx := WithActor(context.Background(), "alice")
+	insertTestUser(t, ctx, db, 1, "jane")
+	insertTestUser(t, context.Background(), db, 2, "john")
+
+	user, err := GetUser(ctx, db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Email = "jane@new.example.com"
+	if err := UpdateUser(WithActor(ctx, "bob"), db, *user); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteUser(WithActor(ctx, "carol"), db, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := RestoreUser(ctx, db, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed change leaves no trace
+	if err := UpdateUser(ctx, db, *user); !errors.Is(err, ErrConflict) {
+		t.Fatalf("stale UpdateUser returned %v", err)
+	}
+
+	entries, err := UserAuditTrail(ctx, db, 1)
+	if err != nil {
+		t.Fatalf("UserAuditTrail failed: %v", err)
+	}
+	want := []struct{ action, actor string }{
+		{AuditCreate, "alice"},
+		{AuditUpdate, "bob"},
+		{AuditDelete, "carol"},
+		{AuditRestore, "alice"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("audit trail has %d entries, want %d: %+v", len(entries), len(want), entries)
+	}
+	for i, w := range want {
+		if e := entries[i]; e.UserID != 1 || e.Action != w.action || e.Actor != w.actor || e.CreatedAt.IsZero() {
+			t.Errorf("entry %d = %+v, want %s by %s", i, e, w.action, w.actor)
+		}
+	}
+
+	// Creating and updating record the changed fields
+	if got := entries[0].Changes["username"]; got.From != nil || got.To != "jane" {
+		t.Errorf("create recorded username change %+v", got)
+	}
+	update := entries[1].Changes
+	if len(update) != 2 || update["email"].From != "jane@example.com" || update["email"].To != "jane@new.example.com" ||
+		update["version"].From != 1.0 || update["version"].To != 2.0 {
+		t.Errorf("update recorded %+v", update)
+	}
+	if entries[2].Changes != nil || entries[3].Changes != nil {
+		t.Errorf("delete and restore recorded changes %+v, %+v", entries[2].Changes, entries[3].Changes)
+	}
+
+	// Without an actor the change is the system's
+	entries, err = UserAuditTrail(ctx, db, 2)
+	if err != nil || len(entries) != 1 || entries[0].Actor != "system" {
+		t.Errorf("audit trail of user 2 = %+v, %v; want one entry by system", entries, err)
+	}
+	if entries, err := UserAuditTrail(ctx, db, 42); err != nil || len(entries) != 0 {
+		t.Errorf("audit trail of a missing user = %+v, %v", entries, err)
+	}
+}
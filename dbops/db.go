@@ -0,0 +1,187 @@
+package dbops
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// DB is a database connection pool bound to a dialect. Queries passed to its
+// methods are written with ? placeholders and rewritten for the dialect, so
+// the same SQL works against every supported database.
+//
+// Every statement is timed and counted, see Instrument and QueryStats, and a
+// failing statement returns a *QueryError naming the query and its duration.
+type DB struct {
+	*sql.DB
+	dialect      Dialect
+	obs          *observer
+	queryTimeout time.Duration
+
+	// driverDSN is what OpenDatabase passed to the driver, empty for a DB
+	// made with NewDB
+	driverDSN string
+
+	// singleConn is set for in-memory SQLite databases, which must not be
+	// spread over several connections
+	singleConn bool
+}
+
+// NewDB wraps an already opened connection pool for use with dialect
+func NewDB(db *sql.DB, dialect Dialect) *DB {
+	return &DB{DB: db, dialect: dialect, obs: newObserver(), queryTimeout: DefaultQueryTimeout}
+}
+
+// Dialect returns the dialect the database was opened with
+func (db *DB) Dialect() Dialect {
+	return db.dialect
+}
+
+// ExecContext executes a query that doesn't return rows
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	if err := injectFault(ctx, query); err != nil {
+		return nil, db.obs.observe(query, args, start, err)
+	}
+	result, err := db.DB.ExecContext(ctx, db.dialect.Rebind(query), args...)
+	return result, db.obs.observe(query, args, start, err)
+}
+
+// Exec executes a query that doesn't return rows
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.ExecContext(context.Background(), query, args...)
+}
+
+// QueryContext executes a query that returns rows
+func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	if err := injectFault(ctx, query); err != nil {
+		return nil, db.obs.observe(query, args, start, err)
+	}
+	rows, err := db.DB.QueryContext(ctx, db.dialect.Rebind(query), args...)
+	return rows, db.obs.observe(query, args, start, err)
+}
+
+// Query executes a query that returns rows
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.QueryContext(context.Background(), query, args...)
+}
+
+// QueryRowContext executes a query that is expected to return at most one row
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	start := time.Now()
+	if err := injectFault(ctx, query); err != nil {
+		return &Row{err: db.obs.observe(query, args, start, err), query: query, start: start}
+	}
+	row := db.DB.QueryRowContext(ctx, db.dialect.Rebind(query), args...)
+	return &Row{row: row, err: db.obs.observe(query, args, start, row.Err()), query: query, start: start}
+}
+
+// QueryRow executes a query that is expected to return at most one row
+func (db *DB) QueryRow(query string, args ...interface{}) *Row {
+	return db.QueryRowContext(context.Background(), query, args...)
+}
+
+// BeginTx starts a transaction whose statements are rewritten for the dialect
+func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	if err := injectFault(ctx, "BEGIN"); err != nil {
+		return nil, err
+	}
+	tx, err := db.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, dialect: db.dialect, obs: db.obs}, nil
+}
+
+// Begin starts a transaction whose statements are rewritten for the dialect
+func (db *DB) Begin() (*Tx, error) {
+	return db.BeginTx(context.Background(), nil)
+}
+
+// Tx is a transaction bound to a dialect, see DB
+type Tx struct {
+	*sql.Tx
+	dialect Dialect
+	obs     *observer
+}
+
+// Dialect returns the dialect of the database the transaction belongs to
+func (tx *Tx) Dialect() Dialect {
+	return tx.dialect
+}
+
+// ExecContext executes a query that doesn't return rows
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	if err := injectFault(ctx, query); err != nil {
+		return nil, tx.obs.observe(query, args, start, err)
+	}
+	result, err := tx.Tx.ExecContext(ctx, tx.dialect.Rebind(query), args...)
+	return result, tx.obs.observe(query, args, start, err)
+}
+
+// Exec executes a query that doesn't return rows
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.ExecContext(context.Background(), query, args...)
+}
+
+// QueryContext executes a query that returns rows
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	if err := injectFault(ctx, query); err != nil {
+		return nil, tx.obs.observe(query, args, start, err)
+	}
+	rows, err := tx.Tx.QueryContext(ctx, tx.dialect.Rebind(query), args...)
+	return rows, tx.obs.observe(query, args, start, err)
+}
+
+// Query executes a query that returns rows
+func (tx *Tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.QueryContext(context.Background(), query, args...)
+}
+
+// QueryRowContext executes a query that is expected to return at most one row
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	start := time.Now()
+	if err := injectFault(ctx, query); err != nil {
+		return &Row{err: tx.obs.observe(query, args, start, err), query: query, start: start}
+	}
+	row := tx.Tx.QueryRowContext(ctx, tx.dialect.Rebind(query), args...)
+	return &Row{row: row, err: tx.obs.observe(query, args, start, row.Err()), query: query, start: start}
+}
+
+// QueryRow executes a query that is expected to return at most one row
+func (tx *Tx) QueryRow(query string, args ...interface{}) *Row {
+	return tx.QueryRowContext(context.Background(), query, args...)
+}
+
+// Row is the result of QueryRow, see sql.Row. Scan returns sql.ErrNoRows
+// as it is and any other error as a *QueryError.
+type Row struct {
+	row   *sql.Row // nil if the query never ran because of an injected fault
+	err   error    // The error of the query itself, already a *QueryError
+	query string
+	start time.Time
+}
+
+// Scan copies the columns of the row into dest
+func (r *Row) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+
+	err := r.row.Scan(dest...)
+	if err == nil || err == sql.ErrNoRows {
+		return err
+	}
+
+	// A value that doesn't fit dest is reported against the query that produced it
+	_, table := describeStatement(r.query)
+	return &QueryError{Query: r.query, Table: table, Duration: time.Since(r.start), Err: err}
+}
+
+// Err returns the error of the query, if any
+func (r *Row) Err() error {
+	return r.err
+}
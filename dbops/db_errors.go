@@ -0,0 +1,79 @@
+package dbops
+
+import (
+	"github.com/pkg/errors"
+
+	apperrors "error-handling-demo/errors"
+	"error-handling-demo/models"
+)
+
+// Sentinel errors for well-known database failures.
+// Callers can match these with errors.Is while still using errors.As
+// to get at the *errors.DatabaseError (and the driver error beneath it).
+var (
+	ErrDuplicateUser        = models.ErrDuplicateUser // Same error as the UserRepository contract
+	ErrConstraintViolation  = errors.New("constraint violation")
+	ErrDatabaseBusy         = errors.New("database is busy")
+	ErrDatabaseLocked       = errors.New("database table is locked")
+	ErrDatabaseReadOnly     = errors.New("database is read-only")
+	ErrDatabaseCorrupt      = errors.New("database is corrupt")
+	ErrDatabaseFull         = errors.New("database or disk is full")
+	ErrSerializationFailure = errors.New("transaction could not be serialized")
+)
+
+// wrapDBError converts an error returned by a database driver into a typed
+// *errors.DatabaseError, using the dialect that recognizes the error to fill
+// in the reason and the *QueryError beneath it to fill in the statement and
+// its duration. Errors that are not driver errors are wrapped with
+// ReasonUnknown so every failure leaving dbops has the same shape.
+func wrapDBError(operation, table string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	// Don't wrap an error that has already been classified
+	var dbErr *apperrors.DatabaseError
+	if errors.As(err, &dbErr) {
+		return err
+	}
+
+	result := apperrors.NewDatabaseError(operation, table, err)
+	if class, ok := classifyDriverError(err); ok {
+		result.Reason = class.Reason
+		result.Sentinel = class.Sentinel
+		result.Retriable = class.Retriable
+	}
+
+	// Record which statement failed and how long it took
+	var queryErr *QueryError
+	if errors.As(err, &queryErr) {
+		result.Query = queryErr.Query
+		result.Duration = queryErr.Duration
+		if result.Table == "" {
+			result.Table = queryErr.Table
+		}
+	}
+
+	return result
+}
+
+// classifyDriverError asks each dialect to recognize err, after checking
+// for a failure injected by package faults
+func classifyDriverError(err error) (ErrorClass, bool) {
+	if class, ok := classifyFault(err); ok {
+		return class, true
+	}
+	for _, dialect := range dialects {
+		if class, ok := dialect.ClassifyError(err); ok {
+			return class, true
+		}
+	}
+	return ErrorClass{}, false
+}
+
+// IsRetriable reports whether err is a database error that may succeed if
+// the operation is attempted again, such as SQLITE_BUSY or a serialization failure
+func IsRetriable(err error) bool {
+	var dbErr *apperrors.DatabaseError
+	return errors.As(err, &dbErr) && dbErr.IsRetriable()
+}
@@ -0,0 +1,91 @@
+package dbops
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+
+	apperrors "error-handling-demo/errors"
+)
+
+// sqliteErrors returns the errors SQLite reports for a duplicate username,
+// a duplicate id, a missing email and a dangling foreign key
+func sqliteErrors(t *testing.T) (duplicateUser, duplicateID, notNull, foreignKey error) {
+	db, err := sql.Open("sqlite3", ":memory:?_foreign_keys=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	for _, stmt := range []string{
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, username TEXT NOT NULL UNIQUE, email TEXT NOT NULL)",
+		"CREATE TABLE orders (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL REFERENCES users (id))",
+		"INSERT INTO users (id, username, email) VALUES (1, 'jane', 'jane@example.com')",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exec := func(query string) error {
+		_, err := db.Exec(query)
+		if err == nil {
+			t.Fatalf("%s succeeded", query)
+		}
+		return err
+	}
+	return exec("INSERT INTO users (username, email) VALUES ('jane', 'other@example.com')"),
+		exec("INSERT INTO users (id, username, email) VALUES (1, 'john', 'john@example.com')"),
+		exec("INSERT INTO users (username) VALUES ('john')"),
+		exec("INSERT INTO orders (user_id) VALUES (42)")
+}
+
+func TestClassifySQLiteError(t *testing.T) {
+	duplicateUser, duplicateID, notNull, foreignKey := sqliteErrors(t)
+	for _, c := range []classifyCase{
+		{"busy", sqlite3.Error{Code: sqlite3.ErrBusy}, apperrors.ReasonBusy, ErrDatabaseBusy, true},
+		{"locked", sqlite3.Error{Code: sqlite3.ErrLocked}, apperrors.ReasonLocked, ErrDatabaseLocked, true},
+		{"read only", sqlite3.Error{Code: sqlite3.ErrReadonly}, apperrors.ReasonReadOnly, ErrDatabaseReadOnly, false},
+		{"corrupt", sqlite3.Error{Code: sqlite3.ErrCorrupt}, apperrors.ReasonCorrupt, ErrDatabaseCorrupt, false},
+		{"not a database", sqlite3.Error{Code: sqlite3.ErrNotADB}, apperrors.ReasonCorrupt, ErrDatabaseCorrupt, false},
+		{"disk full", sqlite3.Error{Code: sqlite3.ErrFull}, apperrors.ReasonFull, ErrDatabaseFull, false},
+		{"duplicate username", duplicateUser, apperrors.ReasonUniqueViolation, ErrDuplicateUser, false},
+		{"duplicate id", duplicateID, apperrors.ReasonUniqueViolation, ErrConstraintViolation, false},
+		{"not null", notNull, apperrors.ReasonNotNullViolation, ErrConstraintViolation, false},
+		{"foreign key", foreignKey, apperrors.ReasonForeignKeyViolation, ErrConstraintViolation, false},
+		{"check", sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintCheck}, apperrors.ReasonConstraint, ErrConstraintViolation, false},
+		{"other", sqlite3.Error{Code: sqlite3.ErrError}, apperrors.ReasonUnknown, nil, false},
+	} {
+		// The driver error arrives wrapped, as it does from DB and Tx
+		err := wrapDBError("insert", "users", errors.Wrap(c.err, "query failed"))
+
+		var dbErr *apperrors.DatabaseError
+		if !errors.As(err, &dbErr) {
+			t.Errorf("%s: wrapDBError returned %T", c.name, err)
+			continue
+		}
+		if dbErr.Reason != c.reason || dbErr.Sentinel != c.sentinel || dbErr.Retriable != c.retriable || IsRetriable(err) != c.retriable {
+			t.Errorf("%s: classified as reason %v, sentinel %v, retriable %v", c.name, dbErr.Reason, dbErr.Sentinel, dbErr.Retriable)
+		}
+		if c.sentinel != nil && !errors.Is(errors.Wrap(err, "creating user"), c.sentinel) {
+			t.Errorf("%s: %v doesn't match %v", c.name, err, c.sentinel)
+		}
+		if c.sentinel != ErrDuplicateUser && errors.Is(err, ErrDuplicateUser) {
+			t.Errorf("%s: %v matches ErrDuplicateUser", c.name, err)
+		}
+
+		var sqliteErr sqlite3.Error
+		if !errors.As(err, &sqliteErr) || sqliteErr.Code != c.err.(sqlite3.Error).Code {
+			t.Errorf("%s: the driver error is lost in %v", c.name, err)
+		}
+	}
+
+	// An error that is already classified is left alone
+	err := wrapDBError("insert", "users", sqlite3.Error{Code: sqlite3.ErrBusy})
+	if again := wrapDBError("commit", "", errors.Wrap(err, "retrying")); errors.Cause(again) != err {
+		t.Errorf("wrapDBError wrapped a DatabaseError again: %v", again)
+	}
+}
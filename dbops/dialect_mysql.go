@@ -0,0 +1,104 @@
+package dbops
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	apperrors "error-handling-demo/errors"
+)
+
+// mysqlDialect supports MySQL and MariaDB. The driver
+// (github.com/go-sql-driver/mysql) must be registered by the application.
+//
+// MySQL commits implicitly before and after every DDL statement such as
+// CREATE TABLE or ALTER TABLE, so a migration is not atomic there: if one
+// of its statements fails, the ones before it stay applied and the
+// migration isn't recorded. Such a migration has to be repaired by hand
+// before it can be run again.
+type mysqlDialect struct{}
+
+// Name implements Dialect
+func (mysqlDialect) Name() string { return "mysql" }
+
+// DriverName implements Dialect
+func (mysqlDialect) DriverName() string { return "mysql" }
+
+// Rebind implements Dialect; MySQL understands ? placeholders natively
+func (mysqlDialect) Rebind(query string) string { return query }
+
+// UpsertClause implements Dialect
+func (mysqlDialect) UpsertClause(table, key string, columns []string) string {
+	assignments := make([]string, len(columns))
+	for i, column := range columns {
+		assignments[i] = column + " = VALUES(" + column + ")"
+	}
+	return " ON DUPLICATE KEY UPDATE " + strings.Join(assignments, ", ")
+}
+
+// SyncSequence implements Dialect; AUTO_INCREMENT moves past explicitly
+// inserted keys by itself
+func (mysqlDialect) SyncSequence(table, key string) string { return "" }
+
+// TimestampCondition implements Dialect
+func (mysqlDialect) TimestampCondition(column, op string) string {
+	return column + " " + op + " ?"
+}
+
+// ClassifyError implements Dialect using the MySQL server error number
+func (mysqlDialect) ClassifyError(err error) (ErrorClass, bool) {
+	number, message, ok := mysqlErrorNumber(err)
+	if !ok {
+		return ErrorClass{}, false
+	}
+
+	switch number {
+	case 1062: // ER_DUP_ENTRY: "Duplicate entry 'x' for key 'users.username'"
+		if strings.Contains(message, "username") {
+			return ErrorClass{Reason: apperrors.ReasonUniqueViolation, Sentinel: ErrDuplicateUser}, true
+		}
+		return ErrorClass{Reason: apperrors.ReasonUniqueViolation, Sentinel: ErrConstraintViolation}, true
+	case 1048: // ER_BAD_NULL_ERROR
+		return ErrorClass{Reason: apperrors.ReasonNotNullViolation, Sentinel: ErrConstraintViolation}, true
+	case 1451, 1452: // ER_ROW_IS_REFERENCED_2, ER_NO_REFERENCED_ROW_2
+		return ErrorClass{Reason: apperrors.ReasonForeignKeyViolation, Sentinel: ErrConstraintViolation}, true
+	case 3819: // ER_CHECK_CONSTRAINT_VIOLATED
+		return ErrorClass{Reason: apperrors.ReasonConstraint, Sentinel: ErrConstraintViolation}, true
+	case 1213: // ER_LOCK_DEADLOCK
+		return ErrorClass{Reason: apperrors.ReasonBusy, Sentinel: ErrSerializationFailure, Retriable: true}, true
+	case 1205: // ER_LOCK_WAIT_TIMEOUT
+		return ErrorClass{Reason: apperrors.ReasonLocked, Sentinel: ErrDatabaseLocked, Retriable: true}, true
+	case 1290, 1792, 1836: // read-only server or transaction
+		return ErrorClass{Reason: apperrors.ReasonReadOnly, Sentinel: ErrDatabaseReadOnly}, true
+	case 1114, 1021: // ER_RECORD_FILE_FULL, ER_DISK_FULL
+		return ErrorClass{Reason: apperrors.ReasonFull, Sentinel: ErrDatabaseFull}, true
+	case 1034, 1194, 1195: // corrupted key file or table
+		return ErrorClass{Reason: apperrors.ReasonCorrupt, Sentinel: ErrDatabaseCorrupt}, true
+	default:
+		return ErrorClass{Reason: apperrors.ReasonUnknown}, true
+	}
+}
+
+// mysqlErrorNumber looks through err's chain for the MySQL driver's
+// *mysql.MySQLError and returns its number and message. The driver exposes
+// them only as the struct fields Number and Message, which are read by
+// reflection so that the driver isn't a dependency of this package.
+func mysqlErrorNumber(err error) (int, string, bool) {
+	for ; err != nil; err = errors.Unwrap(err) {
+		v := reflect.ValueOf(err)
+		if v.Kind() != reflect.Ptr || v.IsNil() {
+			continue
+		}
+		v = v.Elem()
+		if v.Kind() != reflect.Struct || v.Type().Name() != "MySQLError" {
+			continue
+		}
+		number, message := v.FieldByName("Number"), v.FieldByName("Message")
+		if number.Kind() != reflect.Uint16 || message.Kind() != reflect.String {
+			continue
+		}
+		return int(number.Uint()), message.String(), true
+	}
+	return 0, "", false
+}
@@ -0,0 +1,83 @@
+package dbops
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	apperrors "error-handling-demo/errors"
+)
+
+// postgresDialect supports PostgreSQL. The driver (lib/pq, pgx's stdlib
+// adapter, ...) must be registered as "postgres" by the application.
+type postgresDialect struct{}
+
+// sqlStateError is implemented by the error types of the common PostgreSQL
+// drivers, which lets us read the SQLSTATE without importing a driver
+type sqlStateError interface {
+	error
+	SQLState() string
+}
+
+// Name implements Dialect
+func (postgresDialect) Name() string { return "postgres" }
+
+// DriverName implements Dialect
+func (postgresDialect) DriverName() string { return "postgres" }
+
+// Rebind implements Dialect; PostgreSQL uses $1, $2, ... placeholders
+func (postgresDialect) Rebind(query string) string { return rebindNumbered(query, "$") }
+
+// UpsertClause implements Dialect
+func (postgresDialect) UpsertClause(table, key string, columns []string) string {
+	return excludedUpsertClause(key, columns)
+}
+
+// SyncSequence implements Dialect. A SERIAL column's sequence knows nothing
+// of keys inserted explicitly, so without this the next generated key would
+// collide with them.
+func (postgresDialect) SyncSequence(table, key string) string {
+	return fmt.Sprintf("SELECT setval(pg_get_serial_sequence('%[1]s', '%[2]s'), COALESCE(MAX(%[2]s), 0) + 1, false) FROM %[1]s", table, key)
+}
+
+// TimestampCondition implements Dialect
+func (postgresDialect) TimestampCondition(column, op string) string {
+	return column + " " + op + " ?"
+}
+
+// ClassifyError implements Dialect using the SQLSTATE error code
+func (postgresDialect) ClassifyError(err error) (ErrorClass, bool) {
+	var pgErr sqlStateError
+	if !errors.As(err, &pgErr) {
+		return ErrorClass{}, false
+	}
+
+	state := pgErr.SQLState()
+	switch {
+	case state == "23505": // unique_violation
+		// The default constraint name for users.username is users_username_key
+		if strings.Contains(pgErr.Error(), "username") {
+			return ErrorClass{Reason: apperrors.ReasonUniqueViolation, Sentinel: ErrDuplicateUser}, true
+		}
+		return ErrorClass{Reason: apperrors.ReasonUniqueViolation, Sentinel: ErrConstraintViolation}, true
+	case state == "23502": // not_null_violation
+		return ErrorClass{Reason: apperrors.ReasonNotNullViolation, Sentinel: ErrConstraintViolation}, true
+	case state == "23503": // foreign_key_violation
+		return ErrorClass{Reason: apperrors.ReasonForeignKeyViolation, Sentinel: ErrConstraintViolation}, true
+	case strings.HasPrefix(state, "23"): // integrity_constraint_violation
+		return ErrorClass{Reason: apperrors.ReasonConstraint, Sentinel: ErrConstraintViolation}, true
+	case state == "40001", state == "40P01": // serialization_failure, deadlock_detected
+		return ErrorClass{Reason: apperrors.ReasonBusy, Sentinel: ErrSerializationFailure, Retriable: true}, true
+	case state == "55P03": // lock_not_available
+		return ErrorClass{Reason: apperrors.ReasonLocked, Sentinel: ErrDatabaseLocked, Retriable: true}, true
+	case state == "25006": // read_only_sql_transaction
+		return ErrorClass{Reason: apperrors.ReasonReadOnly, Sentinel: ErrDatabaseReadOnly}, true
+	case state == "53100": // disk_full
+		return ErrorClass{Reason: apperrors.ReasonFull, Sentinel: ErrDatabaseFull}, true
+	case state == "XX001", state == "XX002": // data_corrupted, index_corrupted
+		return ErrorClass{Reason: apperrors.ReasonCorrupt, Sentinel: ErrDatabaseCorrupt}, true
+	default:
+		return ErrorClass{Reason: apperrors.ReasonUnknown}, true
+	}
+}
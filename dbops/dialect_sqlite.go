@@ -0,0 +1,81 @@
+package dbops
+
+import (
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+
+	apperrors "error-handling-demo/errors"
+)
+
+// sqliteDialect supports SQLite through github.com/mattn/go-sqlite3
+type sqliteDialect struct{}
+
+// Name implements Dialect
+func (sqliteDialect) Name() string { return "sqlite" }
+
+// DriverName implements Dialect
+func (sqliteDialect) DriverName() string { return "sqlite3" }
+
+// Rebind implements Dialect; SQLite understands ? placeholders natively
+func (sqliteDialect) Rebind(query string) string { return query }
+
+// UpsertClause implements Dialect
+func (sqliteDialect) UpsertClause(table, key string, columns []string) string {
+	return excludedUpsertClause(key, columns)
+}
+
+// SyncSequence implements Dialect; an INTEGER PRIMARY KEY always continues
+// after the largest rowid
+func (sqliteDialect) SyncSequence(table, key string) string { return "" }
+
+// TimestampCondition implements Dialect. SQLite stores timestamps as text in
+// whatever format they were written, so both sides go through datetime().
+func (sqliteDialect) TimestampCondition(column, op string) string {
+	return "datetime(" + column + ") " + op + " datetime(?)"
+}
+
+// ClassifyError implements Dialect using SQLite's (extended) result codes
+func (sqliteDialect) ClassifyError(err error) (ErrorClass, bool) {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return ErrorClass{}, false
+	}
+
+	switch sqliteErr.Code {
+	case sqlite3.ErrConstraint:
+		return classifySQLiteConstraint(sqliteErr), true
+	case sqlite3.ErrBusy:
+		return ErrorClass{Reason: apperrors.ReasonBusy, Sentinel: ErrDatabaseBusy, Retriable: true}, true
+	case sqlite3.ErrLocked:
+		return ErrorClass{Reason: apperrors.ReasonLocked, Sentinel: ErrDatabaseLocked, Retriable: true}, true
+	case sqlite3.ErrReadonly:
+		return ErrorClass{Reason: apperrors.ReasonReadOnly, Sentinel: ErrDatabaseReadOnly}, true
+	case sqlite3.ErrCorrupt, sqlite3.ErrNotADB:
+		return ErrorClass{Reason: apperrors.ReasonCorrupt, Sentinel: ErrDatabaseCorrupt}, true
+	case sqlite3.ErrFull:
+		return ErrorClass{Reason: apperrors.ReasonFull, Sentinel: ErrDatabaseFull}, true
+	default:
+		return ErrorClass{Reason: apperrors.ReasonUnknown}, true
+	}
+}
+
+// classifySQLiteConstraint determines which constraint was violated from the
+// extended result code and, for UNIQUE violations, the offending column
+func classifySQLiteConstraint(err sqlite3.Error) ErrorClass {
+	switch err.ExtendedCode {
+	case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey, sqlite3.ErrConstraintRowID:
+		// SQLite reports the column as "UNIQUE constraint failed: users.username"
+		if strings.Contains(err.Error(), "users.username") {
+			return ErrorClass{Reason: apperrors.ReasonUniqueViolation, Sentinel: ErrDuplicateUser}
+		}
+		return ErrorClass{Reason: apperrors.ReasonUniqueViolation, Sentinel: ErrConstraintViolation}
+	case sqlite3.ErrConstraintNotNull:
+		return ErrorClass{Reason: apperrors.ReasonNotNullViolation, Sentinel: ErrConstraintViolation}
+	case sqlite3.ErrConstraintForeignKey:
+		return ErrorClass{Reason: apperrors.ReasonForeignKeyViolation, Sentinel: ErrConstraintViolation}
+	default:
+		return ErrorClass{Reason: apperrors.ReasonConstraint, Sentinel: ErrConstraintViolation}
+	}
+}
@@ -0,0 +1,64 @@
+package dbops
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	apperrors "error-handling-demo/errors"
+	"error-handling-demo/faults"
+)
+
+// faultError is a database failure that can be injected with package faults.
+// It is classified like the driver error it imitates, so an injected
+// "db_busy" is retried exactly like a real SQLITE_BUSY.
+type faultError struct {
+	msg   string
+	class ErrorClass
+}
+
+// Error implements the error interface
+func (e *faultError) Error() string {
+	return e.msg
+}
+
+// Register the database failures configured rules can inject
+func init() {
+	for name, err := range map[string]*faultError{
+		"db_busy":          {"database is locked (injected)", ErrorClass{apperrors.ReasonBusy, ErrDatabaseBusy, true}},
+		"db_locked":        {"database table is locked (injected)", ErrorClass{apperrors.ReasonLocked, ErrDatabaseLocked, true}},
+		"db_serialization": {"could not serialize access (injected)", ErrorClass{apperrors.ReasonBusy, ErrSerializationFailure, true}},
+		"db_unique":        {"UNIQUE constraint failed (injected)", ErrorClass{apperrors.ReasonUniqueViolation, ErrDuplicateUser, false}},
+		"db_readonly":      {"attempt to write a readonly database (injected)", ErrorClass{apperrors.ReasonReadOnly, ErrDatabaseReadOnly, false}},
+		"db_full":          {"database or disk is full (injected)", ErrorClass{apperrors.ReasonFull, ErrDatabaseFull, false}},
+		"db_corrupt":       {"database disk image is malformed (injected)", ErrorClass{apperrors.ReasonCorrupt, ErrDatabaseCorrupt, false}},
+	} {
+		faults.RegisterError(name, err)
+	}
+}
+
+// classifyFault recognizes an injected database failure
+func classifyFault(err error) (ErrorClass, bool) {
+	var fault *faultError
+	if errors.As(err, &fault) {
+		return fault.class, true
+	}
+	return ErrorClass{}, false
+}
+
+// injectFault consults package faults before a statement runs. Statements
+// are named "dbops.<verb>.<table>", e.g. "dbops.insert.users", or
+// "dbops.<verb>" when they have no table.
+func injectFault(ctx context.Context, query string) error {
+	// Don't bother naming the statement when injection is off
+	if faults.Active() == nil {
+		return nil
+	}
+
+	verb, table := describeStatement(query)
+	op := "dbops." + verb
+	if table != "" {
+		op += "." + table
+	}
+	return faults.Inject(ctx, op)
+}
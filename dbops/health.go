@@ -0,0 +1,127 @@
+package dbops
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultHealthCheckTimeout bounds how long a single health check may take
+const DefaultHealthCheckTimeout = 5 * time.Second
+
+// HealthStatus is the result of a database health check
+type HealthStatus struct {
+	Healthy             bool          `json:"healthy"`
+	Latency             time.Duration `json:"latency_ns"`
+	CheckedAt           time.Time     `json:"checked_at"`
+	ConsecutiveFailures int           `json:"consecutive_failures"`
+	Error               string        `json:"error,omitempty"`
+	Err                 error         `json:"-"`
+	// Stats is a snapshot of the connection pool. A growing WaitCount or
+	// WaitDuration with InUse stuck at MaxOpenConnections points at pool
+	// exhaustion, i.e. connections that are held too long or leaked.
+	Stats sql.DBStats `json:"stats"`
+}
+
+// HealthChecker periodically pings the database and keeps the latest status
+type HealthChecker struct {
+	db       *DB
+	interval time.Duration
+	timeout  time.Duration
+	log      logrus.FieldLogger
+
+	mu     sync.RWMutex
+	status HealthStatus
+}
+
+// NewHealthChecker creates a HealthChecker that checks db every interval.
+// Changes between healthy and unhealthy are reported to log, which may be nil.
+func NewHealthChecker(db *DB, interval time.Duration, log logrus.FieldLogger) *HealthChecker {
+	return &HealthChecker{
+		db:       db,
+		interval: interval,
+		timeout:  DefaultHealthCheckTimeout,
+		log:      log,
+	}
+}
+
+// Check pings the database now, records and returns the result
+func (h *HealthChecker) Check(ctx context.Context) HealthStatus {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := h.db.PingContext(ctx)
+	latency := time.Since(start)
+
+	h.mu.Lock()
+	previous := h.status
+	status := HealthStatus{
+		Healthy:   err == nil,
+		Latency:   latency,
+		CheckedAt: start,
+		Stats:     h.db.Stats(),
+	}
+	if err != nil {
+		status.Err = wrapDBError("health check", "", err)
+		status.Error = status.Err.Error()
+		status.ConsecutiveFailures = previous.ConsecutiveFailures + 1
+	}
+	h.status = status
+	h.mu.Unlock()
+
+	h.report(previous, status)
+	return status
+}
+
+// Status returns the result of the most recent check
+func (h *HealthChecker) Status() HealthStatus {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.status
+}
+
+// Run checks the database immediately and then every interval until ctx is
+// cancelled. It is meant to be started in its own goroutine.
+func (h *HealthChecker) Run(ctx context.Context) {
+	h.Check(ctx)
+
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.Check(ctx)
+		}
+	}
+}
+
+// report logs transitions between healthy and unhealthy
+func (h *HealthChecker) report(previous, current HealthStatus) {
+	if h.log == nil {
+		return
+	}
+
+	fields := logrus.Fields{
+		"latency":     current.Latency,
+		"open_conns":  current.Stats.OpenConnections,
+		"in_use":      current.Stats.InUse,
+		"wait_count":  current.Stats.WaitCount,
+		"max_open":    current.Stats.MaxOpenConnections,
+		"first_check": previous.CheckedAt.IsZero(),
+	}
+
+	switch {
+	case !current.Healthy && (previous.Healthy || previous.CheckedAt.IsZero()):
+		h.log.WithFields(fields).WithError(current.Err).Error("Database became unhealthy")
+	case current.Healthy && !previous.Healthy && !previous.CheckedAt.IsZero():
+		// Only a recovery is worth reporting, not a healthy first check
+		h.log.WithFields(fields).Info("Database is healthy")
+	}
+}
@@ -0,0 +1,43 @@
+package dbops_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+
+	"error-handling-demo/dbops"
+)
+
+func TestHealthCheckerReportsTransitions(t *testing.T) {
+	ctx := context.Background()
+	db, err := dbops.OpenDatabase(":memory:")
+	if err != nil {
+		t.Fatalf("OpenDatabase failed: %v", err)
+	}
+	log, hook := test.NewNullLogger()
+	checker := dbops.NewHealthChecker(db, time.Minute, log)
+
+	// A healthy first check is not a recovery
+	if status := checker.Check(ctx); !status.Healthy {
+		t.Fatalf("first check unhealthy: %v", status.Err)
+	}
+	checker.Check(ctx)
+	if entries := hook.AllEntries(); len(entries) != 0 {
+		t.Fatalf("healthy checks logged %q", entries[0].Message)
+	}
+
+	// Failing is reported once
+	db.Close()
+	for i := 0; i < 2; i++ {
+		if status := checker.Check(ctx); status.Healthy || status.ConsecutiveFailures != i+1 {
+			t.Fatalf("check %d on a closed database = %+v", i+1, status)
+		}
+	}
+	entries := hook.AllEntries()
+	if len(entries) != 1 || entries[0].Level != logrus.ErrorLevel || entries[0].Message != "Database became unhealthy" {
+		t.Fatalf("failing checks logged %d entries, want one error", len(entries))
+	}
+}
@@ -0,0 +1,263 @@
+package dbops
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"error-handling-demo/config"
+)
+
+// DefaultQueryTimeout is how long single-row lookups such as GetUser may take
+// unless the DB was given a different timeout with SetQueryTimeout
+const DefaultQueryTimeout = 5 * time.Second
+
+// Instrumentation configures how a DB observes the statements it runs
+type Instrumentation struct {
+	// Logger receives slow-query warnings and, with Trace, every statement.
+	// A nil Logger disables logging.
+	Logger logrus.FieldLogger
+	// SlowQueryThreshold is the duration above which a statement is logged
+	// as slow; 0 disables the slow-query log
+	SlowQueryThreshold time.Duration
+	// Trace logs every statement at debug level
+	Trace bool
+	// Observer, if set, is called after every statement, e.g. to feed a tracer
+	Observer func(QueryEvent)
+}
+
+// InstrumentationFromConfig builds the instrumentation described by the configuration
+func InstrumentationFromConfig(cfg *config.Config, log logrus.FieldLogger) Instrumentation {
+	return Instrumentation{
+		Logger:             log,
+		SlowQueryThreshold: time.Duration(cfg.SlowQueryThreshold) * time.Millisecond,
+	}
+}
+
+// Configure applies the pool, instrumentation and query timeout settings of
+// the configuration to the DB, logging slow queries to log
+func (db *DB) Configure(cfg *config.Config, log logrus.FieldLogger) {
+	db.ConfigurePool(PoolOptionsFromConfig(cfg.DatabasePool))
+	db.Instrument(InstrumentationFromConfig(cfg, log))
+	db.SetQueryTimeout(time.Duration(cfg.QueryTimeout) * time.Second)
+}
+
+// QueryEvent describes one statement run through a DB or Tx
+type QueryEvent struct {
+	Operation string        // Statement verb and table, e.g. "select users"
+	Table     string        // Main table of the statement, if it could be found
+	Query     string        // The statement as written by the caller
+	Args      []string      // The types of the arguments, never their values
+	Duration  time.Duration // How long the driver took to run the statement
+	Err       error         // The error returned by the driver, if any
+}
+
+// QueryStats are the counters kept for one operation
+type QueryStats struct {
+	Count  int64         `json:"count"`
+	Errors int64         `json:"errors"`
+	Total  time.Duration `json:"total_ns"`
+	Max    time.Duration `json:"max_ns"`
+}
+
+// Mean returns the average duration of the operation
+func (s QueryStats) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Count)
+}
+
+// QueryError records which statement failed and how long it ran. The DB and
+// Tx wrappers return it in place of the driver error, and wrapDBError copies
+// its details into the resulting *errors.DatabaseError.
+type QueryError struct {
+	Query    string
+	Table    string
+	Duration time.Duration
+	Err      error
+}
+
+// Error implements the error interface. The statement is left out so that
+// the message reads exactly like the driver error it wraps.
+func (e *QueryError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the driver error
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+// observer times statements and keeps per-operation counters. It is shared
+// by a DB and every transaction started from it.
+type observer struct {
+	inst Instrumentation
+
+	mu    sync.Mutex
+	stats map[string]*QueryStats
+}
+
+// newObserver creates an observer without logging
+func newObserver() *observer {
+	return &observer{stats: make(map[string]*QueryStats)}
+}
+
+// observe records a statement that started at start and returned err, and
+// returns err wrapped in a *QueryError
+func (o *observer) observe(query string, args []interface{}, start time.Time, err error) error {
+	duration := time.Since(start)
+	verb, table := describeStatement(query)
+
+	operation := verb
+	if table != "" {
+		operation += " " + table
+	}
+
+	// Not finding a row is an answer, not a failure
+	failed := err != nil && !errors.Is(err, sql.ErrNoRows)
+
+	o.mu.Lock()
+	stats, ok := o.stats[operation]
+	if !ok {
+		stats = &QueryStats{}
+		o.stats[operation] = stats
+	}
+	stats.Count++
+	if failed {
+		stats.Errors++
+	}
+	stats.Total += duration
+	if duration > stats.Max {
+		stats.Max = duration
+	}
+	o.mu.Unlock()
+
+	event := QueryEvent{
+		Operation: operation,
+		Table:     table,
+		Query:     query,
+		Args:      redactArgs(args),
+		Duration:  duration,
+		Err:       err,
+	}
+	o.log(event)
+	if o.inst.Observer != nil {
+		o.inst.Observer(event)
+	}
+
+	if !failed {
+		return err
+	}
+	return &QueryError{Query: query, Table: table, Duration: duration, Err: err}
+}
+
+// log writes the event to the slow-query log or the trace log
+func (o *observer) log(event QueryEvent) {
+	log := o.inst.Logger
+	if log == nil {
+		return
+	}
+
+	slow := o.inst.SlowQueryThreshold > 0 && event.Duration >= o.inst.SlowQueryThreshold
+	if !slow && !o.inst.Trace {
+		return
+	}
+
+	entry := log.WithFields(logrus.Fields{
+		"operation": event.Operation,
+		"query":     strings.Join(strings.Fields(event.Query), " "),
+		"args":      event.Args,
+		"duration":  event.Duration,
+	})
+	if event.Err != nil {
+		entry = entry.WithError(event.Err)
+	}
+
+	if slow {
+		entry.Warn("Slow database query")
+	} else {
+		entry.Debug("Database query")
+	}
+}
+
+// snapshot returns a copy of the counters
+func (o *observer) snapshot() map[string]QueryStats {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	result := make(map[string]QueryStats, len(o.stats))
+	for operation, stats := range o.stats {
+		result[operation] = *stats
+	}
+	return result
+}
+
+// statementTable finds the table a statement works on
+var statementTable = regexp.MustCompile(
+	"(?is)\\b(?:from|into|update|table(?:\\s+if\\s+(?:not\\s+)?exists)?)\\s+[\"`]?(\\w+)")
+
+// describeStatement returns the lower-cased verb of a statement and its
+// main table, e.g. "select" and "users"
+func describeStatement(query string) (verb, table string) {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "", ""
+	}
+	verb = strings.ToLower(fields[0])
+
+	// Savepoint and transaction statements name no table
+	switch verb {
+	case "savepoint", "release", "rollback", "commit", "begin":
+		return verb, ""
+	}
+
+	if match := statementTable.FindStringSubmatch(query); match != nil {
+		table = strings.ToLower(match[1])
+	}
+	return verb, table
+}
+
+// redactArgs describes query arguments by type so that logs never contain
+// user data such as emails
+func redactArgs(args []interface{}) []string {
+	if len(args) == 0 {
+		return nil
+	}
+
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == nil {
+			redacted[i] = "nil"
+		} else {
+			redacted[i] = fmt.Sprintf("%T", arg)
+		}
+	}
+	return redacted
+}
+
+// Instrument sets how the DB logs and reports statements. It should be
+// called before the DB is shared between goroutines.
+func (db *DB) Instrument(inst Instrumentation) {
+	db.obs.inst = inst
+}
+
+// QueryStats returns per-operation counters for every statement run so far
+func (db *DB) QueryStats() map[string]QueryStats {
+	return db.obs.snapshot()
+}
+
+// SetQueryTimeout sets the timeout for single-row lookups such as GetUser;
+// 0 restores DefaultQueryTimeout
+func (db *DB) SetQueryTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = DefaultQueryTimeout
+	}
+	db.queryTimeout = timeout
+}
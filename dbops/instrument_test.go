@@ -0,0 +1,200 @@
+package dbops
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// capturedObserver returns an observer logging to a captured logger
+func capturedObserver(inst Instrumentation) (*observer, *test.Hook) {
+	logger, hook := test.NewNullLogger()
+	logger.SetLevel(logrus.DebugLevel)
+	inst.Logger = logger
+	o := newObserver()
+	o.inst = inst
+	return o, hook
+}
+
+func TestObserveSlowQueries(t *testing.T) {
+	query := "SELECT id FROM users WHERE id = ?"
+	for _, tc := range []struct {
+		name      string
+		threshold time.Duration
+		trace     bool
+		level     logrus.Level
+		logged    bool
+	}{
+		{"slow log disabled", 0, false, 0, false},
+		{"below the threshold", time.Hour, false, 0, false},
+		{"above the threshold", 10 * time.Millisecond, false, logrus.WarnLevel, true},
+		{"traced", 0, true, logrus.DebugLevel, true},
+		{"traced and slow", 10 * time.Millisecond, true, logrus.WarnLevel, true},
+	} {
+		o, hook := capturedObserver(Instrumentation{SlowQueryThreshold: tc.threshold, Trace: tc.trace})
+		o.observe(query, []interface{}{1}, time.Now().Add(-20*time.Millisecond), nil)
+
+		entries := hook.AllEntries()
+		if !tc.logged {
+			if len(entries) != 0 {
+				t.Errorf("%s: logged %q", tc.name, entries[0].Message)
+			}
+			continue
+		}
+		if len(entries) != 1 || entries[0].Level != tc.level {
+			t.Errorf("%s: logged %d entries, want one at %s", tc.name, len(entries), tc.level)
+			continue
+		}
+		fields := entries[0].Data
+		if fields["operation"] != "select users" || fields["query"] != query || fields["duration"].(time.Duration) < 20*time.Millisecond {
+			t.Errorf("%s: logged fields %v", tc.name, fields)
+		}
+	}
+
+	// Without a logger nothing is logged, but the observer still runs
+	var events []QueryEvent
+	o := newObserver()
+	o.inst = Instrumentation{SlowQueryThreshold: time.Nanosecond, Observer: func(e QueryEvent) { events = append(events, e) }}
+	o.observe(query, nil, time.Now(), nil)
+	if len(events) != 1 || events[0].Operation != "select users" || events[0].Table != "users" {
+		t.Errorf("observer received %+v", events)
+	}
+}
+
+func TestObserveStats(t *testing.T) {
+	o, _ := capturedObserver(Instrumentation{})
+	driverErr := sqlite3.Error{Code: sqlite3.ErrBusy}
+	start := time.Now().Add(-10 * time.Millisecond)
+
+	o.observe("SELECT * FROM users", nil, start, nil)
+	o.observe("select id from users where id = ?", nil, start, sql.ErrNoRows)
+	o.observe("INSERT INTO users (username) VALUES (?)", nil, start, nil)
+	o.observe("INSERT INTO users (username) VALUES (?)", nil, start, driverErr)
+	o.observe("INSERT INTO user_audit (user_id) VALUES (?)", nil, start, nil)
+	o.observe("SAVEPOINT sp_1", nil, start, nil)
+
+	want := map[string]struct{ count, errors int64 }{
+		"select users":      {2, 0}, // Not finding a row isn't an error
+		"insert users":      {2, 1},
+		"insert user_audit": {1, 0},
+		"savepoint":         {1, 0},
+	}
+	stats := o.snapshot()
+	if len(stats) != len(want) {
+		t.Errorf("stats for %d operations, want %d: %v", len(stats), len(want), stats)
+	}
+	for operation, w := range want {
+		s := stats[operation]
+		if s.Count != w.count || s.Errors != w.errors {
+			t.Errorf("%s: count %d, errors %d; want %d, %d", operation, s.Count, s.Errors, w.count, w.errors)
+		}
+		if s.Max < 10*time.Millisecond || s.Total < time.Duration(w.count)*10*time.Millisecond || s.Mean() < 10*time.Millisecond {
+			t.Errorf("%s: max %v, total %v, mean %v; want at least 10ms each", operation, s.Max, s.Total, s.Mean())
+		}
+	}
+
+	// The snapshot is a copy
+	stats["select users"] = QueryStats{}
+	if o.snapshot()["select users"].Count != 2 {
+		t.Error("changing a snapshot changed the counters")
+	}
+	if (QueryStats{}).Mean() != 0 {
+		t.Error("an unused operation has a mean duration")
+	}
+}
+
+func TestObserveQueryError(t *testing.T) {
+	o, _ := capturedObserver(Instrumentation{})
+	query := "UPDATE users SET email = ? WHERE id = ?"
+	driverErr := sqlite3.Error{Code: sqlite3.ErrBusy}
+
+	err := o.observe(query, nil, time.Now().Add(-5*time.Millisecond), driverErr)
+	var queryErr *QueryError
+	if !errors.As(err, &queryErr) {
+		t.Fatalf("observe returned %T, want a *QueryError", err)
+	}
+	if queryErr.Query != query || queryErr.Table != "users" || queryErr.Duration < 5*time.Millisecond {
+		t.Errorf("QueryError = %+v", queryErr)
+	}
+	if err.Error() != driverErr.Error() {
+		t.Errorf("QueryError reads %q, want the driver's %q", err.Error(), driverErr.Error())
+	}
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.Code != sqlite3.ErrBusy {
+		t.Errorf("the driver error is lost in %v", err)
+	}
+
+	// The details reach the DatabaseError
+	if !IsRetriable(wrapDBError("update user", "", err)) {
+		t.Errorf("wrapped QueryError isn't retriable: %v", err)
+	}
+
+	// Success and sql.ErrNoRows come back as they are
+	if err := o.observe(query, nil, time.Now(), nil); err != nil {
+		t.Errorf("observe(nil) = %v", err)
+	}
+	if err := o.observe("SELECT id FROM users", nil, time.Now(), sql.ErrNoRows); err != sql.ErrNoRows {
+		t.Errorf("observe(sql.ErrNoRows) = %v", err)
+	}
+}
+
+func TestRedactArgs(t *testing.T) {
+	if got := redactArgs(nil); got != nil {
+		t.Errorf("redactArgs(nil) = %v", got)
+	}
+	got := redactArgs([]interface{}{"jane@example.com", 42, nil, []byte("secret"), time.Time{}})
+	want := []string{"string", "int", "nil", "[]uint8", "time.Time"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("redactArgs = %v, want %v", got, want)
+	}
+}
+
+func TestInstrumentNeverLogsArguments(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	logger, hook := test.NewNullLogger()
+	logger.SetLevel(logrus.DebugLevel)
+	db.Instrument(Instrumentation{Logger: logger, SlowQueryThreshold: time.Nanosecond, Trace: true})
+
+	insertTestUser(t, ctx, db, 1, "secretname")
+	insertErr := InsertUser(ctx, db, struct {
+		ID       int
+		Username string
+		Email    string
+	}{2, "secretname", "hidden@example.org"})
+	if !errors.Is(insertErr, ErrDuplicateUser) {
+		t.Fatalf("duplicate InsertUser returned %v", insertErr)
+	}
+
+	entries := hook.AllEntries()
+	if len(entries) == 0 {
+		t.Fatal("nothing was logged")
+	}
+	for _, entry := range entries {
+		line, err := entry.String()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, secret := range []string{"secretname", "hidden@example.org"} {
+			if strings.Contains(line, secret) {
+				t.Errorf("log line contains %q: %s", secret, line)
+			}
+		}
+	}
+
+	// Every statement was counted, including the one that failed
+	stats := db.QueryStats()
+	if s := stats["insert users"]; s.Count != 2 || s.Errors != 1 {
+		t.Errorf("insert users stats = %+v, want 2 statements and 1 error", s)
+	}
+	if s := stats["insert user_audit"]; s.Count != 1 {
+		t.Errorf("insert user_audit stats = %+v, want 1 statement", s)
+	}
+}
@@ -0,0 +1,407 @@
+package dbops
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	apperrors "error-handling-demo/errors"
+)
+
+// migrationFiles holds the migrations shipped with the application,
+// one directory per dialect
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// Errors reported by the migrator
+var (
+	ErrMigrationChecksum = errors.New("applied migration has been modified")
+	ErrMigrationMissing  = errors.New("applied migration is missing from the migration files")
+	ErrMigrationNoDown   = errors.New("migration has no down script")
+	ErrInvalidMigration  = errors.New("invalid migration file")
+)
+
+// migrationsTable is the table recording which migrations have been applied
+const migrationsTable = "schema_migrations"
+
+// Migration is a single versioned schema change
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string // SHA-256 of the up script
+}
+
+// ID returns the migration's identifier, e.g. "0001_create_users"
+func (m Migration) ID() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// MigrationStatus describes whether a migration has been applied
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+	Modified  bool // The applied checksum doesn't match the migration file
+}
+
+// appliedMigration is a row from the schema_migrations table
+type appliedMigration struct {
+	version   int
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// Migrator applies and reverts versioned migrations
+type Migrator struct {
+	db         *DB
+	migrations []Migration
+
+	// DryRun reports which migrations would run without executing them
+	DryRun bool
+}
+
+// NewMigrator creates a Migrator for the migrations in fsys. Migration files
+// are named NNNN_name.up.sql and NNNN_name.down.sql and live in the root of fsys.
+func NewMigrator(db *DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := loadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// DefaultMigrator creates a Migrator for the migrations embedded in dbops
+// for the database's dialect
+func DefaultMigrator(db *DB) (*Migrator, error) {
+	fsys, err := fs.Sub(migrationFiles, path.Join("migrations", db.Dialect().Name()))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open embedded migrations")
+	}
+	return NewMigrator(db, fsys)
+}
+
+// loadMigrations reads and pairs up the migration scripts in fsys
+func loadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read migrations")
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+
+		version, name, direction, err := parseMigrationFileName(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read migration %s", entry.Name())
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, errors.Wrapf(ErrInvalidMigration, "version %d is used by both %q and %q", version, m.Name, name)
+		}
+
+		if direction == "up" {
+			m.Up = string(content)
+			sum := sha256.Sum256(content)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, errors.Wrapf(ErrInvalidMigration, "migration %s has no up script", m.ID())
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// parseMigrationFileName splits "0001_create_users.up.sql" into its parts
+func parseMigrationFileName(fileName string) (int, string, string, error) {
+	base := strings.TrimSuffix(fileName, ".sql")
+
+	var direction string
+	switch {
+	case strings.HasSuffix(base, ".up"):
+		direction = "up"
+	case strings.HasSuffix(base, ".down"):
+		direction = "down"
+	default:
+		return 0, "", "", errors.Wrapf(ErrInvalidMigration, "%s must end in .up.sql or .down.sql", fileName)
+	}
+	base = strings.TrimSuffix(base, "."+direction)
+
+	parts := strings.SplitN(base, "_", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return 0, "", "", errors.Wrapf(ErrInvalidMigration, "%s must be named NNNN_name", fileName)
+	}
+
+	version, err := strconv.Atoi(parts[0])
+	if err != nil || version <= 0 {
+		return 0, "", "", errors.Wrapf(ErrInvalidMigration, "%s has an invalid version number", fileName)
+	}
+
+	return version, parts[1], direction, nil
+}
+
+// Up applies every pending migration in version order, each in its own
+// transaction, and returns the migrations that were (or in dry-run mode
+// would have been) applied. MySQL commits DDL statements implicitly, so
+// there a failed migration can be left partly applied.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	applied, err := m.verifiedApplied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+
+	if m.DryRun || len(pending) == 0 {
+		return pending, nil
+	}
+
+	db, release, err := m.scriptDB()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var done []Migration
+	for _, migration := range pending {
+		if err := m.apply(ctx, db, migration); err != nil {
+			return done, err
+		}
+		done = append(done, migration)
+	}
+
+	return done, nil
+}
+
+// Down reverts the most recently applied migrations, at most steps of them,
+// and returns the migrations that were (or would have been) reverted
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	applied, err := m.verifiedApplied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []Migration
+	for i := len(m.migrations) - 1; i >= 0 && len(targets) < steps; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		if migration.Down == "" {
+			return nil, migrationError("revert migration", migration, ErrMigrationNoDown)
+		}
+		targets = append(targets, migration)
+	}
+
+	if m.DryRun || len(targets) == 0 {
+		return targets, nil
+	}
+
+	db, release, err := m.scriptDB()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var done []Migration
+	for _, migration := range targets {
+		if err := m.revert(ctx, db, migration); err != nil {
+			return done, err
+		}
+		done = append(done, migration)
+	}
+
+	return done, nil
+}
+
+// Status reports every known migration and whether it has been applied
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.loadApplied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Migration: migration}
+		if row, ok := applied[migration.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = row.appliedAt
+			status.Modified = row.checksum != migration.Checksum
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// scriptDB returns the database to run migration scripts on and a function
+// releasing it. A script holds several statements, which MySQL only accepts
+// on a connection opened for that, so one is opened for the duration of the
+// migration; other databases use the migrator's own.
+func (m *Migrator) scriptDB() (*DB, func(), error) {
+	dsn := multiStatementDSN(m.db.dialect, m.db.driverDSN)
+	if m.db.driverDSN == "" || dsn == m.db.driverDSN {
+		return m.db, func() {}, nil
+	}
+
+	sqlDB, err := sql.Open(m.db.dialect.DriverName(), dsn)
+	if err != nil {
+		return nil, nil, wrapDBError("open", "", err)
+	}
+	db := NewDB(sqlDB, m.db.dialect)
+	db.driverDSN, db.obs, db.queryTimeout = dsn, m.db.obs, m.db.queryTimeout
+	return db, func() { db.Close() }, nil
+}
+
+// apply runs a migration's up script and records it in one transaction
+func (m *Migrator) apply(ctx context.Context, db *DB, migration Migration) error {
+	err := ExecuteInTransaction(ctx, db, func(tx *Tx) error {
+		if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO `+migrationsTable+` (version, name, checksum) VALUES (?, ?, ?)`,
+			migration.Version, migration.Name, migration.Checksum)
+		return err
+	})
+	if err != nil {
+		return migrationError("apply migration", migration, err)
+	}
+	return nil
+}
+
+// revert runs a migration's down script and removes its record in one transaction
+func (m *Migrator) revert(ctx context.Context, db *DB, migration Migration) error {
+	err := ExecuteInTransaction(ctx, db, func(tx *Tx) error {
+		if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx,
+			`DELETE FROM `+migrationsTable+` WHERE version = ?`, migration.Version)
+		return err
+	})
+	if err != nil {
+		return migrationError("revert migration", migration, err)
+	}
+	return nil
+}
+
+// verifiedApplied loads the applied migrations and checks that each of them
+// still matches its migration file
+func (m *Migrator) verifiedApplied(ctx context.Context) (map[int]appliedMigration, error) {
+	applied, err := m.loadApplied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[int]Migration, len(m.migrations))
+	for _, migration := range m.migrations {
+		known[migration.Version] = migration
+	}
+
+	for version, row := range applied {
+		migration, ok := known[version]
+		if !ok {
+			return nil, migrationError("verify migration",
+				Migration{Version: row.version, Name: row.name}, ErrMigrationMissing)
+		}
+		if row.checksum != migration.Checksum {
+			return nil, migrationError("verify migration", migration, ErrMigrationChecksum)
+		}
+	}
+
+	return applied, nil
+}
+
+// loadApplied creates the schema_migrations table if needed and reads it
+func (m *Migrator) loadApplied(ctx context.Context) (map[int]appliedMigration, error) {
+	createSQL := `
+	CREATE TABLE IF NOT EXISTS ` + migrationsTable + ` (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);`
+	if _, err := m.db.ExecContext(ctx, createSQL); err != nil {
+		return nil, wrapDBError("create table", migrationsTable, err)
+	}
+
+	rows, err := m.db.QueryContext(ctx,
+		`SELECT version, name, checksum, applied_at FROM `+migrationsTable)
+	if err != nil {
+		return nil, wrapDBError("load migrations", migrationsTable, err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]appliedMigration)
+	for rows.Next() {
+		var row appliedMigration
+		if err := rows.Scan(&row.version, &row.name, &row.checksum, scanTimestamp("applied_at", &row.appliedAt)); err != nil {
+			return nil, wrapDBError("load migrations", migrationsTable, err)
+		}
+		applied[row.version] = row
+	}
+	if err := rows.Err(); err != nil {
+		return nil, wrapDBError("load migrations", migrationsTable, err)
+	}
+
+	return applied, nil
+}
+
+// migrationError reports a failed migration step as a DatabaseError whose
+// operation names the migration. The reason of an underlying DatabaseError
+// is carried over so callers don't have to dig for it.
+func migrationError(op string, migration Migration, err error) error {
+	result := apperrors.NewDatabaseError(op+" "+migration.ID(), migrationsTable, err)
+
+	var dbErr *apperrors.DatabaseError
+	if errors.As(err, &dbErr) {
+		result.Reason = dbErr.Reason
+		result.Retriable = dbErr.Retriable
+	}
+
+	return result
+}
@@ -0,0 +1,189 @@
+package dbops
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/pkg/errors"
+)
+
+// openUnmigratedDB returns an empty SQLite database in a temporary directory
+func openUnmigratedDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := OpenDatabase(filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatalf("OpenDatabase failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// tableExists reports whether a SQLite database has a table called name
+func tableExists(t *testing.T, db *DB, name string) bool {
+	t.Helper()
+	var count int
+	err := db.QueryRowContext(context.Background(),
+		"SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count > 0
+}
+
+// migrationIDs lists the IDs of migrations
+func migrationIDs(migrations []Migration) string {
+	ids := make([]string, len(migrations))
+	for i, m := range migrations {
+		ids[i] = m.ID()
+	}
+	return strings.Join(ids, " ")
+}
+
+func TestMigratorUpDown(t *testing.T) {
+	ctx := context.Background()
+	db := openUnmigratedDB(t)
+	migrator, err := DefaultMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const all = "0001_create_users 0002_user_versions_and_audit"
+
+	applied, err := migrator.Up(ctx)
+	if err != nil || migrationIDs(applied) != all {
+		t.Fatalf("Up applied %q, %v; want %q", migrationIDs(applied), err, all)
+	}
+	if !tableExists(t, db, "users") || !tableExists(t, db, "user_audit") {
+		t.Fatal("Up didn't create the tables")
+	}
+
+	// Running Up again has nothing to do
+	if applied, err := migrator.Up(ctx); err != nil || len(applied) != 0 {
+		t.Errorf("second Up applied %q, %v", migrationIDs(applied), err)
+	}
+
+	// Down(1) reverts 0002 only
+	reverted, err := migrator.Down(ctx, 1)
+	if err != nil || migrationIDs(reverted) != "0002_user_versions_and_audit" {
+		t.Fatalf("Down(1) reverted %q, %v", migrationIDs(reverted), err)
+	}
+	if tableExists(t, db, "user_audit") || !tableExists(t, db, "users") {
+		t.Error("Down(1) didn't leave just the users table")
+	}
+	if _, err := db.ExecContext(ctx, "SELECT version FROM users"); err == nil {
+		t.Error("users.version survived Down(1)")
+	}
+	statuses, err := migrator.Status(ctx)
+	if err != nil || len(statuses) != 2 || !statuses[0].Applied || statuses[1].Applied {
+		t.Errorf("Status after Down(1) = %+v, %v", statuses, err)
+	}
+
+	// Up brings it back
+	if applied, err := migrator.Up(ctx); err != nil || migrationIDs(applied) != "0002_user_versions_and_audit" {
+		t.Errorf("Up after Down(1) applied %q, %v", migrationIDs(applied), err)
+	}
+}
+
+func TestMigratorDryRun(t *testing.T) {
+	ctx := context.Background()
+	db := openUnmigratedDB(t)
+	migrator, err := DefaultMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrator.DryRun = true
+
+	pending, err := migrator.Up(ctx)
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("dry-run Up = %q, %v; want both migrations", migrationIDs(pending), err)
+	}
+	if tableExists(t, db, "users") || tableExists(t, db, "user_audit") {
+		t.Error("dry-run Up changed the schema")
+	}
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.Applied {
+			t.Errorf("dry-run Up recorded %s as applied", status.ID())
+		}
+	}
+
+	// A dry-run Down leaves applied migrations alone too
+	migrator.DryRun = false
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	migrator.DryRun = true
+	if reverted, err := migrator.Down(ctx, 2); err != nil || len(reverted) != 2 {
+		t.Errorf("dry-run Down(2) = %q, %v; want both migrations", migrationIDs(reverted), err)
+	}
+	if !tableExists(t, db, "users") || !tableExists(t, db, "user_audit") {
+		t.Error("dry-run Down changed the schema")
+	}
+}
+
+func TestMigratorChecksum(t *testing.T) {
+	ctx := context.Background()
+	db := openUnmigratedDB(t)
+	files := fstest.MapFS{
+		"0001_create_items.up.sql":   {Data: []byte("CREATE TABLE items (id INTEGER PRIMARY KEY);")},
+		"0001_create_items.down.sql": {Data: []byte("DROP TABLE items;")},
+	}
+	migrator, err := NewMigrator(db, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+
+	// Editing an applied migration is caught before anything runs
+	files["0001_create_items.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT);")}
+	files["0002_create_tags.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE tags (id INTEGER PRIMARY KEY);")}
+	migrator, err = NewMigrator(db, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(ctx); !errors.Is(err, ErrMigrationChecksum) {
+		t.Errorf("Up with a modified migration returned %v, want ErrMigrationChecksum", err)
+	}
+	if tableExists(t, db, "tags") {
+		t.Error("Up ran a pending migration despite the modified one")
+	}
+	if _, err := migrator.Down(ctx, 1); !errors.Is(err, ErrMigrationChecksum) {
+		t.Errorf("Down with a modified migration returned %v, want ErrMigrationChecksum", err)
+	}
+	statuses, err := migrator.Status(ctx)
+	if err != nil || !statuses[0].Applied || !statuses[0].Modified || statuses[1].Applied {
+		t.Errorf("Status = %+v, %v; want 0001 applied and modified", statuses, err)
+	}
+}
+
+func TestMigratorMultiStatementConnection(t *testing.T) {
+	db, err := OpenDatabase("mysql://app@db.example.com/users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mysqlStub.reset(nil)
+	if strings.Contains(mysqlStub.dsn, "multiStatements") {
+		t.Errorf("ordinary connection opened with %q", mysqlStub.dsn)
+	}
+
+	// The scripts run on a connection of their own that allows several
+	// statements per Exec
+	migrator, err := DefaultMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+	if want := "app@tcp(db.example.com:3306)/users?multiStatements=true&parseTime=true"; mysqlStub.dsn != want {
+		t.Errorf("migration connection opened with %q, want %q", mysqlStub.dsn, want)
+	}
+}
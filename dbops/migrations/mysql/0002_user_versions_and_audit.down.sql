@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS user_audit;
+
+ALTER TABLE users DROP COLUMN deleted_at;
+ALTER TABLE users DROP COLUMN version;
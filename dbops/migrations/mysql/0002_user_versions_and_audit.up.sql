@@ -0,0 +1,12 @@
+ALTER TABLE users ADD COLUMN version INT NOT NULL DEFAULT 1;
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP NULL;
+
+CREATE TABLE IF NOT EXISTS user_audit (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	user_id INT NOT NULL,
+	action VARCHAR(32) NOT NULL,
+	actor VARCHAR(255) NOT NULL,
+	changes TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	INDEX idx_user_audit_user_id (user_id)
+) ENGINE=InnoDB;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_user_audit_user_id;
+DROP TABLE IF EXISTS user_audit;
+
+ALTER TABLE users DROP COLUMN deleted_at;
+ALTER TABLE users DROP COLUMN version;
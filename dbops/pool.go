@@ -0,0 +1,57 @@
+package dbops
+
+import (
+	"time"
+
+	"error-handling-demo/config"
+)
+
+// PoolOptions configures the connection pool of a DB.
+// A value of 0 leaves the database/sql default in place.
+type PoolOptions struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// PoolOptionsFromConfig converts the pool section of the configuration
+func PoolOptionsFromConfig(cfg config.DatabasePool) PoolOptions {
+	return PoolOptions{
+		MaxOpenConns:    cfg.MaxOpenConns,
+		MaxIdleConns:    cfg.MaxIdleConns,
+		ConnMaxLifetime: time.Duration(cfg.ConnMaxLifetime) * time.Second,
+		ConnMaxIdleTime: time.Duration(cfg.ConnMaxIdleTime) * time.Second,
+	}
+}
+
+// ConfigurePool applies opts to the connection pool. An in-memory SQLite
+// database lives and dies with its connection, so it always keeps exactly
+// one connection open forever and opts are ignored.
+func (db *DB) ConfigurePool(opts PoolOptions) {
+	if db.singleConn {
+		return
+	}
+
+	if opts.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(opts.MaxOpenConns)
+	}
+	if opts.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+	if opts.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(opts.ConnMaxLifetime)
+	}
+	if opts.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(opts.ConnMaxIdleTime)
+	}
+}
+
+// useSingleConnection pins the pool to one connection that is never closed
+func (db *DB) useSingleConnection() {
+	db.singleConn = true
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxLifetime(0)
+	db.SetConnMaxIdleTime(0)
+}
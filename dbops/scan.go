@@ -0,0 +1,105 @@
+package dbops
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// ErrUnsupportedType is returned when a column holds a type we can't convert
+var ErrUnsupportedType = errors.New("unsupported column type")
+
+// ScanError reports a column value that could not be converted while scanning a row
+type ScanError struct {
+	Column string      // Name of the column being scanned
+	Raw    interface{} // The value returned by the driver
+	Err    error       // Why the conversion failed
+}
+
+// Error implements the error interface
+func (e *ScanError) Error() string {
+	return fmt.Sprintf("cannot scan column '%s' (raw value %#v): %v", e.Column, e.Raw, e.Err)
+}
+
+// Unwrap returns the underlying conversion error
+func (e *ScanError) Unwrap() error {
+	return e.Err
+}
+
+// timestampLayouts are the string formats accepted for timestamp columns:
+// RFC 3339 followed by the formats SQLite itself produces and understands
+var timestampLayouts = append([]string{time.RFC3339Nano}, sqlite3.SQLiteTimestampFormats...)
+
+// timestampScanner is a sql.Scanner that accepts any representation of a
+// timestamp the driver may hand back and normalizes it to UTC
+type timestampScanner struct {
+	column string
+	dest   *time.Time
+}
+
+// scanTimestamp returns a sql.Scanner that stores a timestamp column into dest.
+// time.Time values, RFC 3339 strings, SQLite's default formats and Unix
+// seconds are accepted; NULL leaves dest as the zero time.
+func scanTimestamp(column string, dest *time.Time) sql.Scanner {
+	return timestampScanner{column: column, dest: dest}
+}
+
+// Scan implements the sql.Scanner interface
+func (s timestampScanner) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*s.dest = time.Time{}
+	case time.Time:
+		*s.dest = v.UTC()
+	case int64:
+		*s.dest = time.Unix(v, 0).UTC()
+	case []byte:
+		return s.parse(src, string(v))
+	case string:
+		return s.parse(src, v)
+	default:
+		return &ScanError{Column: s.column, Raw: src, Err: errors.Wrapf(ErrUnsupportedType, "%T", src)}
+	}
+	return nil
+}
+
+// parse tries each accepted layout in turn
+func (s timestampScanner) parse(raw interface{}, value string) error {
+	value = strings.TrimSpace(value)
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			*s.dest = t.UTC()
+			return nil
+		}
+	}
+	return &ScanError{
+		Column: s.column,
+		Raw:    raw,
+		Err:    errors.Errorf("unrecognized timestamp format %q", value),
+	}
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// userColumns is the column list scanUser expects, in order
+const userColumns = "id, username, email, created_at, version, deleted_at"
+
+// scanUser reads a row selected as userColumns
+func scanUser(row rowScanner) (*User, error) {
+	var user User
+	err := row.Scan(&user.ID, &user.Username, &user.Email,
+		scanTimestamp("created_at", &user.CreatedAt),
+		&user.Version,
+		scanTimestamp("deleted_at", &user.DeletedAt))
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
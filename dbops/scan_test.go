@@ -0,0 +1,90 @@
+package dbops
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+func TestScanTimestamp(t *testing.T) {
+	want := time.Date(2024, 3, 1, 10, 30, 15, 500000000, time.UTC)
+	berlin := time.FixedZone("CET", 3600)
+
+	for _, tc := range []struct {
+		name string
+		src  interface{}
+		want time.Time
+	}{
+		{"time in UTC", want, want},
+		{"time in another zone", want.In(berlin), want},
+		{"unix seconds", want.Unix(), want.Truncate(time.Second)},
+		{"RFC 3339", "2024-03-01T11:30:15.5+01:00", want},
+		{"RFC 3339 in UTC", "2024-03-01T10:30:15.5Z", want},
+		{"bytes", []byte("2024-03-01T11:30:15.5+01:00"), want},
+		{"SQLite with zone", "2024-03-01 11:30:15.5+01:00", want},
+		{"SQLite with T and zone", "2024-03-01T11:30:15.5+01:00", want},
+		{"SQLite fraction", "2024-03-01 10:30:15.5", want},
+		{"SQLite default", "2024-03-01 10:30:15", want.Truncate(time.Second)},
+		{"SQLite minutes", "2024-03-01 10:30", want.Truncate(time.Minute)},
+		{"SQLite date", "2024-03-01", want.Truncate(24 * time.Hour)},
+		{"surrounding space", " 2024-03-01 10:30:15 \n", want.Truncate(time.Second)},
+		{"NULL", nil, time.Time{}},
+	} {
+		got := time.Now() // NULL must reset it
+		if err := scanTimestamp("created_at", &got).Scan(tc.src); err != nil {
+			t.Errorf("%s: Scan(%#v) failed: %v", tc.name, tc.src, err)
+			continue
+		}
+		if !got.Equal(tc.want) || (!got.IsZero() && got.Location() != time.UTC) {
+			t.Errorf("%s: Scan(%#v) = %v, want %v in UTC", tc.name, tc.src, got, tc.want)
+		}
+	}
+
+	// Every format SQLite writes is understood. Those without a zone are
+	// read as UTC, the others are converted to it.
+	midnight := time.Date(2024, 3, 1, 0, 0, 0, 0, berlin)
+	for _, layout := range sqlite3.SQLiteTimestampFormats {
+		var got time.Time
+		value := midnight.Format(layout)
+		if err := scanTimestamp("created_at", &got).Scan(value); err != nil {
+			t.Errorf("Scan(%q) failed: %v", value, err)
+			continue
+		}
+		want := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+		if strings.HasSuffix(layout, "-07:00") {
+			want = midnight
+		}
+		if !got.Equal(want) || got.Location() != time.UTC {
+			t.Errorf("Scan(%q) = %v, want %v", value, got, want.UTC())
+		}
+	}
+}
+
+func TestScanTimestampErrors(t *testing.T) {
+	for _, src := range []interface{}{
+		"yesterday",
+		"2024-13-01 10:30:15",
+		[]byte("01/03/2024"),
+		"",
+		3.5,
+		true,
+	} {
+		var got time.Time
+		err := scanTimestamp("deleted_at", &got).Scan(src)
+
+		var scanErr *ScanError
+		if !errors.As(err, &scanErr) {
+			t.Errorf("Scan(%#v) = %v, want a ScanError", src, err)
+			continue
+		}
+		if scanErr.Column != "deleted_at" {
+			t.Errorf("Scan(%#v) blamed column %q", src, scanErr.Column)
+		}
+		if _, ok := src.(float64); ok && !errors.Is(err, ErrUnsupportedType) {
+			t.Errorf("Scan(%#v) = %v, want ErrUnsupportedType", src, err)
+		}
+	}
+}
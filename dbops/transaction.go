@@ -0,0 +1,173 @@
+package dbops
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	apperrors "error-handling-demo/errors"
+	"error-handling-demo/utils"
+)
+
+// TxOptions configures how RunInTransaction executes a transaction
+type TxOptions struct {
+	Isolation sql.IsolationLevel // Isolation level passed to BeginTx
+	ReadOnly  bool               // Whether the transaction only reads data
+	// Retry controls how often the whole transaction is re-run when it fails
+	// with a retriable error (busy, locked or a serialization failure).
+	// If Retry.RetryableFunc is nil, IsRetriable is used.
+	Retry utils.RetryOptions
+}
+
+// DefaultTxOptions provides sensible defaults for RunInTransaction
+func DefaultTxOptions() TxOptions {
+	retry := utils.DefaultRetryOptions()
+	retry.MaxRetries = 5
+	retry.BaseDelay = 50 * time.Millisecond
+	retry.MaxDelay = 2 * time.Second
+	retry.RetryableFunc = IsRetriable
+
+	return TxOptions{
+		Isolation: sql.LevelDefault,
+		Retry:     retry,
+	}
+}
+
+// RollbackError is returned when a transaction failed and rolling it back
+// failed as well. It unwraps to the original error so errors.Is and
+// errors.As keep working on the reason the transaction failed.
+type RollbackError struct {
+	Err         error // The error that caused the rollback
+	RollbackErr error // The error returned by the rollback itself
+}
+
+// Error implements the error interface
+func (e *RollbackError) Error() string {
+	return fmt.Sprintf("%v (rollback also failed: %v)", e.Err, e.RollbackErr)
+}
+
+// Unwrap returns the error that caused the rollback
+func (e *RollbackError) Unwrap() error {
+	return e.Err
+}
+
+// RunInTransaction executes fn within a database transaction, committing if
+// fn returns nil and rolling back otherwise. If the transaction fails with a
+// retriable error the whole of fn is run again in a fresh transaction, so fn
+// must not have side effects outside of tx.
+func RunInTransaction(ctx context.Context, db *DB, opts TxOptions, fn func(*Tx) error) error {
+	retry := opts.Retry
+	if retry.RetryableFunc == nil {
+		retry.RetryableFunc = IsRetriable
+	}
+
+	return utils.Retry(ctx, func() error {
+		return runTransactionOnce(ctx, db, opts, fn)
+	}, retry)
+}
+
+// runTransactionOnce makes a single attempt at running fn in a transaction
+func runTransactionOnce(ctx context.Context, db *DB, opts TxOptions, fn func(*Tx) error) error {
+	// Start a transaction
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{
+		Isolation: opts.Isolation,
+		ReadOnly:  opts.ReadOnly,
+	})
+	if err != nil {
+		return wrapDBError("begin transaction", "", err)
+	}
+
+	// Execute the function, making sure a panic doesn't leave the transaction open
+	if err := runGuarded(tx, fn); err != nil {
+		return rollback(tx, classifyTxError(err))
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return wrapDBError("commit transaction", "", err)
+	}
+
+	return nil
+}
+
+// runGuarded calls fn and rolls back the transaction before re-panicking
+// if fn panics
+func runGuarded(tx *Tx, fn func(*Tx) error) error {
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		}
+	}()
+	return fn(tx)
+}
+
+// rollback rolls back tx and reports a failed rollback alongside cause
+// instead of discarding it
+func rollback(tx *Tx, cause error) error {
+	if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+		return &RollbackError{
+			Err:         cause,
+			RollbackErr: wrapDBError("rollback transaction", "", err),
+		}
+	}
+	return cause
+}
+
+// classifyTxError converts raw driver errors returned by a transaction
+// function into DatabaseErrors so that retriable failures can be detected.
+// Other errors are returned untouched.
+func classifyTxError(err error) error {
+	var dbErr *apperrors.DatabaseError
+	if errors.As(err, &dbErr) {
+		return err
+	}
+	if _, ok := classifyDriverError(err); ok {
+		return wrapDBError("transaction", "", err)
+	}
+	return err
+}
+
+// savepointSeq generates unique savepoint names
+var savepointSeq uint64
+
+// WithSavepoint runs fn inside a SAVEPOINT on an existing transaction,
+// giving nested-transaction semantics: if fn fails only its own changes are
+// rolled back and the outer transaction can continue.
+func WithSavepoint(ctx context.Context, tx *Tx, fn func(*Tx) error) error {
+	name := fmt.Sprintf("sp_%d", atomic.AddUint64(&savepointSeq, 1))
+
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return wrapDBError("create savepoint", "", err)
+	}
+
+	if err := fn(tx); err != nil {
+		cause := classifyTxError(err)
+
+		// ROLLBACK TO undoes the changes but leaves the savepoint on the
+		// stack, so it still has to be released afterwards
+		if _, rbErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); rbErr != nil {
+			return &RollbackError{
+				Err:         cause,
+				RollbackErr: wrapDBError("rollback to savepoint", "", rbErr),
+			}
+		}
+		if _, relErr := tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name); relErr != nil {
+			return &RollbackError{
+				Err:         cause,
+				RollbackErr: wrapDBError("release savepoint", "", relErr),
+			}
+		}
+		return cause
+	}
+
+	if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name); err != nil {
+		return wrapDBError("release savepoint", "", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,251 @@
+package dbops
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+
+	apperrors "error-handling-demo/errors"
+)
+
+// openTestDB returns a migrated SQLite database in a temporary directory
+func openTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := InitDatabase(filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatalf("InitDatabase failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// usernames returns the usernames stored in db, in id order
+func usernames(t *testing.T, db *DB) []string {
+	t.Helper()
+	rows, err := db.QueryContext(context.Background(), "SELECT username FROM users ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+// insertName is a transaction function adding a user called name
+func insertName(name string) func(*Tx) error {
+	return func(tx *Tx) error {
+		_, err := tx.ExecContext(context.Background(), "INSERT INTO users (username, email) VALUES (?, ?)", name, name+"@example.com")
+		return err
+	}
+}
+
+// fastRetries retries max times without waiting long
+func fastRetries(max int) TxOptions {
+	opts := DefaultTxOptions()
+	opts.Retry.MaxRetries = max
+	opts.Retry.BaseDelay = time.Millisecond
+	opts.Retry.MaxDelay = time.Millisecond
+	return opts
+}
+
+func TestRunInTransactionRetries(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	// A busy database is retried until it gives up
+	attempts := 0
+	err := RunInTransaction(ctx, db, fastRetries(3), func(tx *Tx) error {
+		attempts++
+		if err := insertName("jane")(tx); err != nil {
+			return err
+		}
+		return sqlite3.Error{Code: sqlite3.ErrBusy}
+	})
+	if attempts != 4 {
+		t.Errorf("a busy transaction ran %d times, want 4", attempts)
+	}
+	var dbErr *apperrors.DatabaseError
+	if !errors.Is(err, ErrDatabaseBusy) || !errors.As(err, &dbErr) || !dbErr.Retriable {
+		t.Errorf("RunInTransaction returned %v, want a retriable DatabaseError", err)
+	}
+	if names := usernames(t, db); len(names) != 0 {
+		t.Errorf("failed attempts left %v behind", names)
+	}
+
+	// A locked database that frees up commits the last attempt only
+	attempts = 0
+	err = RunInTransaction(ctx, db, fastRetries(3), func(tx *Tx) error {
+		attempts++
+		if err := insertName("jane")(tx); err != nil {
+			return err
+		}
+		if attempts < 3 {
+			return errors.Wrap(sqlite3.Error{Code: sqlite3.ErrLocked}, "updating jane")
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Errorf("RunInTransaction returned %v after %d attempts, want success after 3", err, attempts)
+	}
+	if names := usernames(t, db); len(names) != 1 || names[0] != "jane" {
+		t.Errorf("users = %v, want [jane]", names)
+	}
+
+	// Other errors are returned as they are without a retry
+	attempts = 0
+	failure := errors.New("invalid user")
+	err = RunInTransaction(ctx, db, fastRetries(3), func(tx *Tx) error {
+		attempts++
+		return failure
+	})
+	if err != failure || attempts != 1 {
+		t.Errorf("RunInTransaction returned %v after %d attempts, want %v after 1", err, attempts, failure)
+	}
+}
+
+func TestRunInTransactionPanic(t *testing.T) {
+	db := openTestDB(t)
+
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("recovered %v, want the panic from fn", r)
+			}
+		}()
+		RunInTransaction(context.Background(), db, fastRetries(0), func(tx *Tx) error {
+			if err := insertName("jane")(tx); err != nil {
+				t.Fatal(err)
+			}
+			panic("boom")
+		})
+		t.Error("RunInTransaction didn't re-panic")
+	}()
+
+	if names := usernames(t, db); len(names) != 0 {
+		t.Errorf("a panicking transaction left %v behind", names)
+	}
+	// The connection was released, so the database is still usable
+	if err := ExecuteInTransaction(context.Background(), db, insertName("john")); err != nil {
+		t.Errorf("transaction after a panic failed: %v", err)
+	}
+}
+
+func TestRunInTransactionRollbackFails(t *testing.T) {
+	db, err := OpenDatabase("postgres://app@db.example.com/users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	postgresStub.reset(nil)
+	postgresStub.failRollback(errors.New("connection reset"))
+	defer postgresStub.reset(nil)
+
+	failure := errors.New("invalid user")
+	err = RunInTransaction(context.Background(), db, fastRetries(0), func(tx *Tx) error {
+		return failure
+	})
+
+	var rbErr *RollbackError
+	if !errors.As(err, &rbErr) {
+		t.Fatalf("RunInTransaction returned %v, want a RollbackError", err)
+	}
+	if rbErr.Err != failure || !errors.Is(err, failure) {
+		t.Errorf("RollbackError doesn't carry the original error: %v", err)
+	}
+	var dbErr *apperrors.DatabaseError
+	if !errors.As(rbErr.RollbackErr, &dbErr) || dbErr.Operation != "rollback transaction" {
+		t.Errorf("RollbackErr = %v, want a DatabaseError from the rollback", rbErr.RollbackErr)
+	}
+}
+
+func TestWithSavepoint(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	failure := errors.New("invalid user")
+	err := ExecuteInTransaction(ctx, db, func(tx *Tx) error {
+		if err := insertName("jane")(tx); err != nil {
+			return err
+		}
+
+		// A failing savepoint undoes only its own work, nested ones included
+		err := WithSavepoint(ctx, tx, func(tx *Tx) error {
+			if err := insertName("john")(tx); err != nil {
+				return err
+			}
+			if err := WithSavepoint(ctx, tx, insertName("jim")); err != nil {
+				return err
+			}
+			return failure
+		})
+		if err != failure {
+			t.Errorf("WithSavepoint returned %v, want %v", err, failure)
+		}
+
+		// A failing nested savepoint leaves its parent's work in place
+		return WithSavepoint(ctx, tx, func(tx *Tx) error {
+			if err := insertName("joan")(tx); err != nil {
+				return err
+			}
+			if err := WithSavepoint(ctx, tx, func(tx *Tx) error {
+				if err := insertName("jack")(tx); err != nil {
+					return err
+				}
+				return failure
+			}); err != failure {
+				t.Errorf("nested WithSavepoint returned %v, want %v", err, failure)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("transaction failed: %v", err)
+	}
+
+	if names := usernames(t, db); len(names) != 2 || names[0] != "jane" || names[1] != "joan" {
+		t.Errorf("users = %v, want [jane joan]", names)
+	}
+
+	// Driver errors from a savepoint are classified
+	err = ExecuteInTransaction(ctx, db, func(tx *Tx) error {
+		return WithSavepoint(ctx, tx, insertName("jane"))
+	})
+	if !errors.Is(err, ErrDuplicateUser) {
+		t.Errorf("duplicate insert in a savepoint returned %v, want ErrDuplicateUser", err)
+	}
+}
+
+func TestClassifyTxError(t *testing.T) {
+	// Driver errors become DatabaseErrors
+	err := classifyTxError(errors.Wrap(sqlite3.Error{Code: sqlite3.ErrBusy}, "inserting"))
+	var dbErr *apperrors.DatabaseError
+	if !errors.As(err, &dbErr) || dbErr.Reason != apperrors.ReasonBusy || !IsRetriable(err) {
+		t.Errorf("classifyTxError(busy) = %v, want a retriable DatabaseError", err)
+	}
+
+	// DatabaseErrors and other errors are left alone
+	for _, err := range []error{
+		wrapDBError("insert user", "users", sqlite3.Error{Code: sqlite3.ErrLocked}),
+		errors.New("invalid user"),
+		context.Canceled,
+	} {
+		if got := classifyTxError(err); got != err {
+			t.Errorf("classifyTxError(%v) = %v, want it unchanged", err, got)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package dbops
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Page size limits for ListUsers
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 1000
+)
+
+// ErrInvalidListOptions is returned when ListOptions can't be turned into a query
+var ErrInvalidListOptions = errors.New("invalid list options")
+
+// UserOrder selects the order users are listed in
+type UserOrder int
+
+// Supported orderings. Ties are always broken by ID so that keyset
+// pagination is stable.
+const (
+	OrderByIDAsc UserOrder = iota
+	OrderByIDDesc
+	OrderByUsernameAsc
+	OrderByUsernameDesc
+	OrderByCreatedAtAsc
+	OrderByCreatedAtDesc
+)
+
+// UserFilter restricts which users are returned. Zero-valued fields are ignored.
+// UsernamePrefix and EmailDomain ignore case on every database, although
+// SQLite only folds the case of ASCII letters.
+type UserFilter struct {
+	UsernamePrefix string    // Only usernames starting with this prefix
+	EmailDomain    string    // Only emails at this domain, e.g. "example.com"
+	CreatedAfter   time.Time // Only users created at or after this time
+	CreatedBefore  time.Time // Only users created before this time
+}
+
+// ListOptions configures ListUsers and StreamUsers
+type ListOptions struct {
+	Limit  int // Maximum number of users to return; 0 uses DefaultPageSize for ListUsers and no limit for StreamUsers
+	After  int // Cursor: only return users after the user with this ID in the chosen order
+	Order  UserOrder
+	Filter UserFilter
+	// IncludeDeleted also returns soft-deleted users
+	IncludeDeleted bool
+}
+
+// UserPage is one page of ListUsers results
+type UserPage struct {
+	Users []*User
+	// NextCursor is the value to pass as ListOptions.After to fetch the
+	// next page, or 0 if this is the last page
+	NextCursor int
+}
+
+// StreamError reports how far StreamUsers got before it was stopped by a
+// cancelled context, a database error or the callback
+type StreamError struct {
+	Processed int // Number of users successfully passed to the callback
+	LastID    int // ID of the last user passed to the callback, 0 if none
+	Err       error
+}
+
+// Error implements the error interface
+func (e *StreamError) Error() string {
+	return fmt.Sprintf("user stream stopped after %d users (last id %d): %v", e.Processed, e.LastID, e.Err)
+}
+
+// Unwrap returns the reason the stream stopped
+func (e *StreamError) Unwrap() error {
+	return e.Err
+}
+
+// ListUsers returns one keyset-paginated page of users matching opts
+func ListUsers(ctx context.Context, db *DB, opts ListOptions) (*UserPage, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultPageSize
+	}
+	if opts.Limit > MaxPageSize {
+		opts.Limit = MaxPageSize
+	}
+
+	// Fetch one extra row to find out whether there is another page
+	query, args, err := buildUserQuery(db.Dialect(), opts, opts.Limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, wrapDBError("list users", "users", err)
+	}
+	defer rows.Close()
+
+	page := &UserPage{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, wrapDBError("list users", "users", err)
+		}
+		page.Users = append(page.Users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, wrapDBError("list users", "users", err)
+	}
+
+	if len(page.Users) > opts.Limit {
+		page.Users = page.Users[:opts.Limit]
+		page.NextCursor = page.Users[opts.Limit-1].ID
+	}
+
+	return page, nil
+}
+
+// StreamUsers calls fn for each user matching opts without loading them all
+// into memory. The context is checked before every row; if it is cancelled,
+// or the query or fn fails, the returned *StreamError records how many users
+// were processed so the caller can resume from LastID.
+func StreamUsers(ctx context.Context, db *DB, opts ListOptions, fn func(*User) error) error {
+	query, args, err := buildUserQuery(db.Dialect(), opts, opts.Limit)
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return &StreamError{Err: wrapDBError("stream users", "users", err)}
+	}
+	defer rows.Close()
+
+	progress := &StreamError{}
+	for rows.Next() {
+		// Stop promptly if the caller has given up
+		if err := ctx.Err(); err != nil {
+			progress.Err = err
+			return progress
+		}
+
+		user, err := scanUser(rows)
+		if err != nil {
+			progress.Err = wrapDBError("stream users", "users", err)
+			return progress
+		}
+
+		if err := fn(user); err != nil {
+			progress.Err = err
+			return progress
+		}
+
+		progress.Processed++
+		progress.LastID = user.ID
+	}
+
+	// A cancelled context usually surfaces here, as the driver aborts the query
+	if err := rows.Err(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		} else {
+			err = wrapDBError("stream users", "users", err)
+		}
+		progress.Err = err
+		return progress
+	}
+
+	return nil
+}
+
+// buildUserQuery builds the SELECT statement for opts. A limit of 0 means no limit.
+func buildUserQuery(dialect Dialect, opts ListOptions, limit int) (string, []interface{}, error) {
+	var conditions []string
+	var args []interface{}
+
+	// Filters. LIKE ignores case on SQLite and MySQL but not on PostgreSQL,
+	// so both sides are lowered to get the same answer everywhere.
+	if !opts.IncludeDeleted {
+		conditions = append(conditions, "deleted_at IS NULL")
+	}
+	if opts.Filter.UsernamePrefix != "" {
+		conditions = append(conditions, `LOWER(username) LIKE LOWER(?) ESCAPE '!'`)
+		args = append(args, escapeLike(opts.Filter.UsernamePrefix)+"%")
+	}
+	if opts.Filter.EmailDomain != "" {
+		conditions = append(conditions, `LOWER(email) LIKE LOWER(?) ESCAPE '!'`)
+		args = append(args, "%@"+escapeLike(strings.TrimPrefix(opts.Filter.EmailDomain, "@")))
+	}
+	if !opts.Filter.CreatedAfter.IsZero() {
+		conditions = append(conditions, dialect.TimestampCondition("created_at", ">="))
+		args = append(args, opts.Filter.CreatedAfter.UTC())
+	}
+	if !opts.Filter.CreatedBefore.IsZero() {
+		conditions = append(conditions, dialect.TimestampCondition("created_at", "<"))
+		args = append(args, opts.Filter.CreatedBefore.UTC())
+	}
+
+	// Ordering and keyset cursor
+	column, direction, err := orderColumn(opts.Order)
+	if err != nil {
+		return "", nil, err
+	}
+	comparison := ">"
+	if direction == "DESC" {
+		comparison = "<"
+	}
+	if opts.After > 0 {
+		if column == "id" {
+			conditions = append(conditions, "id "+comparison+" ?")
+			args = append(args, opts.After)
+		} else {
+			// Compare (column, id) against the cursor row so ties stay in order
+			conditions = append(conditions, fmt.Sprintf(
+				"(%[1]s, id) %[2]s ((SELECT %[1]s FROM users WHERE id = ?), ?)", column, comparison))
+			args = append(args, opts.After, opts.After)
+		}
+	}
+
+	query := `SELECT ` + userColumns + ` FROM users`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	if column == "id" {
+		query += " ORDER BY id " + direction
+	} else {
+		query += fmt.Sprintf(" ORDER BY %s %s, id %s", column, direction, direction)
+	}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	return query, args, nil
+}
+
+// orderColumn maps a UserOrder to its column and sort direction
+func orderColumn(order UserOrder) (string, string, error) {
+	switch order {
+	case OrderByIDAsc:
+		return "id", "ASC", nil
+	case OrderByIDDesc:
+		return "id", "DESC", nil
+	case OrderByUsernameAsc:
+		return "username", "ASC", nil
+	case OrderByUsernameDesc:
+		return "username", "DESC", nil
+	case OrderByCreatedAtAsc:
+		return "created_at", "ASC", nil
+	case OrderByCreatedAtDesc:
+		return "created_at", "DESC", nil
+	default:
+		return "", "", errors.Wrapf(ErrInvalidListOptions, "unknown order %d", order)
+	}
+}
+
+// escapeLike escapes the LIKE wildcards in s so it is matched literally.
+// '!' is used as the escape character because a backslash means different
+// things in the string literals of different databases.
+func escapeLike(s string) string {
+	replacer := strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`)
+	return replacer.Replace(s)
+}
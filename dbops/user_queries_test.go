@@ -0,0 +1,279 @@
+package dbops
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// seedUser stores a user with a given creation time, soft-deleted if deleted is set
+func seedUser(t *testing.T, db *DB, id int, username, email string, createdAt time.Time, deleted bool) {
+	t.Helper()
+	var deletedAt interface{}
+	if deleted {
+		deletedAt = createdAt.Add(time.Hour)
+	}
+	_, err := db.ExecContext(context.Background(),
+		"INSERT INTO users (id, username, email, created_at, deleted_at) VALUES (?, ?, ?, ?, ?)",
+		id, username, email, createdAt, deletedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// userIDs returns the IDs of users
+func userIDs(users []*User) []int {
+	ids := make([]int, len(users))
+	for i, user := range users {
+		ids[i] = user.ID
+	}
+	return ids
+}
+
+// equalIDs reports whether two ID lists are the same
+func equalIDs(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestListUsersPaging(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	// Several users share a creation time, so pages have to break ties by ID
+	day := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	users := []struct {
+		id       int
+		username string
+		created  time.Time
+	}{
+		{1, "mia", day},
+		{2, "bob", day.Add(time.Hour)},
+		{3, "zoe", day},
+		{4, "amy", day.Add(-time.Hour)},
+		{5, "kai", day},
+		{6, "eve", day.Add(time.Hour)},
+		{7, "lea", day},
+	}
+	for _, u := range users {
+		seedUser(t, db, u.id, u.username, u.username+"@example.com", u.created, false)
+	}
+
+	for _, tc := range []struct {
+		order UserOrder
+		less  func(i, j int) bool
+	}{
+		{OrderByIDAsc, func(i, j int) bool { return users[i].id < users[j].id }},
+		{OrderByIDDesc, func(i, j int) bool { return users[i].id > users[j].id }},
+		{OrderByUsernameAsc, func(i, j int) bool { return users[i].username < users[j].username }},
+		{OrderByUsernameDesc, func(i, j int) bool { return users[i].username > users[j].username }},
+		{OrderByCreatedAtAsc, func(i, j int) bool {
+			if !users[i].created.Equal(users[j].created) {
+				return users[i].created.Before(users[j].created)
+			}
+			return users[i].id < users[j].id
+		}},
+		{OrderByCreatedAtDesc, func(i, j int) bool {
+			if !users[i].created.Equal(users[j].created) {
+				return users[i].created.After(users[j].created)
+			}
+			return users[i].id > users[j].id
+		}},
+	} {
+		order := make([]int, len(users))
+		for i := range order {
+			order[i] = i
+		}
+		sort.Slice(order, func(a, b int) bool { return tc.less(order[a], order[b]) })
+		var want []int
+		for _, i := range order {
+			want = append(want, users[i].id)
+		}
+
+		// Walk the pages two users at a time
+		var got []int
+		opts := ListOptions{Limit: 2, Order: tc.order}
+		for pages := 0; pages < 10; pages++ {
+			page, err := ListUsers(ctx, db, opts)
+			if err != nil {
+				t.Fatalf("order %d: ListUsers failed: %v", tc.order, err)
+			}
+			got = append(got, userIDs(page.Users)...)
+			if page.NextCursor == 0 {
+				break
+			}
+			opts.After = page.NextCursor
+		}
+		if !equalIDs(got, want) {
+			t.Errorf("order %d: pages returned %v, want %v", tc.order, got, want)
+		}
+	}
+
+	// The last page has no cursor, even when it is full
+	page, err := ListUsers(ctx, db, ListOptions{Limit: 7})
+	if err != nil || len(page.Users) != 7 || page.NextCursor != 0 {
+		t.Errorf("ListUsers(Limit 7) = %v, cursor %d, %v", userIDs(page.Users), page.NextCursor, err)
+	}
+
+	if _, err := ListUsers(ctx, db, ListOptions{Order: UserOrder(42)}); !errors.Is(err, ErrInvalidListOptions) {
+		t.Errorf("ListUsers with an unknown order returned %v", err)
+	}
+}
+
+func TestListUsersFilters(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	day := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	seedUser(t, db, 1, "a_bob", "bob@example.com", day, false)
+	seedUser(t, db, 2, "axbob", "axbob@EXAMPLE.com", day, false)
+	seedUser(t, db, 3, "50%off", "sale@example.com.evil", day.Add(time.Hour), false)
+	seedUser(t, db, 4, "500", "five@sub.example.com", day.Add(2*time.Hour), false)
+	seedUser(t, db, 5, "x!y", "x@other.org", day.Add(3*time.Hour), false)
+	seedUser(t, db, 6, "xy", "xy@other.org", day.Add(4*time.Hour), false)
+	seedUser(t, db, 7, "Jane", "jane@example.com", day, false)
+	seedUser(t, db, 8, "a_bobby", "gone@example.com", day, true)
+
+	for _, tc := range []struct {
+		name string
+		opts ListOptions
+		want []int
+	}{
+		{"all live users", ListOptions{}, []int{1, 2, 3, 4, 5, 6, 7}},
+		{"deleted too", ListOptions{IncludeDeleted: true}, []int{1, 2, 3, 4, 5, 6, 7, 8}},
+		{"underscore is literal", ListOptions{Filter: UserFilter{UsernamePrefix: "a_"}}, []int{1}},
+		{"percent is literal", ListOptions{Filter: UserFilter{UsernamePrefix: "50%"}}, []int{3}},
+		{"escape character is literal", ListOptions{Filter: UserFilter{UsernamePrefix: "x!"}}, []int{5}},
+		{"prefix ignores case", ListOptions{Filter: UserFilter{UsernamePrefix: "jA"}}, []int{7}},
+		{"deleted prefix match", ListOptions{Filter: UserFilter{UsernamePrefix: "a_"}, IncludeDeleted: true}, []int{1, 8}},
+		{"email domain", ListOptions{Filter: UserFilter{EmailDomain: "example.com"}}, []int{1, 2, 7}},
+		{"email domain with @", ListOptions{Filter: UserFilter{EmailDomain: "@other.org"}}, []int{5, 6}},
+		{"created after", ListOptions{Filter: UserFilter{CreatedAfter: day.Add(2 * time.Hour)}}, []int{4, 5, 6}},
+		{"created before", ListOptions{Filter: UserFilter{CreatedBefore: day.Add(time.Hour)}}, []int{1, 2, 7}},
+		{"combined", ListOptions{Filter: UserFilter{UsernamePrefix: "x", CreatedBefore: day.Add(4 * time.Hour)}}, []int{5}},
+	} {
+		page, err := ListUsers(ctx, db, tc.opts)
+		if err != nil {
+			t.Errorf("%s: ListUsers failed: %v", tc.name, err)
+			continue
+		}
+		if got := userIDs(page.Users); !equalIDs(got, tc.want) {
+			t.Errorf("%s: ListUsers returned %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	// Soft-deleted users come back marked as such
+	page, err := ListUsers(ctx, db, ListOptions{After: 7, IncludeDeleted: true})
+	if err != nil || len(page.Users) != 1 || !page.Users[0].IsDeleted() {
+		t.Errorf("ListUsers(IncludeDeleted) = %+v, %v; want deleted user 8", page, err)
+	}
+}
+
+func TestBuildUserQuery(t *testing.T) {
+	query, args, err := buildUserQuery(postgresDialect{}, ListOptions{
+		After:  5,
+		Order:  OrderByUsernameDesc,
+		Filter: UserFilter{UsernamePrefix: "a_b!", EmailDomain: "@50%.org"},
+	}, 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, part := range []string{
+		"deleted_at IS NULL",
+		"LOWER(username) LIKE LOWER(?) ESCAPE '!'",
+		"LOWER(email) LIKE LOWER(?) ESCAPE '!'",
+		"(username, id) < ((SELECT username FROM users WHERE id = ?), ?)",
+		"ORDER BY username DESC, id DESC LIMIT ?",
+	} {
+		if !strings.Contains(query, part) {
+			t.Errorf("query %q lacks %q", query, part)
+		}
+	}
+	want := []interface{}{"a!_b!!%", "%@50!%.org", 5, 5, 11}
+	if len(args) != len(want) {
+		t.Fatalf("args = %v, want %v", args, want)
+	}
+	for i := range want {
+		if args[i] != want[i] {
+			t.Errorf("args = %v, want %v", args, want)
+			break
+		}
+	}
+
+	// Without a limit there's no LIMIT
+	if query, _, _ := buildUserQuery(sqliteDialect{}, ListOptions{}, 0); strings.Contains(query, "LIMIT") {
+		t.Errorf("unlimited query %q has a LIMIT", query)
+	}
+}
+
+func TestStreamUsers(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	day := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for id := 1; id <= 6; id++ {
+		seedUser(t, db, id, "user"+strconv.Itoa(id), "u@example.com", day, id == 4)
+	}
+
+	var seen []int
+	err := StreamUsers(ctx, db, ListOptions{}, func(u *User) error {
+		seen = append(seen, u.ID)
+		return nil
+	})
+	if err != nil || !equalIDs(seen, []int{1, 2, 3, 5, 6}) {
+		t.Errorf("StreamUsers saw %v, %v; want the live users", seen, err)
+	}
+
+	// Cancelling stops the stream and reports how far it got
+	cancelCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	seen = nil
+	err = StreamUsers(cancelCtx, db, ListOptions{}, func(u *User) error {
+		seen = append(seen, u.ID)
+		if len(seen) == 2 {
+			cancel()
+		}
+		return nil
+	})
+	var streamErr *StreamError
+	if !errors.As(err, &streamErr) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled StreamUsers returned %v", err)
+	}
+	if streamErr.Processed != 2 || streamErr.LastID != 2 || len(seen) != 2 {
+		t.Errorf("cancelled stream processed %d up to %d after seeing %v, want 2 up to 2", streamErr.Processed, streamErr.LastID, seen)
+	}
+
+	// Resuming from LastID picks up the rest
+	seen = nil
+	err = StreamUsers(ctx, db, ListOptions{After: streamErr.LastID}, func(u *User) error {
+		seen = append(seen, u.ID)
+		return nil
+	})
+	if err != nil || !equalIDs(seen, []int{3, 5, 6}) {
+		t.Errorf("resumed StreamUsers saw %v, %v", seen, err)
+	}
+
+	// A failing callback isn't counted as processed
+	failure := errors.New("export failed")
+	err = StreamUsers(ctx, db, ListOptions{IncludeDeleted: true}, func(u *User) error {
+		if u.ID == 4 {
+			return failure
+		}
+		return nil
+	})
+	if !errors.As(err, &streamErr) || streamErr.Err != failure || streamErr.Processed != 3 || streamErr.LastID != 3 {
+		t.Errorf("StreamUsers with a failing callback returned %v", err)
+	}
+}
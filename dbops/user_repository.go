@@ -0,0 +1,176 @@
+package dbops
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+
+	apperrors "error-handling-demo/errors"
+	"error-handling-demo/models"
+	"error-handling-demo/utils"
+)
+
+// UserRepository implements models.UserRepository on top of a DB. Deleting a
+// user soft-deletes it, so its username stays taken until it is purged.
+type UserRepository struct {
+	db *DB
+}
+
+// Check that UserRepository implements the interface
+var _ models.UserRepository = (*UserRepository)(nil)
+
+// NewUserRepository creates a repository storing users in db
+func NewUserRepository(db *DB) *UserRepository {
+	return &UserRepository{db: db}
+}
+
+// FindByID returns the user with the given ID
+func (r *UserRepository) FindByID(id int) (*models.User, error) {
+	user, err := GetUser(context.Background(), r.db, id)
+	if err != nil {
+		return nil, err
+	}
+	return toModel(user), nil
+}
+
+// FindByUsername returns the user with the given username
+func (r *UserRepository) FindByUsername(username string) (*models.User, error) {
+	user, err := GetUserByUsername(context.Background(), r.db, username)
+	if err != nil {
+		return nil, err
+	}
+	return toModel(user), nil
+}
+
+// Create validates and stores a new user. The database assigns the ID if
+// user.ID is 0; user.ID and user.CreatedAt are filled in from the stored row.
+func (r *UserRepository) Create(user *models.User) error {
+	if err := user.Validate(); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var stored *User
+	err := ExecuteInTransaction(ctx, r.db, func(tx *Tx) error {
+		var err error
+		if user.ID == 0 {
+			_, err = tx.ExecContext(ctx, `INSERT INTO users (username, email) VALUES (?, ?)`,
+				user.Username, user.Email)
+		} else {
+			_, err = tx.ExecContext(ctx, `INSERT INTO users (id, username, email) VALUES (?, ?, ?)`,
+				user.ID, user.Username, user.Email)
+		}
+		if err != nil {
+			return duplicateUserError(wrapDBError("create user", "users", err))
+		}
+		if user.ID != 0 {
+			if err := syncUserSequence(ctx, tx); err != nil {
+				return err
+			}
+		}
+
+		// Read the row back for the assigned ID and creation time; looking
+		// it up by username works the same way on every dialect
+		stored, err = scanUser(tx.QueryRowContext(ctx,
+			`SELECT `+userColumns+` FROM users WHERE username = ?`, user.Username))
+		if err != nil {
+			return wrapDBError("create user", "users", err)
+		}
+
+		return recordAudit(ctx, tx, stored.ID, AuditCreate, map[string]FieldChange{
+			"username": {To: stored.Username},
+			"email":    {To: stored.Email},
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	user.ID = stored.ID
+	user.CreatedAt = stored.CreatedAt
+	return nil
+}
+
+// Update validates and stores the username and email of an existing user.
+// The repository interface has no versions, so the last writer wins: a
+// concurrent change between reading and writing the user is retried.
+func (r *UserRepository) Update(user *models.User) error {
+	if err := user.Validate(); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	retry := utils.DefaultRetryOptions()
+	retry.MaxRetries = 5
+	retry.BaseDelay = 10 * time.Millisecond
+	retry.RetryableFunc = func(err error) bool {
+		return errors.Is(err, ErrConflict)
+	}
+
+	return utils.Retry(ctx, func() error {
+		current, err := GetUser(ctx, r.db, user.ID)
+		if err != nil {
+			return err
+		}
+
+		err = UpdateUser(ctx, r.db, User{
+			ID:       user.ID,
+			Username: user.Username,
+			Email:    user.Email,
+			Version:  current.Version,
+		})
+		if err != nil {
+			return err
+		}
+
+		user.CreatedAt = current.CreatedAt
+		return nil
+	}, retry)
+}
+
+// Delete soft-deletes the user with the given ID
+func (r *UserRepository) Delete(id int) error {
+	return DeleteUser(context.Background(), r.db, id)
+}
+
+// GetUserByUsername retrieves a user from the database by username.
+// Soft-deleted users are reported as ErrUserNotFound.
+func GetUserByUsername(ctx context.Context, db *DB, username string) (*User, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, db.queryTimeout)
+	defer cancel()
+
+	user, err := scanUser(db.QueryRowContext(queryCtx,
+		`SELECT `+userColumns+` FROM users WHERE username = ? AND deleted_at IS NULL`, username))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, wrapDBError("get user by username", "users", err)
+	}
+
+	return user, nil
+}
+
+// duplicateUserError makes a unique violation on any users column, such as
+// a taken ID, match ErrDuplicateUser as the repository contract requires
+func duplicateUserError(err error) error {
+	var dbErr *apperrors.DatabaseError
+	if errors.As(err, &dbErr) && dbErr.Reason == apperrors.ReasonUniqueViolation {
+		duplicate := *dbErr
+		duplicate.Sentinel = ErrDuplicateUser
+		return &duplicate
+	}
+	return err
+}
+
+// toModel converts a database user into the model
+func toModel(user *User) *models.User {
+	return &models.User{
+		ID:        user.ID,
+		Username:  user.Username,
+		Email:     user.Email,
+		CreatedAt: user.CreatedAt,
+	}
+}
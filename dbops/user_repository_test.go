@@ -0,0 +1,24 @@
+package dbops_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"error-handling-demo/dbops"
+	"error-handling-demo/models"
+	"error-handling-demo/models/modeltest"
+)
+
+func TestUserRepository(t *testing.T) {
+	modeltest.RunUserRepositoryTests(t, func(t *testing.T) models.UserRepository {
+		// A database file, unlike :memory:, lets concurrent calls use
+		// several connections and compete for the write lock
+		db, err := dbops.InitDatabase(filepath.Join(t.TempDir(), "users.db"))
+		if err != nil {
+			t.Fatalf("InitDatabase failed: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		return dbops.NewUserRepository(db)
+	})
+}
@@ -1,8 +1,10 @@
 package errors
 
 import (
+	stderrors "errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -16,33 +18,36 @@ var (
 	As     = errors.As
 	New    = errors.New
 	Errorf = fmt.Errorf
+	// Join combines errors into one that errors.Is and errors.As look into,
+	// e.g. a failed write and the failed cleanup after it
+	Join = stderrors.Join
 )
 
-// ValidationError represents a validation error for a specific field
-type ValidationError struct {
-	Field   string
-	Message string
-}
-
-// Error implements the error interface
-func (e *ValidationError) Error() string {
-	return fmt.Sprintf("validation error for field '%s': %s", e.Field, e.Message)
-}
-
 // NetworkError represents an error occurring during network operations
 type NetworkError struct {
-	URL       string
-	Op        string
-	Cause     error
-	Retriable bool
+	URL        string
+	Op         string
+	StatusCode int    // HTTP status of the response, 0 if there was none
+	Body       string // Start of the response body, see RedactBody
+	Cause      error
+	Retriable  bool
 }
 
-// Error implements the error interface
+// Error implements the error interface. It is meant for logs: the URL is
+// passed through RedactURL, so credentials and query parameters are left
+// out, but the host and path are not. Use PublicMessage for API clients.
 func (e *NetworkError) Error() string {
+	msg := fmt.Sprintf("%s operation failed for URL %s", e.Op, RedactURL(e.URL))
+	if e.StatusCode != 0 {
+		msg += fmt.Sprintf(" with status %d", e.StatusCode)
+	}
+	if e.Body != "" {
+		msg += fmt.Sprintf(" (body: %s)", e.Body)
+	}
 	if e.Cause != nil {
-		return fmt.Sprintf("%s operation failed for URL %s: %v", e.Op, e.URL, e.Cause)
+		return fmt.Sprintf("%s: %v", msg, e.Cause)
 	}
-	return fmt.Sprintf("%s operation failed for URL %s", e.Op, e.URL)
+	return msg
 }
 
 // Unwrap returns the underlying cause of the error
@@ -65,19 +70,48 @@ func NewNetworkError(url, op string, cause error, retriable bool) *NetworkError
 	}
 }
 
+// DatabaseErrorReason classifies why a database operation failed
+type DatabaseErrorReason string
+
+// Reasons a database operation can fail
+const (
+	ReasonUnknown             DatabaseErrorReason = "unknown"
+	ReasonUniqueViolation     DatabaseErrorReason = "unique_violation"
+	ReasonNotNullViolation    DatabaseErrorReason = "not_null_violation"
+	ReasonForeignKeyViolation DatabaseErrorReason = "foreign_key_violation"
+	ReasonConstraint          DatabaseErrorReason = "constraint_violation"
+	ReasonBusy                DatabaseErrorReason = "busy"
+	ReasonLocked              DatabaseErrorReason = "locked"
+	ReasonReadOnly            DatabaseErrorReason = "read_only"
+	ReasonCorrupt             DatabaseErrorReason = "corrupt"
+	ReasonFull                DatabaseErrorReason = "full"
+	ReasonConflict            DatabaseErrorReason = "conflict"
+)
+
 // DatabaseError represents an error occurring during database operations
 type DatabaseError struct {
 	Operation string
 	Table     string
-	Cause     error
+	Query     string        // The failing statement, without its arguments
+	Duration  time.Duration // How long the failing statement ran
+	Reason    DatabaseErrorReason
+	Retriable bool
+	// Sentinel is an optional well-known error (such as dbops.ErrDuplicateUser)
+	// that errors.Is should report as matching this error
+	Sentinel error
+	Cause    error
 }
 
 // Error implements the error interface
 func (e *DatabaseError) Error() string {
+	msg := fmt.Sprintf("database operation '%s' on table '%s' failed", e.Operation, e.Table)
+	if e.Reason != "" && e.Reason != ReasonUnknown {
+		msg += fmt.Sprintf(" (%s)", e.Reason)
+	}
 	if e.Cause != nil {
-		return fmt.Sprintf("database operation '%s' on table '%s' failed: %v", e.Operation, e.Table, e.Cause)
+		return fmt.Sprintf("%s: %v", msg, e.Cause)
 	}
-	return fmt.Sprintf("database operation '%s' on table '%s' failed", e.Operation, e.Table)
+	return msg
 }
 
 // Unwrap returns the underlying cause of the error
@@ -85,11 +119,22 @@ func (e *DatabaseError) Unwrap() error {
 	return e.Cause
 }
 
+// Is reports whether target is the sentinel error attached to this error
+func (e *DatabaseError) Is(target error) bool {
+	return e.Sentinel != nil && target == e.Sentinel
+}
+
+// IsRetriable returns whether the operation may succeed if attempted again
+func (e *DatabaseError) IsRetriable() bool {
+	return e.Retriable
+}
+
 // NewDatabaseError creates a new DatabaseError
 func NewDatabaseError(operation, table string, cause error) *DatabaseError {
 	return &DatabaseError{
 		Operation: operation,
 		Table:     table,
+		Reason:    ReasonUnknown,
 		Cause:     cause,
 	}
 }
@@ -113,6 +158,12 @@ func (e *MultiError) Error() string {
 	return fmt.Sprintf("multiple errors occurred: [%s]", strings.Join(errorMessages, "; "))
 }
 
+// Unwrap returns the combined errors, so errors.Is and errors.As look into
+// each of them
+func (e *MultiError) Unwrap() []error {
+	return e.Errors
+}
+
 // Add adds an error to the MultiError
 func (e *MultiError) Add(err error) {
 	if err != nil {
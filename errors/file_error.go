@@ -0,0 +1,98 @@
+package errors
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"syscall"
+)
+
+// FileErrorKind classifies why a file operation failed
+type FileErrorKind string
+
+// Kinds of file operation failures
+const (
+	KindUnknown    FileErrorKind = "unknown"
+	KindNotFound   FileErrorKind = "not_found"
+	KindPermission FileErrorKind = "permission"
+	KindExists     FileErrorKind = "exists"
+	KindNoSpace    FileErrorKind = "no_space"
+	KindReadOnly   FileErrorKind = "read_only"
+	KindIsDir      FileErrorKind = "is_dir"
+	KindTooLarge   FileErrorKind = "too_large"
+)
+
+// FileError represents an error occurring during a file operation. It
+// unwraps to an *fs.PathError, so errors.Is(err, fs.ErrNotExist) and
+// friends keep working, while Kind allows a switch over the common causes.
+type FileError struct {
+	Op   string // The operation as the caller knows it, e.g. "write"
+	Path string // The path as the caller knows it
+	Kind FileErrorKind
+	Err  *fs.PathError
+}
+
+// Error implements the error interface
+func (e *FileError) Error() string {
+	msg := fmt.Sprintf("file operation '%s' on '%s' failed", e.Op, e.Path)
+	if e.Kind != "" && e.Kind != KindUnknown {
+		msg += fmt.Sprintf(" (%s)", e.Kind)
+	}
+
+	if e.Err == nil {
+		return msg
+	}
+	// Only repeat the path of the underlying error if it is a different one,
+	// such as a temporary file
+	if e.Err.Path == e.Path {
+		return fmt.Sprintf("%s: %v", msg, e.Err.Err)
+	}
+	return fmt.Sprintf("%s: %v", msg, e.Err)
+}
+
+// Unwrap returns the underlying *fs.PathError, if any
+func (e *FileError) Unwrap() error {
+	if e.Err == nil {
+		return nil
+	}
+	return e.Err
+}
+
+// NewFileError creates a new FileError for err, which must not be nil. If
+// err is an *fs.PathError, such as those returned by package os, it is
+// kept as is; any other error is wrapped in one for op and path.
+func NewFileError(op, path string, err error) *FileError {
+	pathErr, ok := err.(*fs.PathError)
+	if !ok {
+		pathErr = &fs.PathError{Op: op, Path: path, Err: err}
+	}
+
+	return &FileError{
+		Op:   op,
+		Path: path,
+		Kind: ClassifyFileError(err),
+		Err:  pathErr,
+	}
+}
+
+// ClassifyFileError returns the kind of a file system error
+func ClassifyFileError(err error) FileErrorKind {
+	switch {
+	case Is(err, fs.ErrNotExist):
+		return KindNotFound
+	case Is(err, fs.ErrPermission):
+		return KindPermission
+	case Is(err, fs.ErrExist):
+		return KindExists
+	case Is(err, syscall.ENOSPC), Is(err, syscall.EDQUOT):
+		return KindNoSpace
+	case Is(err, syscall.EROFS):
+		return KindReadOnly
+	case Is(err, syscall.EISDIR):
+		return KindIsDir
+	case Is(err, syscall.EFBIG), Is(err, bytes.ErrTooLarge):
+		return KindTooLarge
+	default:
+		return KindUnknown
+	}
+}
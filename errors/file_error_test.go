@@ -0,0 +1,91 @@
+package errors_test
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	apperrors "error-handling-demo/errors"
+)
+
+func TestClassifyFileError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want apperrors.FileErrorKind
+	}{
+		{fs.ErrNotExist, apperrors.KindNotFound},
+		{&fs.PathError{Op: "open", Path: "x", Err: syscall.EACCES}, apperrors.KindPermission},
+		{syscall.EEXIST, apperrors.KindExists},
+		{errors.Wrap(syscall.ENOSPC, "write failed"), apperrors.KindNoSpace},
+		{syscall.EROFS, apperrors.KindReadOnly},
+		{syscall.EISDIR, apperrors.KindIsDir},
+		{syscall.EFBIG, apperrors.KindTooLarge},
+		{errors.New("boom"), apperrors.KindUnknown},
+	}
+	for _, tt := range tests {
+		if got := apperrors.ClassifyFileError(tt.err); got != tt.want {
+			t.Errorf("ClassifyFileError(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestFileErrorUnwrapsToPathError(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.txt")
+	_, openErr := os.Open(missing)
+
+	err := errors.Wrap(apperrors.NewFileError("read", missing, openErr), "loading config")
+
+	var fileErr *apperrors.FileError
+	if !errors.As(err, &fileErr) || fileErr.Kind != apperrors.KindNotFound {
+		t.Fatalf("errors.As found %+v, want a FileError of kind not_found", fileErr)
+	}
+	var pathErr *fs.PathError
+	if !errors.As(err, &pathErr) || pathErr.Op != "open" || pathErr.Path != missing {
+		t.Errorf("errors.As found %+v, want the *fs.PathError of os.Open", pathErr)
+	}
+	if !errors.Is(err, fs.ErrNotExist) || !os.IsNotExist(errors.Cause(err).(*apperrors.FileError).Err) {
+		t.Error("the FileError does not match fs.ErrNotExist")
+	}
+	if msg := err.Error(); strings.Count(msg, missing) != 1 {
+		t.Errorf("Error() = %q, want the path exactly once", msg)
+	}
+
+	// Errors that aren't an *fs.PathError get wrapped in one
+	wrapped := apperrors.NewFileError("write", "out.txt", syscall.ENOSPC)
+	if wrapped.Err.Op != "write" || wrapped.Err.Path != "out.txt" || wrapped.Kind != apperrors.KindNoSpace {
+		t.Errorf("NewFileError wrapped ENOSPC as %+v (kind %s)", wrapped.Err, wrapped.Kind)
+	}
+}
+
+func TestFileErrorPublicMessage(t *testing.T) {
+	err := apperrors.NewFileError("write", "/var/lib/app/secret.db", syscall.ENOSPC)
+
+	public := apperrors.Render(errors.Wrap(err, "saving users"), apperrors.External)
+	if strings.Contains(public, "/var/lib") || strings.Contains(public, "saving") {
+		t.Errorf("public message %q leaks internal details", public)
+	}
+	if public == apperrors.Localize(errors.New("boom"), apperrors.DefaultLocale) {
+		t.Errorf("public message %q is the generic internal one", public)
+	}
+	if de := apperrors.Localize(err, "de"); de == public {
+		t.Errorf("German message %q is not translated", de)
+	}
+}
+
+func TestFileErrorWithoutUnderlyingError(t *testing.T) {
+	err := &apperrors.FileError{Op: "x", Path: "y"}
+	if msg := err.Error(); msg != "file operation 'x' on 'y' failed" {
+		t.Errorf("Error() = %q", msg)
+	}
+	if err.Unwrap() != nil {
+		t.Errorf("Unwrap() = %#v, want nil", err.Unwrap())
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		t.Error("a FileError without an underlying error matches fs.ErrNotExist")
+	}
+}
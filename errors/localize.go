@@ -0,0 +1,433 @@
+package errors
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Catalog keys. Error() stays English and meant for developer logs; the
+// catalog renders the messages shown to end users. Templates use {name}
+// placeholders filled from the error's parameters.
+//
+//	validation/failed             header of a ValidationError
+//	validation/<path>/<code>      a rule broken by one specific field
+//	validation/<code>             a rule broken by any field, e.g. validation/min_length
+//	field/<path>                  the label used for {field}, defaults to the path
+//	multi                         header of a MultiError
+//	database/<reason>, database   a DatabaseError, e.g. database/busy
+//	network/retriable, network    a NetworkError
+//	file/<kind>, file             a FileError, e.g. file/not_found
+//	code/<code>                   an error with a registered code, see RegisterCode
+//	internal                      anything else
+//
+// Paths are written without slice indexes, so "contacts[2].email" is
+// looked up as "contacts[].email".
+const (
+	KeyValidationFailed = "validation/failed"
+	KeyMulti            = "multi"
+	KeyDatabase         = "database"
+	KeyNetwork          = "network"
+	KeyFile             = "file"
+	KeyInternal         = "internal"
+)
+
+// DefaultLocale is the locale every fallback chain ends with
+const DefaultLocale = "en"
+
+// Catalog holds message templates per locale
+type Catalog struct {
+	mu            sync.RWMutex
+	defaultLocale string
+	messages      map[string]map[string]string // locale -> key -> template
+	fallbacks     map[string][]string          // locale -> locales tried next
+}
+
+// NewCatalog creates an empty catalog that falls back to defaultLocale
+func NewCatalog(defaultLocale string) *Catalog {
+	return &Catalog{
+		defaultLocale: normalizeLocale(defaultLocale),
+		messages:      make(map[string]map[string]string),
+		fallbacks:     make(map[string][]string),
+	}
+}
+
+// DefaultCatalog is used by Localize and holds the built-in messages
+var DefaultCatalog = newDefaultCatalog()
+
+// AddMessages adds or replaces templates of a locale
+func (c *Catalog) AddMessages(locale string, messages map[string]string) {
+	locale = normalizeLocale(locale)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.messages[locale] == nil {
+		c.messages[locale] = make(map[string]string, len(messages))
+	}
+	for key, template := range messages {
+		c.messages[locale][key] = template
+	}
+}
+
+// SetFallback makes locale fall back to the given locales, in order, for
+// keys it has no template for, e.g. SetFallback("gsw", "de"). Every locale
+// also falls back to its base language ("pt-BR" to "pt") and finally to
+// the catalog's default locale.
+func (c *Catalog) SetFallback(locale string, fallbacks ...string) {
+	normalized := make([]string, len(fallbacks))
+	for i, fallback := range fallbacks {
+		normalized[i] = normalizeLocale(fallback)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fallbacks[normalizeLocale(locale)] = normalized
+}
+
+// Locales returns the locales the catalog has templates for
+func (c *Catalog) Locales() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	locales := make([]string, 0, len(c.messages))
+	for locale := range c.messages {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Message renders the template for key in locale, walking the fallback
+// chain of the locale. It reports false if no locale in the chain has one.
+func (c *Catalog) Message(locale, key string, params map[string]interface{}) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, candidate := range c.chain(locale) {
+		if template, ok := c.messages[candidate][key]; ok {
+			return FormatMessage(template, params), true
+		}
+	}
+	return "", false
+}
+
+// chain lists the locales tried for locale, most specific first. The
+// caller must hold c.mu.
+func (c *Catalog) chain(locale string) []string {
+	var chain []string
+	seen := make(map[string]bool)
+
+	var visit func(locale string)
+	visit = func(locale string) {
+		if locale == "" || seen[locale] {
+			return
+		}
+		seen[locale] = true
+		chain = append(chain, locale)
+
+		for _, fallback := range c.fallbacks[locale] {
+			visit(fallback)
+		}
+		if base := baseLanguage(locale); base != locale {
+			visit(base)
+		}
+	}
+
+	visit(normalizeLocale(locale))
+	visit(c.defaultLocale)
+	return chain
+}
+
+// MatchLocale picks the best locale of the catalog for an Accept-Language
+// header such as "fr-CH, fr;q=0.9, de;q=0.8". It returns the default
+// locale if none of the requested languages is available.
+func (c *Catalog) MatchLocale(acceptLanguage string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, locale := range ParseAcceptLanguage(acceptLanguage) {
+		if locale == "*" {
+			break
+		}
+		if _, ok := c.messages[locale]; ok {
+			return locale
+		}
+		if base := baseLanguage(locale); c.messages[base] != nil {
+			return base
+		}
+	}
+	return c.defaultLocale
+}
+
+// ParseAcceptLanguage returns the locales of an Accept-Language header
+// ordered by preference. Locales with q=0 are left out.
+func ParseAcceptLanguage(header string) []string {
+	type weighted struct {
+		locale string
+		q      float64
+	}
+
+	var locales []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		locale := normalizeLocale(fields[0])
+		if locale == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if value, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = value
+				}
+			}
+		}
+		if q > 0 {
+			locales = append(locales, weighted{locale, q})
+		}
+	}
+
+	// Equal weights keep the order of the header
+	sort.SliceStable(locales, func(i, j int) bool {
+		return locales[i].q > locales[j].q
+	})
+
+	result := make([]string, len(locales))
+	for i, l := range locales {
+		result[i] = l.locale
+	}
+	return result
+}
+
+// Localize renders err for an end user in locale, using DefaultCatalog
+func Localize(err error, locale string) string {
+	return DefaultCatalog.Localize(err, locale)
+}
+
+// LocalizeFields renders the field errors of a ValidationError in err for
+// an end user in locale, using DefaultCatalog
+func LocalizeFields(err error, locale string) map[string][]string {
+	return DefaultCatalog.LocalizeFields(err, locale)
+}
+
+// Localize renders err for an end user in locale. Developer context added
+// by wrapping is left out: the message describes the first error in the
+// chain the catalog knows about, and ValidationError and MultiError are
+// rendered with all of their errors. Other PublicError types are rendered
+// with their untranslated PublicMessage, and errors that are neither are
+// rendered with the "internal" message.
+func (c *Catalog) Localize(err error, locale string) string {
+	if err == nil {
+		return ""
+	}
+
+	for e := err; e != nil; e = unwrapOnce(e) {
+		if msg, ok := c.localizeOne(e, locale); ok {
+			return msg
+		}
+	}
+	return c.text(locale, KeyInternal, nil, "an unexpected error occurred")
+}
+
+// LocalizeFields renders the field errors of the ValidationError in err's
+// chain per field, e.g. {"email": ["E-Mail-Adresse ist ungültig"]}. It
+// returns nil if err holds no ValidationError.
+func (c *Catalog) LocalizeFields(err error, locale string) map[string][]string {
+	var validationErr *ValidationError
+	if !As(err, &validationErr) {
+		return nil
+	}
+
+	fields := make(map[string][]string)
+	for _, e := range validationErr.all() {
+		if fieldErr, ok := e.(*FieldError); ok {
+			fields[fieldErr.Path] = append(fields[fieldErr.Path], c.localizeField(fieldErr, locale))
+		} else {
+			fields[""] = append(fields[""], c.Localize(e, locale))
+		}
+	}
+	return fields
+}
+
+// localizeOne renders e itself if the catalog knows how to
+func (c *Catalog) localizeOne(e error, locale string) (string, bool) {
+	// Registered codes come first, so a DatabaseError whose sentinel is
+	// models.ErrDuplicateUser is described as a duplicate user
+	if code, ok := lookupCode(e); ok {
+		if msg, ok := c.Message(locale, "code/"+code, nil); ok {
+			return msg, true
+		}
+	}
+
+	switch e := e.(type) {
+	case *ValidationError:
+		return c.localizeList(locale, KeyValidationFailed, "validation failed", "%s: %s", ", ", e.all()), true
+	case *MultiError:
+		return c.localizeList(locale, KeyMulti, "multiple errors occurred", "%s: [%s]", "; ", e.Errors), true
+	case *FieldError:
+		return c.localizeField(e, locale), true
+	case *DatabaseError:
+		params := map[string]interface{}{"operation": e.Operation, "table": e.Table, "reason": string(e.Reason)}
+		if msg, ok := c.Message(locale, KeyDatabase+"/"+string(e.Reason), params); ok {
+			return msg, true
+		}
+		return c.text(locale, KeyDatabase, params, "a database error occurred"), true
+	case *NetworkError:
+		params := map[string]interface{}{"operation": e.Op}
+		if e.Retriable {
+			if msg, ok := c.Message(locale, KeyNetwork+"/retriable", params); ok {
+				return msg, true
+			}
+		}
+		return c.text(locale, KeyNetwork, params, "a remote service could not be reached"), true
+	case *FileError:
+		params := map[string]interface{}{"operation": e.Op, "kind": string(e.Kind)}
+		if msg, ok := c.Message(locale, KeyFile+"/"+string(e.Kind), params); ok {
+			return msg, true
+		}
+		return c.text(locale, KeyFile, params, "a file could not be accessed"), true
+	}
+
+	// Error types of other packages can provide their own public message
+	if public, ok := e.(PublicError); ok {
+		return public.PublicMessage(), true
+	}
+
+	// Errors joined by other means are rendered like a MultiError
+	if joined, ok := e.(interface{ Unwrap() []error }); ok {
+		return c.localizeList(locale, KeyMulti, "multiple errors occurred", "%s: [%s]", "; ", joined.Unwrap()), true
+	}
+	return "", false
+}
+
+// localizeList renders a header followed by every error of errs, joined
+// with sep and laid out by format
+func (c *Catalog) localizeList(locale, key, fallback, format, sep string, errs []error) string {
+	header := c.text(locale, key, map[string]interface{}{"count": len(errs)}, fallback)
+	if len(errs) == 0 {
+		return header
+	}
+
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = c.Localize(err, locale)
+	}
+	return fmt.Sprintf(format, header, strings.Join(messages, sep))
+}
+
+// localizeField renders a single broken rule
+func (c *Catalog) localizeField(e *FieldError, locale string) string {
+	path := fieldKey(e.Path)
+
+	params := make(map[string]interface{}, len(e.Params)+1)
+	for name, value := range e.Params {
+		params[name] = value
+	}
+	params["field"] = c.text(locale, "field/"+path, nil, e.Path)
+
+	if e.Code != "" {
+		if msg, ok := c.Message(locale, "validation/"+path+"/"+e.Code, params); ok {
+			return msg
+		}
+		if msg, ok := c.Message(locale, "validation/"+e.Code, params); ok {
+			return msg
+		}
+	}
+
+	// Unknown rules keep their English message
+	return e.Error()
+}
+
+// text renders key, or fallback if no locale in the chain has a template
+func (c *Catalog) text(locale, key string, params map[string]interface{}, fallback string) string {
+	if msg, ok := c.Message(locale, key, params); ok {
+		return msg
+	}
+	return FormatMessage(fallback, params)
+}
+
+// FormatMessage fills {name} placeholders in template with params
+func FormatMessage(template string, params map[string]interface{}) string {
+	if len(params) == 0 || !strings.Contains(template, "{") {
+		return template
+	}
+
+	for name, value := range params {
+		template = strings.ReplaceAll(template, "{"+name+"}", fmt.Sprint(value))
+	}
+	return template
+}
+
+var (
+	codesMu sync.RWMutex
+	codes   []codedError
+)
+
+// codedError pairs a sentinel error with its catalog code
+type codedError struct {
+	err  error
+	code string
+}
+
+// RegisterCode gives a sentinel error a code, so Localize renders it and
+// every error matching it with the "code/<code>" template, e.g.
+//
+//	errors.RegisterCode(ErrUserNotFound, "user_not_found")
+func RegisterCode(err error, code string) {
+	codesMu.Lock()
+	defer codesMu.Unlock()
+	codes = append(codes, codedError{err: err, code: code})
+}
+
+// lookupCode returns the code of the sentinel e is or claims to be through
+// its Is method. Unlike errors.Is it does not look at wrapped errors.
+func lookupCode(e error) (string, bool) {
+	codesMu.RLock()
+	defer codesMu.RUnlock()
+
+	matcher, hasIs := e.(interface{ Is(error) bool })
+	for _, coded := range codes {
+		if e == coded.err || (hasIs && matcher.Is(coded.err)) {
+			return coded.code, true
+		}
+	}
+	return "", false
+}
+
+// unwrapOnce returns the error wrapped by e, or nil
+func unwrapOnce(e error) error {
+	switch e := e.(type) {
+	case interface{ Unwrap() error }:
+		return e.Unwrap()
+	case interface{ Cause() error }:
+		return e.Cause()
+	}
+	return nil
+}
+
+// sliceIndex matches the slice indexes of a field path
+var sliceIndex = regexp.MustCompile(`\[\d+\]`)
+
+// fieldKey removes slice indexes from a field path
+func fieldKey(path string) string {
+	return sliceIndex.ReplaceAllString(path, "[]")
+}
+
+// normalizeLocale turns "pt_BR" and " PT-br" into "pt-br"
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+}
+
+// baseLanguage returns the language of a locale, e.g. "pt" for "pt-br"
+func baseLanguage(locale string) string {
+	if i := strings.IndexByte(locale, '-'); i > 0 {
+		return locale[:i]
+	}
+	return locale
+}
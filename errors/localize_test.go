@@ -0,0 +1,121 @@
+package errors_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	apperrors "error-handling-demo/errors"
+	"error-handling-demo/models"
+)
+
+func TestLocalizeValidationError(t *testing.T) {
+	err := errors.Wrap(newUserValidationError(), "create user")
+
+	tests := []struct {
+		locale string
+		want   string
+	}{
+		{"en", "The input is invalid: username must be at least 3 characters long, email is not a valid email address"},
+		{"de", "Die Eingabe ist ungültig: Benutzername muss mindestens 3 Zeichen lang sein, E-Mail-Adresse ist keine gültige E-Mail-Adresse"},
+		{"de-AT", "Die Eingabe ist ungültig: Benutzername muss mindestens 3 Zeichen lang sein, E-Mail-Adresse ist keine gültige E-Mail-Adresse"},
+		{"fr", "The input is invalid: username must be at least 3 characters long, email is not a valid email address"},
+	}
+	for _, tt := range tests {
+		if got := apperrors.Localize(err, tt.locale); got != tt.want {
+			t.Errorf("Localize(%s) = %q, want %q", tt.locale, got, tt.want)
+		}
+	}
+
+	// Error() is left alone for developer logs
+	if got, want := err.Error(), "create user: user validation failed: username must be at least 3 characters long, email format is invalid"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
+
+func TestLocalizeFields(t *testing.T) {
+	got := apperrors.LocalizeFields(newUserValidationError(), "es")
+	want := map[string][]string{
+		"username": {"El nombre de usuario debe tener al menos 3 caracteres"},
+		"email":    {"El correo electrónico no es una dirección de correo válida"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LocalizeFields(es) = %v, want %v", got, want)
+	}
+
+	if got := apperrors.LocalizeFields(errors.New("boom"), "es"); got != nil {
+		t.Errorf("LocalizeFields(non-validation error) = %v, want nil", got)
+	}
+}
+
+func TestLocalizeMultiError(t *testing.T) {
+	multi := apperrors.NewMultiError()
+	multi.Add(errors.Wrap(models.ErrUserNotFound, "find user 42"))
+	multi.Add(&apperrors.DatabaseError{Operation: "insert", Table: "users", Reason: apperrors.ReasonBusy})
+	multi.Add(errors.New("disk controller exploded"))
+
+	want := "Es sind mehrere Probleme aufgetreten: [Der Benutzer existiert nicht; " +
+		"Der Dienst ist ausgelastet, bitte versuchen Sie es erneut; Ein unerwarteter Fehler ist aufgetreten]"
+	if got := apperrors.Localize(multi, "de"); got != want {
+		t.Errorf("Localize(multi, de) = %q, want %q", got, want)
+	}
+}
+
+func TestLocalizeRegisteredSentinelWins(t *testing.T) {
+	// A DatabaseError standing for a duplicate user is described as one
+	err := &apperrors.DatabaseError{
+		Operation: "insert",
+		Table:     "users",
+		Reason:    apperrors.ReasonUniqueViolation,
+		Sentinel:  models.ErrDuplicateUser,
+	}
+	if got, want := apperrors.Localize(err, "en"), "A user with this name already exists"; got != want {
+		t.Errorf("Localize = %q, want %q", got, want)
+	}
+}
+
+func TestCatalogFallbackChain(t *testing.T) {
+	catalog := apperrors.NewCatalog("en")
+	catalog.AddMessages("en", map[string]string{"greeting": "Hello {name}", "farewell": "Goodbye"})
+	catalog.AddMessages("de", map[string]string{"greeting": "Hallo {name}"})
+	catalog.AddMessages("gsw", map[string]string{"farewell": "Adieu"})
+	catalog.SetFallback("gsw", "de")
+
+	tests := []struct {
+		locale, key, want string
+	}{
+		{"gsw-CH", "greeting", "Hallo Ada"}, // gsw-ch -> gsw -> de
+		{"gsw", "farewell", "Adieu"},
+		{"de_DE", "farewell", "Goodbye"}, // de-de -> de -> en
+		{"", "greeting", "Hello Ada"},
+	}
+	for _, tt := range tests {
+		got, ok := catalog.Message(tt.locale, tt.key, map[string]interface{}{"name": "Ada"})
+		if !ok || got != tt.want {
+			t.Errorf("Message(%q, %q) = %q, %v, want %q", tt.locale, tt.key, got, ok, tt.want)
+		}
+	}
+
+	if _, ok := catalog.Message("de", "missing", nil); ok {
+		t.Error("Message found a key no locale has")
+	}
+}
+
+func TestMatchLocale(t *testing.T) {
+	tests := []struct {
+		header, want string
+	}{
+		{"de-CH, de;q=0.9, en;q=0.8", "de"},
+		{"fr-CH, fr;q=0.9, es;q=0.5, en;q=0.3", "es"},
+		{"en;q=0.2, es", "es"},
+		{"es;q=0, fr", "en"},
+		{"", "en"},
+		{"*", "en"},
+	}
+	for _, tt := range tests {
+		if got := apperrors.DefaultCatalog.MatchLocale(tt.header); got != tt.want {
+			t.Errorf("MatchLocale(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package errors
+
+// newDefaultCatalog returns a catalog with the built-in messages. More
+// locales and application-specific keys can be added with AddMessages.
+func newDefaultCatalog() *Catalog {
+	c := NewCatalog(DefaultLocale)
+	c.AddMessages("en", englishMessages)
+	c.AddMessages("de", germanMessages)
+	c.AddMessages("es", spanishMessages)
+
+	// Swiss German readers get the German messages
+	c.SetFallback("gsw", "de")
+	return c
+}
+
+var englishMessages = map[string]string{
+	KeyValidationFailed:     "The input is invalid",
+	"validation/required":   "{field} cannot be empty",
+	"validation/min_length": "{field} must be at least {min} characters long",
+	"validation/max_length": "{field} must be at most {max} characters long",
+	"validation/min":        "{field} must be at least {min}",
+	"validation/max":        "{field} must be at most {max}",
+	"validation/pattern":    "{field} has an invalid format",
+	"validation/email":      "{field} is not a valid email address",
+
+	KeyMulti:                    "Several problems occurred",
+	KeyDatabase:                 "The data could not be saved or loaded",
+	"database/unique_violation": "This record already exists",
+	"database/busy":             "The service is busy, please try again",
+	"database/locked":           "The service is busy, please try again",
+	"database/conflict":         "The record was changed by someone else, please reload it",
+	"database/read_only":        "The service is in maintenance, changes are not possible right now",
+	"database/full":             "The service has run out of storage",
+	KeyNetwork:                  "A remote service could not be reached",
+	"network/retriable":         "A remote service is temporarily unavailable, please try again",
+	KeyFile:                     "The file could not be accessed",
+	"file/not_found":            "The file does not exist",
+	"file/permission":           "Access to the file was denied",
+	"file/exists":               "The file already exists",
+	"file/no_space":             "There is not enough storage space left",
+	"file/read_only":            "The storage is read-only",
+	"file/is_dir":               "A directory was given where a file was expected",
+	"file/too_large":            "The file is too large",
+	KeyInternal:                 "An unexpected error occurred",
+}
+
+var germanMessages = map[string]string{
+	KeyValidationFailed:     "Die Eingabe ist ungültig",
+	"validation/required":   "{field} darf nicht leer sein",
+	"validation/min_length": "{field} muss mindestens {min} Zeichen lang sein",
+	"validation/max_length": "{field} darf höchstens {max} Zeichen lang sein",
+	"validation/min":        "{field} muss mindestens {min} sein",
+	"validation/max":        "{field} darf höchstens {max} sein",
+	"validation/pattern":    "{field} hat ein ungültiges Format",
+	"validation/email":      "{field} ist keine gültige E-Mail-Adresse",
+	"field/username":        "Benutzername",
+	"field/email":           "E-Mail-Adresse",
+	"field/age":             "Alter",
+
+	KeyMulti:                    "Es sind mehrere Probleme aufgetreten",
+	KeyDatabase:                 "Die Daten konnten nicht gespeichert oder geladen werden",
+	"database/unique_violation": "Dieser Eintrag existiert bereits",
+	"database/busy":             "Der Dienst ist ausgelastet, bitte versuchen Sie es erneut",
+	"database/locked":           "Der Dienst ist ausgelastet, bitte versuchen Sie es erneut",
+	"database/conflict":         "Der Eintrag wurde zwischenzeitlich geändert, bitte laden Sie ihn neu",
+	"database/read_only":        "Der Dienst wird gewartet, Änderungen sind gerade nicht möglich",
+	"database/full":             "Der Speicherplatz des Dienstes ist erschöpft",
+	KeyNetwork:                  "Ein externer Dienst ist nicht erreichbar",
+	"network/retriable":         "Ein externer Dienst ist vorübergehend nicht verfügbar, bitte versuchen Sie es erneut",
+	KeyFile:                     "Auf die Datei konnte nicht zugegriffen werden",
+	"file/not_found":            "Die Datei existiert nicht",
+	"file/permission":           "Der Zugriff auf die Datei wurde verweigert",
+	"file/exists":               "Die Datei existiert bereits",
+	"file/no_space":             "Es ist nicht genügend Speicherplatz frei",
+	"file/read_only":            "Der Speicher ist schreibgeschützt",
+	"file/is_dir":               "Statt einer Datei wurde ein Verzeichnis angegeben",
+	"file/too_large":            "Die Datei ist zu groß",
+	KeyInternal:                 "Ein unerwarteter Fehler ist aufgetreten",
+}
+
+var spanishMessages = map[string]string{
+	KeyValidationFailed:     "Los datos introducidos no son válidos",
+	"validation/required":   "{field} no puede estar vacío",
+	"validation/min_length": "{field} debe tener al menos {min} caracteres",
+	"validation/max_length": "{field} debe tener como máximo {max} caracteres",
+	"validation/min":        "{field} debe ser al menos {min}",
+	"validation/max":        "{field} debe ser como máximo {max}",
+	"validation/pattern":    "{field} tiene un formato no válido",
+	"validation/email":      "{field} no es una dirección de correo válida",
+	"field/username":        "El nombre de usuario",
+	"field/email":           "El correo electrónico",
+	"field/age":             "La edad",
+
+	KeyMulti:                    "Se produjeron varios problemas",
+	KeyDatabase:                 "No se pudieron guardar o cargar los datos",
+	"database/unique_violation": "Este registro ya existe",
+	"database/busy":             "El servicio está ocupado, inténtelo de nuevo",
+	"database/locked":           "El servicio está ocupado, inténtelo de nuevo",
+	"database/conflict":         "Otra persona modificó el registro, vuelva a cargarlo",
+	"database/read_only":        "El servicio está en mantenimiento, ahora no se pueden hacer cambios",
+	"database/full":             "El servicio se quedó sin espacio de almacenamiento",
+	KeyNetwork:                  "No se pudo contactar con un servicio externo",
+	"network/retriable":         "Un servicio externo no está disponible temporalmente, inténtelo de nuevo",
+	KeyFile:                     "No se pudo acceder al archivo",
+	"file/not_found":            "El archivo no existe",
+	"file/permission":           "Se denegó el acceso al archivo",
+	"file/exists":               "El archivo ya existe",
+	"file/no_space":             "No queda espacio de almacenamiento suficiente",
+	"file/read_only":            "El almacenamiento es de solo lectura",
+	"file/is_dir":               "Se indicó un directorio en lugar de un archivo",
+	"file/too_large":            "El archivo es demasiado grande",
+	KeyInternal:                 "Se produjo un error inesperado",
+}
@@ -0,0 +1,22 @@
+package errors
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestShippedLocalesAreComplete(t *testing.T) {
+	c := newDefaultCatalog()
+	for _, locale := range c.Locales() {
+		var missing []string
+		for key := range englishMessages {
+			if _, ok := c.messages[locale][key]; !ok {
+				missing = append(missing, key)
+			}
+		}
+		sort.Strings(missing)
+		if len(missing) > 0 {
+			t.Errorf("locale %s has no message for %v", locale, missing)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package errors
+
+// PublicError is an error with a message that is safe to show outside the
+// application. Error() is the detailed internal message for developers and
+// logs: it may name hosts, tables and queries. PublicMessage() never does.
+type PublicError interface {
+	error
+	PublicMessage() string
+}
+
+// Audience says who will read a rendered error
+type Audience int
+
+const (
+	// Internal readers, such as logs and operators, get Error()
+	Internal Audience = iota
+	// External readers, such as API clients and end users, get the public
+	// message
+	External
+)
+
+// Render returns the message of err meant for audience. Use it wherever an
+// error crosses a trust boundary, e.g. when writing an API response:
+//
+//	http.Error(w, errors.Render(err, errors.External), status)
+//
+// For External, the public message of the first error in the chain that
+// has one is used, with ValidationError and MultiError listing all of
+// their errors; anything else becomes "An unexpected error occurred".
+// Wrapping context is left out. Use Localize for a translated message.
+func Render(err error, audience Audience) string {
+	if err == nil {
+		return ""
+	}
+	if audience == Internal {
+		return err.Error()
+	}
+	return Localize(err, DefaultLocale)
+}
+
+// PublicMessage implements PublicError without the URL and cause
+func (e *NetworkError) PublicMessage() string {
+	return Localize(e, DefaultLocale)
+}
+
+// PublicMessage implements PublicError without the table, query and cause
+func (e *DatabaseError) PublicMessage() string {
+	return Localize(e, DefaultLocale)
+}
+
+// PublicMessage implements PublicError without the path and cause
+func (e *FileError) PublicMessage() string {
+	return Localize(e, DefaultLocale)
+}
+
+// PublicMessage implements PublicError
+func (e *FieldError) PublicMessage() string {
+	return Localize(e, DefaultLocale)
+}
+
+// PublicMessage implements PublicError, listing every field error
+func (e *ValidationError) PublicMessage() string {
+	return Localize(e, DefaultLocale)
+}
+
+// PublicMessage implements PublicError, listing the public message of every
+// error
+func (e *MultiError) PublicMessage() string {
+	return Localize(e, DefaultLocale)
+}
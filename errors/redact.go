@@ -0,0 +1,139 @@
+package errors
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Redacted replaces secrets removed by a RedactPolicy
+const Redacted = "REDACTED"
+
+// RedactPolicy describes what is removed from URLs and bodies before they
+// end up in an error message. Even internal messages are logged and
+// shipped around, so credentials never belong in them.
+type RedactPolicy struct {
+	// KeepQueryParams lists the query parameters kept in URLs; every other
+	// parameter is dropped, as are credentials and the fragment
+	KeepQueryParams []string
+	// SensitiveKeys are masked in bodies wherever a JSON field or form key
+	// contains one of them, compared case-insensitively
+	SensitiveKeys []string
+	// MaxBodyLength cuts bodies to this many bytes, 0 means no limit
+	MaxBodyLength int
+}
+
+// DefaultRedactPolicy is used by RedactURL, RedactBody and the Error
+// methods of this package
+var DefaultRedactPolicy = RedactPolicy{
+	SensitiveKeys: []string{
+		"password", "passwd", "secret", "token", "api_key", "apikey",
+		"authorization", "session", "cookie", "credential",
+	},
+	MaxBodyLength: 256,
+}
+
+// RedactURL applies DefaultRedactPolicy to a URL
+func RedactURL(raw string) string {
+	return DefaultRedactPolicy.URL(raw)
+}
+
+// RedactBody applies DefaultRedactPolicy to a request or response body
+func RedactBody(body []byte) string {
+	return DefaultRedactPolicy.Body(body)
+}
+
+// URL strips credentials, the fragment and every query parameter not listed
+// in KeepQueryParams, e.g. "https://bob:pw@api.example.com/v1?token=x"
+// becomes "https://api.example.com/v1"
+func (p RedactPolicy) URL(raw string) string {
+	if raw == "" {
+		return ""
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		// Whatever it is, it may hold a secret
+		return "[invalid URL]"
+	}
+
+	u.User = nil
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	query := url.Values{}
+	for _, name := range p.KeepQueryParams {
+		if values, ok := u.Query()[name]; ok {
+			query[name] = values
+		}
+	}
+	u.RawQuery = query.Encode()
+	u.ForceQuery = false
+
+	return u.String()
+}
+
+// Body masks the values of sensitive JSON fields and form keys as well as
+// bearer tokens, and cuts the body to MaxBodyLength. Binary bodies are only
+// described by their size.
+func (p RedactPolicy) Body(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	if !utf8.Valid(body) {
+		return fmt.Sprintf("[%d bytes of binary data]", len(body))
+	}
+
+	text := string(body)
+	for _, mask := range p.masks() {
+		text = mask.re.ReplaceAllString(text, mask.replacement)
+	}
+
+	if p.MaxBodyLength > 0 && len(text) > p.MaxBodyLength {
+		cut := p.MaxBodyLength
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		text = fmt.Sprintf("%s... [%d bytes truncated]", text[:cut], len(text)-cut)
+	}
+	return text
+}
+
+// mask is a pattern whose matches are replaced in bodies
+type mask struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// bearerToken matches the credentials of an Authorization header
+var bearerToken = regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9\-._~+/]+=*`)
+
+// masks builds the patterns for the sensitive keys of the policy. Bodies
+// are only redacted on error paths, so they are compiled on demand.
+func (p RedactPolicy) masks() []mask {
+	masks := []mask{{bearerToken, "$1 " + Redacted}}
+	if len(p.SensitiveKeys) == 0 {
+		return masks
+	}
+
+	quoted := make([]string, len(p.SensitiveKeys))
+	for i, key := range p.SensitiveKeys {
+		quoted[i] = regexp.QuoteMeta(key)
+	}
+	keys := strings.Join(quoted, "|")
+
+	return append(masks,
+		// "api_token": "abc" and "password": 1234
+		mask{
+			regexp.MustCompile(`(?i)("[^"]*(?:` + keys + `)[^"]*"\s*:\s*)("(?:[^"\\]|\\.)*"|[^,}\]\s]+)`),
+			`$1"` + Redacted + `"`,
+		},
+		// password=abc&user=bob
+		mask{
+			regexp.MustCompile(`(?i)\b([\w.-]*(?:` + keys + `)[\w.-]*=)[^&\s]*`),
+			"${1}" + Redacted,
+		},
+	)
+}
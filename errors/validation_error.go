@@ -0,0 +1,156 @@
+package errors
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// FieldError reports a value that broke a validation rule
+type FieldError struct {
+	Path    string                 // Path of the field, e.g. "address.city" or "tags[2]"
+	Code    string                 // Code of the broken rule, e.g. "min_length"
+	Params  map[string]interface{} // Parameters of the rule, e.g. {"min": 3}
+	Message string                 // English description, e.g. "must be at least 3 characters long"
+}
+
+// Error implements the error interface
+func (e *FieldError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + " " + e.Message
+}
+
+// Is lets errors.Is find a field error by path and code. Empty fields of
+// target match anything, so &FieldError{Path: "email"} matches every
+// error reported for the email field.
+func (e *FieldError) Is(target error) bool {
+	t, ok := target.(*FieldError)
+	if !ok || (t.Path == "" && t.Code == "") {
+		return false
+	}
+	return (t.Path == "" || t.Path == e.Path) && (t.Code == "" || t.Code == e.Code)
+}
+
+// ValidationError collects every problem found while validating a value.
+// It is the one validation error of the application; models.ValidationError
+// and validation.ValidationError are aliases of it.
+type ValidationError struct {
+	Message string
+	Errors  []error // Usually *FieldError values
+
+	// Field is the single-field form of ValidationError, kept so existing
+	// code building &ValidationError{Field: ..., Message: ...} still works.
+	// Such an error reports Message as the only error of Field.
+	//
+	// Deprecated: Use NewValidationError with *FieldError values instead.
+	Field string
+}
+
+// NewValidationError creates a new ValidationError
+func NewValidationError(message string, errs []error) *ValidationError {
+	return &ValidationError{
+		Message: message,
+		Errors:  errs,
+	}
+}
+
+// Error implements the error interface
+func (e *ValidationError) Error() string {
+	if e.isSingleField() {
+		return fmt.Sprintf("validation error for field '%s': %s", e.Field, e.Message)
+	}
+	if len(e.Errors) == 0 {
+		return e.Message
+	}
+
+	errMessages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		errMessages[i] = err.Error()
+	}
+
+	return e.Message + ": " + strings.Join(errMessages, ", ")
+}
+
+// Unwrap returns the individual errors, so errors.Is and errors.As can
+// match a single field error inside the ValidationError
+func (e *ValidationError) Unwrap() []error {
+	return e.all()
+}
+
+// FieldErrors returns the errors that concern a specific field
+func (e *ValidationError) FieldErrors() []*FieldError {
+	var result []*FieldError
+	for _, err := range e.all() {
+		if fieldErr, ok := err.(*FieldError); ok {
+			result = append(result, fieldErr)
+		}
+	}
+	return result
+}
+
+// Fields renders the error messages per field for API clients, e.g.
+// {"email": ["format is invalid"]}. Errors that don't concern a field are
+// listed under the empty path.
+func (e *ValidationError) Fields() map[string][]string {
+	fields := make(map[string][]string)
+	for _, err := range e.all() {
+		if fieldErr, ok := err.(*FieldError); ok {
+			fields[fieldErr.Path] = append(fields[fieldErr.Path], fieldErr.Message)
+		} else {
+			fields[""] = append(fields[""], err.Error())
+		}
+	}
+	return fields
+}
+
+// fieldJSON is how a field error is marshaled inside a ValidationError
+type fieldJSON struct {
+	Code    string                 `json:"code,omitempty"`
+	Message string                 `json:"message"`
+	Params  map[string]interface{} `json:"params,omitempty"`
+}
+
+// MarshalJSON renders the error keyed by field:
+//
+//	{"message": "user validation failed",
+//	 "fields": {"email": [{"code": "email", "message": "format is invalid"}]}}
+func (e *ValidationError) MarshalJSON() ([]byte, error) {
+	fields := make(map[string][]fieldJSON)
+	for _, err := range e.all() {
+		if fieldErr, ok := err.(*FieldError); ok {
+			fields[fieldErr.Path] = append(fields[fieldErr.Path], fieldJSON{
+				Code:    fieldErr.Code,
+				Message: fieldErr.Message,
+				Params:  fieldErr.Params,
+			})
+		} else {
+			fields[""] = append(fields[""], fieldJSON{Message: err.Error()})
+		}
+	}
+
+	message := e.Message
+	if e.isSingleField() {
+		message = "validation failed"
+	}
+
+	return json.Marshal(struct {
+		Message string                 `json:"message"`
+		Fields  map[string][]fieldJSON `json:"fields"`
+	}{message, fields})
+}
+
+// isSingleField reports whether the error uses the deprecated Field form
+func (e *ValidationError) isSingleField() bool {
+	return e.Field != "" && len(e.Errors) == 0
+}
+
+// all returns the individual errors, turning the deprecated single-field
+// form into a FieldError
+func (e *ValidationError) all() []error {
+	if e.isSingleField() {
+		return []error{&FieldError{Path: e.Field, Message: e.Message}}
+	}
+	return e.Errors
+}
@@ -0,0 +1,98 @@
+package errors_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	apperrors "error-handling-demo/errors"
+	"error-handling-demo/models"
+)
+
+func newUserValidationError() error {
+	return apperrors.NewValidationError("user validation failed", []error{
+		&apperrors.FieldError{Path: "username", Code: "min_length", Params: map[string]interface{}{"min": 3},
+			Message: "must be at least 3 characters long"},
+		&apperrors.FieldError{Path: "email", Code: "email", Message: "format is invalid"},
+	})
+}
+
+func TestValidationErrorAsEitherName(t *testing.T) {
+	err := errors.Wrap(newUserValidationError(), "create user")
+
+	var appErr *apperrors.ValidationError
+	if !errors.As(err, &appErr) {
+		t.Fatal("errors.As into *errors.ValidationError failed")
+	}
+	var modelErr *models.ValidationError
+	if !errors.As(err, &modelErr) {
+		t.Fatal("errors.As into *models.ValidationError failed")
+	}
+	if len(modelErr.Errors) != 2 {
+		t.Fatalf("models.ValidationError has %d errors, want 2", len(modelErr.Errors))
+	}
+}
+
+func TestValidationErrorUnwrapsFieldErrors(t *testing.T) {
+	err := errors.Wrap(newUserValidationError(), "create user")
+
+	if !errors.Is(err, &apperrors.FieldError{Path: "email"}) {
+		t.Error("errors.Is did not find the email field error")
+	}
+	if !errors.Is(err, &apperrors.FieldError{Code: "min_length"}) {
+		t.Error("errors.Is did not find the min_length field error")
+	}
+	if errors.Is(err, &apperrors.FieldError{Path: "age"}) {
+		t.Error("errors.Is matched a field without errors")
+	}
+
+	var fieldErr *apperrors.FieldError
+	if !errors.As(err, &fieldErr) || fieldErr.Path != "username" {
+		t.Fatalf("errors.As found %v, want the username field error", fieldErr)
+	}
+}
+
+func TestValidationErrorSingleFieldForm(t *testing.T) {
+	err := &apperrors.ValidationError{Field: "age", Message: "user must be at least 18 years old"}
+
+	if got, want := err.Error(), "validation error for field 'age': user must be at least 18 years old"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	if !errors.Is(err, &apperrors.FieldError{Path: "age"}) {
+		t.Error("errors.Is did not find the single field")
+	}
+	if fields := err.Fields(); len(fields["age"]) != 1 {
+		t.Errorf("Fields() = %v, want one age message", fields)
+	}
+}
+
+func TestValidationErrorJSON(t *testing.T) {
+	data, err := json.Marshal(newUserValidationError())
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %v", err)
+	}
+
+	var decoded struct {
+		Message string `json:"message"`
+		Fields  map[string][]struct {
+			Code    string                 `json:"code"`
+			Message string                 `json:"message"`
+			Params  map[string]interface{} `json:"params"`
+		} `json:"fields"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("json.Unmarshal(%s) failed: %v", data, err)
+	}
+
+	if decoded.Message != "user validation failed" {
+		t.Errorf("message = %q", decoded.Message)
+	}
+	username := decoded.Fields["username"]
+	if len(username) != 1 || username[0].Code != "min_length" || username[0].Params["min"] != 3.0 {
+		t.Errorf("username = %+v", username)
+	}
+	if email := decoded.Fields["email"]; len(email) != 1 || email[0].Message != "format is invalid" {
+		t.Errorf("email = %+v", email)
+	}
+}
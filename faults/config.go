@@ -0,0 +1,88 @@
+package faults
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"error-handling-demo/config"
+)
+
+// Environment variables that enable fault injection without editing the
+// configuration file. FAULT_INJECTION holds a JSON array of rules in the
+// configuration format and replaces the configured rules.
+const (
+	EnvRules = "FAULT_INJECTION"
+	EnvSeed  = "FAULT_INJECTION_SEED"
+)
+
+// RulesFromConfig validates and converts configured rules, resolving error names
+func RulesFromConfig(rules []config.FaultRule) ([]Rule, error) {
+	result := make([]Rule, 0, len(rules))
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return nil, err
+		}
+
+		var err error
+		if rule.Error != "" {
+			var ok bool
+			if err, ok = LookupError(rule.Error); !ok {
+				return nil, errors.Wrapf(ErrUnknownFault, "%q for %s (known: %v)", rule.Error, rule.Op, ErrorNames())
+			}
+		}
+
+		result = append(result, Rule{
+			Op:          rule.Op,
+			Probability: *rule.Probability,
+			Latency:     time.Duration(rule.Latency) * time.Millisecond,
+			Err:         err,
+			Panic:       rule.Panic,
+			Times:       rule.Times,
+		})
+	}
+	return result, nil
+}
+
+// Configure enables or disables fault injection according to cfg, with the
+// FAULT_INJECTION and FAULT_INJECTION_SEED environment variables taking
+// precedence. It returns the installed Injector, or nil if injection is off.
+func Configure(cfg config.FaultInjection) (*Injector, error) {
+	if raw := os.Getenv(EnvRules); raw != "" {
+		var rules []config.FaultRule
+		if err := json.Unmarshal([]byte(raw), &rules); err != nil {
+			return nil, errors.Wrapf(err, "invalid %s", EnvRules)
+		}
+		cfg.Enabled = true
+		cfg.Rules = rules
+	}
+	if raw := os.Getenv(EnvSeed); raw != "" {
+		seed, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s", EnvSeed)
+		}
+		cfg.Seed = seed
+	}
+
+	if !cfg.Enabled || len(cfg.Rules) == 0 {
+		Disable()
+		return nil, nil
+	}
+
+	rules, err := RulesFromConfig(cfg.Rules)
+	if err != nil {
+		return nil, err
+	}
+
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	injector := NewInjector(seed, rules...)
+	Enable(injector)
+	return injector, nil
+}
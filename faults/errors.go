@@ -0,0 +1,86 @@
+package faults
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInjected matches every error returned because of an injected fault
+var ErrInjected = errors.New("injected fault")
+
+// ErrUnknownFault is returned for a rule naming an error that isn't registered
+var ErrUnknownFault = errors.New("unknown fault error")
+
+// InjectedError is returned by an operation that failed because of a rule.
+// It unwraps to the rule's error, so code under test sees the same error it
+// would see from a real failure, and it also matches ErrInjected.
+type InjectedError struct {
+	Op  string // The operation the fault was injected into
+	Err error  // The error the rule returns
+}
+
+// Error implements the error interface
+func (e *InjectedError) Error() string {
+	return fmt.Sprintf("injected fault in %s: %v", e.Op, e.Err)
+}
+
+// Unwrap returns the rule's error
+func (e *InjectedError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrInjected
+func (e *InjectedError) Is(target error) bool {
+	return target == ErrInjected
+}
+
+// registry maps the error names usable in configuration to errors
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]error{
+		"timeout":            context.DeadlineExceeded,
+		"canceled":           context.Canceled,
+		"unexpected_eof":     io.ErrUnexpectedEOF,
+		"not_exist":          fs.ErrNotExist,
+		"permission":         fs.ErrPermission,
+		"disk_full":          syscall.ENOSPC,
+		"connection_refused": syscall.ECONNREFUSED,
+		"connection_reset":   syscall.ECONNRESET,
+	}
+)
+
+// RegisterError makes err available to configured rules under name. Packages
+// register their own failures, e.g. dbops registers "db_busy".
+func RegisterError(name string, err error) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = err
+}
+
+// LookupError returns the error registered under name
+func LookupError(name string) (error, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	err, ok := registry[name]
+	return err, ok
+}
+
+// ErrorNames lists the registered error names in order
+func ErrorNames() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
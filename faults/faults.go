@@ -0,0 +1,154 @@
+// Package faults injects failures into the netops, dbops and fileops
+// functions so that error handling can be exercised without a broken disk,
+// network or database. Injection is off unless an Injector is installed
+// with Enable, usually from the configuration or the FAULT_INJECTION
+// environment variable (see Configure).
+package faults
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Rule describes a fault to inject into matching operations
+type Rule struct {
+	// Op is the operation name or a path.Match pattern, e.g. "netops.fetch"
+	// or "dbops.*". Operation names are listed in the README.
+	Op string
+	// Probability is the chance in [0, 1] that the rule fires on a matching
+	// call: 1 always fires, and 0 never does, which parks the rule
+	Probability float64
+	// Latency is added before the operation runs, or before Err is returned
+	Latency time.Duration
+	// Err is returned instead of running the operation; nil only adds latency
+	Err error
+	// Panic makes the operation panic instead of returning Err
+	Panic bool
+	// Times limits how often the rule fires; 0 means no limit
+	Times int
+}
+
+// matches reports whether the rule applies to op
+func (r Rule) matches(op string) bool {
+	if r.Op == op {
+		return true
+	}
+	matched, err := path.Match(r.Op, op)
+	return err == nil && matched
+}
+
+// Injector decides which operations fail. It is safe for concurrent use.
+type Injector struct {
+	mu    sync.Mutex
+	rules []Rule
+	fired []int          // How often each rule has fired
+	count map[string]int // How often a fault was injected per operation
+	rng   *rand.Rand
+}
+
+// NewInjector creates an Injector for rules. The same seed produces the
+// same sequence of faults for the same sequence of calls.
+func NewInjector(seed int64, rules ...Rule) *Injector {
+	return &Injector{
+		rules: rules,
+		fired: make([]int, len(rules)),
+		count: make(map[string]int),
+		rng:   rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Inject applies the first rule that matches op and fires: it waits for the
+// rule's latency, then panics or returns the rule's error wrapped in an
+// *InjectedError. It returns ctx.Err() if ctx is done while waiting.
+func (i *Injector) Inject(ctx context.Context, op string) error {
+	rule, ok := i.pick(op)
+	if !ok {
+		return nil
+	}
+
+	if rule.Latency > 0 {
+		timer := time.NewTimer(rule.Latency)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	if rule.Panic {
+		panic(fmt.Sprintf("injected panic in %s", op))
+	}
+	if rule.Err != nil {
+		return &InjectedError{Op: op, Err: rule.Err}
+	}
+	return nil
+}
+
+// pick finds the rule that fires for op and records that it fired
+func (i *Injector) pick(op string) (Rule, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for n, rule := range i.rules {
+		if !rule.matches(op) {
+			continue
+		}
+		if rule.Times > 0 && i.fired[n] >= rule.Times {
+			continue
+		}
+		if rule.Probability < 1 && i.rng.Float64() >= rule.Probability {
+			continue
+		}
+
+		i.fired[n]++
+		i.count[op]++
+		return rule, true
+	}
+	return Rule{}, false
+}
+
+// Injected returns how often a fault was injected into each operation
+func (i *Injector) Injected() map[string]int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	result := make(map[string]int, len(i.count))
+	for op, n := range i.count {
+		result[op] = n
+	}
+	return result
+}
+
+// active is the installed Injector, nil while injection is disabled
+var active atomic.Pointer[Injector]
+
+// Enable installs injector for every instrumented operation; nil disables injection
+func Enable(injector *Injector) {
+	active.Store(injector)
+}
+
+// Disable turns fault injection off
+func Disable() {
+	active.Store(nil)
+}
+
+// Active returns the installed Injector, or nil if injection is disabled
+func Active() *Injector {
+	return active.Load()
+}
+
+// Inject is called by instrumented operations before they do any work.
+// It returns nil at once when fault injection is disabled.
+func Inject(ctx context.Context, op string) error {
+	injector := active.Load()
+	if injector == nil {
+		return nil
+	}
+	return injector.Inject(ctx, op)
+}
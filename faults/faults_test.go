@@ -0,0 +1,200 @@
+package faults
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"error-handling-demo/config"
+)
+
+func TestRuleMatches(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, op string
+		want        bool
+	}{
+		{"netops.fetch", "netops.fetch", true},
+		{"netops.fetch", "netops.post", false},
+		{"dbops.*", "dbops.begin", true},
+		{"dbops.*", "dbops.insert.users", true}, // * only stops at a slash
+		{"dbops.*.users", "dbops.insert.users", true},
+		{"dbops.insert.*", "dbops.insert.audit_log", true},
+		{"fileops.fs.*", "fileops.fs", false},
+		{"*", "fileops.write", true},
+		{"fileops.[rw]*", "fileops.read", true},
+		{"fileops.[", "fileops.[", true}, // A broken pattern still matches itself
+		{"fileops.[", "fileops.write", false},
+	} {
+		if got := (Rule{Op: tc.pattern}).matches(tc.op); got != tc.want {
+			t.Errorf("%q matching %q = %v, want %v", tc.pattern, tc.op, got, tc.want)
+		}
+	}
+}
+
+func TestInjectFirstMatchingRule(t *testing.T) {
+	ctx := context.Background()
+	injector := NewInjector(1,
+		Rule{Op: "dbops.insert.users", Probability: 1, Err: syscall.ENOSPC, Times: 2},
+		Rule{Op: "dbops.*.users", Probability: 1, Err: syscall.EACCES},
+	)
+
+	// The first rule fires until it is used up, then the second takes over
+	for i, want := range []error{syscall.ENOSPC, syscall.ENOSPC, syscall.EACCES, syscall.EACCES} {
+		err := injector.Inject(ctx, "dbops.insert.users")
+		if !errors.Is(err, want) || !errors.Is(err, ErrInjected) {
+			t.Errorf("call %d returned %v, want %v", i+1, err, want)
+		}
+	}
+	if err := injector.Inject(ctx, "dbops.begin"); err != nil {
+		t.Errorf("an operation without a rule failed: %v", err)
+	}
+
+	if got := injector.Injected(); len(got) != 1 || got["dbops.insert.users"] != 4 {
+		t.Errorf("Injected() = %v, want 4 faults in dbops.insert.users", got)
+	}
+}
+
+func TestInjectTimes(t *testing.T) {
+	injector := NewInjector(1, Rule{Op: "fileops.write", Probability: 1, Err: syscall.ENOSPC, Times: 3})
+	failures := 0
+	for i := 0; i < 10; i++ {
+		if injector.Inject(context.Background(), "fileops.write") != nil {
+			failures++
+		}
+	}
+	if failures != 3 {
+		t.Errorf("a rule limited to 3 times fired %d times", failures)
+	}
+}
+
+func TestInjectProbability(t *testing.T) {
+	// sequence returns which of 200 calls fail
+	sequence := func(seed int64, probability float64) []bool {
+		injector := NewInjector(seed, Rule{Op: "netops.fetch", Probability: probability, Err: syscall.ECONNRESET})
+		fired := make([]bool, 200)
+		for i := range fired {
+			fired[i] = injector.Inject(context.Background(), "netops.fetch") != nil
+		}
+		return fired
+	}
+	count := func(fired []bool) int {
+		n := 0
+		for _, f := range fired {
+			if f {
+				n++
+			}
+		}
+		return n
+	}
+
+	if n := count(sequence(1, 0)); n != 0 {
+		t.Errorf("a rule with probability 0 fired %d times", n)
+	}
+	if n := count(sequence(1, 1)); n != 200 {
+		t.Errorf("a rule with probability 1 fired %d of 200 times", n)
+	}
+
+	// The same seed gives the same failures, another seed others
+	first, again, other := sequence(42, 0.3), sequence(42, 0.3), sequence(43, 0.3)
+	same, differs := true, false
+	for i := range first {
+		same = same && first[i] == again[i]
+		differs = differs || first[i] != other[i]
+	}
+	if !same {
+		t.Error("the same seed gave different failures")
+	}
+	if !differs {
+		t.Error("different seeds gave the same failures")
+	}
+	if n := count(first); n < 30 || n > 90 {
+		t.Errorf("a rule with probability 0.3 fired %d of 200 times", n)
+	}
+}
+
+func TestInjectLatency(t *testing.T) {
+	injector := NewInjector(1,
+		Rule{Op: "netops.post", Probability: 1, Latency: time.Minute, Err: syscall.ECONNRESET},
+		Rule{Op: "netops.fetch", Probability: 1, Latency: 20 * time.Millisecond},
+	)
+
+	// Latency alone delays the operation without failing it
+	start := time.Now()
+	if err := injector.Inject(context.Background(), "netops.fetch"); err != nil {
+		t.Errorf("a latency-only rule returned %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("latency of 20ms took %v", elapsed)
+	}
+
+	// The wait ends with the context
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start = time.Now()
+	if err := injector.Inject(ctx, "netops.post"); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled latency returned %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("canceled latency took %v", elapsed)
+	}
+}
+
+func TestRulesFromConfig(t *testing.T) {
+	never, sometimes := 0.0, 0.5
+	rules, err := RulesFromConfig([]config.FaultRule{
+		{Op: "dbops.*", Probability: &never, Error: "disk_full"},
+		{Op: "netops.fetch", Probability: &sometimes, Latency: 250},
+	})
+	if err != nil {
+		t.Fatalf("RulesFromConfig failed: %v", err)
+	}
+	if rules[0].Probability != 0 || rules[0].Err != syscall.ENOSPC || rules[1].Latency != 250*time.Millisecond {
+		t.Errorf("RulesFromConfig = %+v", rules)
+	}
+
+	// A parked rule never fires
+	if err := NewInjector(1, rules[0]).Inject(context.Background(), "dbops.begin"); err != nil {
+		t.Errorf("a rule with probability 0 fired: %v", err)
+	}
+
+	// Invalid rules are refused whether they come from the configuration
+	// file or the environment
+	tooLikely, negative := 5.0, -0.5
+	for i, rule := range []config.FaultRule{
+		{Op: "dbops.*", Error: "disk_full"},
+		{Op: "dbops.*", Probability: &tooLikely, Error: "disk_full"},
+		{Op: "dbops.*", Probability: &negative, Error: "disk_full"},
+		{Op: "", Probability: &sometimes, Error: "disk_full"},
+		{Op: "dbops.*", Probability: &sometimes, Latency: -1},
+		{Op: "dbops.*", Probability: &sometimes, Times: -1},
+	} {
+		if _, err := RulesFromConfig([]config.FaultRule{rule}); err == nil {
+			t.Errorf("RulesFromConfig accepted invalid rule %d", i)
+		}
+	}
+	if _, err := RulesFromConfig([]config.FaultRule{{Op: "dbops.*", Probability: &never, Error: "no_such_error"}}); !errors.Is(err, ErrUnknownFault) {
+		t.Errorf("RulesFromConfig with an unknown error returned %v", err)
+	}
+}
+
+func TestConfigureFromEnvironment(t *testing.T) {
+	defer Disable()
+
+	t.Setenv(EnvRules, `[{"op": "netops.fetch", "probability": 5, "error": "timeout"}]`)
+	if _, err := Configure(config.FaultInjection{}); err == nil {
+		t.Error("Configure accepted a probability of 5 from the environment")
+	}
+	if Active() != nil {
+		t.Error("an invalid rule was enabled")
+	}
+
+	t.Setenv(EnvRules, `[{"op": "netops.fetch", "probability": 1, "error": "timeout"}]`)
+	t.Setenv(EnvSeed, "7")
+	injector, err := Configure(config.FaultInjection{})
+	if err != nil || injector == nil || Active() != injector {
+		t.Fatalf("Configure = %v, %v; want an enabled injector", injector, err)
+	}
+}
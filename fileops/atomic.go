@@ -0,0 +1,205 @@
+package fileops
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	apperrors "error-handling-demo/errors"
+	"error-handling-demo/faults"
+)
+
+// AtomicFile is written like a regular file but only replaces its target
+// when Commit is called. Until then the data lives in a temporary file in
+// the same directory, so readers see either the old or the new content and
+// a crash never leaves a half-written target behind.
+type AtomicFile struct {
+	fsys   FS
+	tmp    File
+	target string
+	perm   os.FileMode
+	keep   bool       // Whether a file is being replaced whose mode must be kept
+	owner  *fileOwner // Owner of the file being replaced, nil for new files
+	done   bool
+}
+
+// CreateAtomic starts an atomic write of filename. A new file gets perm less
+// the umask as its permissions; an existing one keeps its permissions and, where the
+// process is allowed to set it, its owner. If filename is a symlink, the
+// file it points to is replaced and the link is kept.
+func CreateAtomic(filename string, perm os.FileMode) (*AtomicFile, error) {
+	return CreateAtomicFS(OSFS{}, filename, perm)
+}
+
+// CreateAtomicFS is CreateAtomic on fsys. Ownership is only kept on OSFS.
+func CreateAtomicFS(fsys FS, filename string, perm os.FileMode) (*AtomicFile, error) {
+	target, err := resolveTarget(fsys, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &AtomicFile{fsys: fsys, target: target, perm: perm}
+	if info, err := fsys.Stat(target); err == nil {
+		f.perm, f.keep = info.Mode().Perm(), true
+		f.owner = ownerOf(info)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fileError("stat", target, err)
+	}
+
+	// The temporary file must be in the target's directory: rename is only
+	// atomic within a single file system
+	dir, base := filepath.Split(target)
+	tmp, err := createTemp(fsys, dir, "."+base+".tmp-", f.perm)
+	if err != nil {
+		return nil, fileError("create", target, err)
+	}
+	f.tmp = tmp
+
+	return f, nil
+}
+
+// createTemp creates a new file in dir named prefix and a random number.
+// Unlike FS.CreateTemp, which always uses 0600, it creates the file with
+// perm, so the operating system applies the umask as for any new file.
+func createTemp(fsys FS, dir, prefix string, perm os.FileMode) (File, error) {
+	for try := 0; ; try++ {
+		name := filepath.Join(dir, prefix+strconv.FormatUint(uint64(rand.Uint32()), 10))
+		f, err := fsys.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if err == nil || !errors.Is(err, fs.ErrExist) || try == 10000 {
+			return f, err
+		}
+	}
+}
+
+// Write writes to the temporary file
+func (f *AtomicFile) Write(p []byte) (int, error) {
+	return f.tmp.Write(p)
+}
+
+// WriteString writes to the temporary file
+func (f *AtomicFile) WriteString(s string) (int, error) {
+	return io.WriteString(f.tmp, s)
+}
+
+// Name returns the path of the file that Commit replaces
+func (f *AtomicFile) Name() string {
+	return f.target
+}
+
+// Commit flushes the temporary file to disk, gives it the permissions and
+// owner of the target it replaces and renames it over the target. The directory is
+// synced afterwards, so the rename survives a crash too. If any step fails
+// the temporary file is removed and the target is left untouched.
+func (f *AtomicFile) Commit() error {
+	if f.done {
+		return errors.New("atomic file already committed or aborted")
+	}
+	f.done = true
+
+	if err := f.tmp.Sync(); err != nil {
+		return f.cleanup(fileError("sync", f.target, err))
+	}
+	// The umask may have taken bits off the mode of the file being replaced
+	if f.keep {
+		if err := f.fsys.Chmod(f.tmp.Name(), f.perm); err != nil {
+			return f.cleanup(fileError("chmod", f.target, err))
+		}
+	}
+	if err := f.owner.apply(f.tmp); err != nil {
+		return f.cleanup(fileError("chown", f.target, err))
+	}
+	if err := f.tmp.Close(); err != nil {
+		return f.cleanup(fileError("close", f.target, err))
+	}
+	if err := f.fsys.Rename(f.tmp.Name(), f.target); err != nil {
+		return f.cleanup(fileError("rename", f.target, err))
+	}
+
+	// The new content is in place; a failed directory sync only means the
+	// rename might not survive a crash
+	if syncer, ok := f.fsys.(dirSyncer); ok {
+		if err := syncer.syncDir(filepath.Dir(f.target)); err != nil {
+			return fileError("sync", filepath.Dir(f.target), err)
+		}
+	}
+	return nil
+}
+
+// Abort discards everything written and leaves the target untouched. It
+// does nothing after Commit, so it can be deferred.
+func (f *AtomicFile) Abort() error {
+	if f.done {
+		return nil
+	}
+	f.done = true
+	return f.cleanup(nil)
+}
+
+// cleanup closes and removes the temporary file and joins any error doing
+// so with err
+func (f *AtomicFile) cleanup(err error) error {
+	if closeErr := f.tmp.Close(); closeErr != nil && !errors.Is(closeErr, fs.ErrClosed) {
+		err = apperrors.Join(err, fileError("close", f.tmp.Name(), closeErr))
+	}
+	if removeErr := f.fsys.Remove(f.tmp.Name()); removeErr != nil && !errors.Is(removeErr, fs.ErrNotExist) {
+		err = apperrors.Join(err, fileError("remove", f.tmp.Name(), removeErr))
+	}
+	return err
+}
+
+// WriteFileAtomic replaces filename with data, see CreateAtomic for how
+// permissions and ownership are handled. A failed write is returned joined
+// with any error of cleaning up after it.
+func WriteFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	return WriteFileAtomicFS(OSFS{}, filename, data, perm)
+}
+
+// WriteFileAtomicFS is WriteFileAtomic on fsys
+func WriteFileAtomicFS(fsys FS, filename string, data []byte, perm os.FileMode) error {
+	// Give fault injection a chance to fail the write
+	if err := faults.Inject(context.Background(), "fileops.write"); err != nil {
+		return fileError("write", filename, err)
+	}
+
+	f, err := CreateAtomicFS(fsys, filename, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		return apperrors.Join(fileError("write", filename, err), f.Abort())
+	}
+	return f.Commit()
+}
+
+// resolveTarget follows filename if it is a symlink, so the link survives
+// the rename
+func resolveTarget(fsys FS, filename string) (string, error) {
+	info, err := fsys.Lstat(filename)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return filename, nil
+	}
+
+	target, err := fsys.EvalSymlinks(filename)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			// A dangling link: create the file it points to
+			link, readErr := fsys.Readlink(filename)
+			if readErr != nil {
+				return "", fileError("readlink", filename, readErr)
+			}
+			if !filepath.IsAbs(link) {
+				link = filepath.Join(filepath.Dir(filename), link)
+			}
+			return link, nil
+		}
+		return "", fileError("readlink", filename, err)
+	}
+	return target, nil
+}
@@ -0,0 +1,25 @@
+//go:build !unix
+
+package fileops
+
+import (
+	"os"
+)
+
+// fileOwner is not tracked on systems without Unix ownership
+type fileOwner struct{}
+
+// ownerOf returns nil, ownership is left to the system
+func ownerOf(info os.FileInfo) *fileOwner {
+	return nil
+}
+
+// apply does nothing
+func (o *fileOwner) apply(f File) error {
+	return nil
+}
+
+// syncDir does nothing: directories can't be synced on these systems
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,133 @@
+package fileops
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readFile returns the content of path or fails the test
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed: %v", path, err)
+	}
+	return string(data)
+}
+
+// expectNoTempFiles fails the test if dir holds anything but want
+func expectNoTempFiles(t *testing.T, dir string, want ...string) {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir(%s) failed: %v", dir, err)
+	}
+	if len(entries) != len(want) {
+		names := make([]string, len(entries))
+		for i, entry := range entries {
+			names[i] = entry.Name()
+		}
+		t.Fatalf("directory holds %v, want %v", names, want)
+	}
+}
+
+func TestWriteFileAtomicReplacesContent(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+
+	if err := WriteFileAtomic(path, []byte("old"), 0600); err != nil {
+		t.Fatalf("first WriteFileAtomic failed: %v", err)
+	}
+	if err := WriteFileAtomic(path, []byte("new"), 0644); err != nil {
+		t.Fatalf("second WriteFileAtomic failed: %v", err)
+	}
+
+	if got := readFile(t, path); got != "new" {
+		t.Errorf("content = %q, want %q", got, "new")
+	}
+
+	// The existing file keeps its permissions
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+	}
+	expectNoTempFiles(t, dir, "config.json")
+}
+
+func TestAtomicFileAbortKeepsTarget(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.txt")
+	if err := WriteFile(path, "original"); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	f, err := CreateAtomic(path, 0644)
+	if err != nil {
+		t.Fatalf("CreateAtomic failed: %v", err)
+	}
+	if _, err := f.WriteString("half of the new"); err != nil {
+		t.Fatalf("WriteString failed: %v", err)
+	}
+
+	// Readers still see the old content while the write is in progress
+	if got := readFile(t, path); got != "original" {
+		t.Errorf("content during write = %q, want %q", got, "original")
+	}
+
+	if err := f.Abort(); err != nil {
+		t.Fatalf("Abort failed: %v", err)
+	}
+	if err := f.Commit(); err == nil {
+		t.Error("Commit after Abort succeeded")
+	}
+
+	if got := readFile(t, path); got != "original" {
+		t.Errorf("content after Abort = %q, want %q", got, "original")
+	}
+	expectNoTempFiles(t, dir, "data.txt")
+}
+
+func TestWriteFileAtomicFailedRenameCleansUp(t *testing.T) {
+	dir := t.TempDir()
+
+	// A non-empty directory can't be replaced by a file
+	target := filepath.Join(dir, "target")
+	if err := os.MkdirAll(filepath.Join(target, "child"), 0755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+
+	if err := WriteFileAtomic(target, []byte("data"), 0644); err == nil {
+		t.Fatal("WriteFileAtomic over a directory succeeded")
+	}
+	expectNoTempFiles(t, dir, "target")
+}
+
+func TestWriteFileAtomicKeepsSymlink(t *testing.T) {
+	dir := t.TempDir()
+	real := filepath.Join(dir, "real.txt")
+	link := filepath.Join(dir, "link.txt")
+
+	if err := WriteFile(real, "old"); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := os.Symlink("real.txt", link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	if err := WriteFile(link, "new"); err != nil {
+		t.Fatalf("WriteFile through symlink failed: %v", err)
+	}
+
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("link.txt is no longer a symlink (err %v)", err)
+	}
+	if got := readFile(t, real); got != "new" {
+		t.Errorf("target content = %q, want %q", got, "new")
+	}
+}
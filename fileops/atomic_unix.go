@@ -0,0 +1,48 @@
+//go:build unix
+
+package fileops
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner is the user and group owning a file
+type fileOwner struct {
+	uid, gid int
+}
+
+// ownerOf returns the owner of the file described by info
+func ownerOf(info os.FileInfo) *fileOwner {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return &fileOwner{uid: int(stat.Uid), gid: int(stat.Gid)}
+}
+
+// apply gives f the owner o. Only root may give files away, so a lack of
+// permission is not an error: the file then belongs to the current user,
+// just as if it had been rewritten in place by them. Only files of the OS
+// have an owner.
+func (o *fileOwner) apply(f File) error {
+	osFile, ok := f.(*os.File)
+	if o == nil || !ok || (o.uid == os.Getuid() && o.gid == os.Getgid()) {
+		return nil
+	}
+	if err := osFile.Chown(o.uid, o.gid); err != nil && !os.IsPermission(err) {
+		return err
+	}
+	return nil
+}
+
+// syncDir flushes the directory entry changes of dir, such as a rename
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
@@ -0,0 +1,52 @@
+//go:build unix
+
+package fileops
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// fileMode returns the permissions of path or fails the test
+func fileMode(t *testing.T, path string) os.FileMode {
+	t.Helper()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	return info.Mode().Perm()
+}
+
+func TestWriteFileAtomicUmask(t *testing.T) {
+	// The umask belongs to the process, so this test must not run in parallel
+	defer syscall.Umask(syscall.Umask(027))
+	dir := t.TempDir()
+
+	// A new file gets perm less the umask, as with os.WriteFile
+	path := filepath.Join(dir, "new.txt")
+	if err := WriteFileAtomic(path, []byte("data"), 0666); err != nil {
+		t.Fatalf("WriteFileAtomic failed: %v", err)
+	}
+	if mode := fileMode(t, path); mode != 0640 {
+		t.Errorf("new file mode = %v, want 0640", mode)
+	}
+
+	// An existing file keeps its mode, even bits the umask would remove
+	shared := filepath.Join(dir, "shared.txt")
+	if err := os.WriteFile(shared, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(shared, 0664); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFileAtomic(shared, []byte("new"), 0600); err != nil {
+		t.Fatalf("WriteFileAtomic failed: %v", err)
+	}
+	if mode := fileMode(t, shared); mode != 0664 {
+		t.Errorf("replaced file mode = %v, want 0664", mode)
+	}
+	expectNoTempFiles(t, dir, "new.txt", "shared.txt")
+}
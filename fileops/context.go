@@ -0,0 +1,90 @@
+package fileops
+
+import (
+	"context"
+	"io"
+	"io/fs"
+
+	"github.com/pkg/errors"
+
+	"error-handling-demo/faults"
+)
+
+// DefaultChunkSize is how much StreamFile reads between two context checks
+const DefaultChunkSize = 32 * 1024
+
+// contextReader checks its context before every Read
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// NewContextReader returns a reader that reads from r until ctx is done,
+// after which every Read fails with ctx.Err(). A Read already in progress
+// is not interrupted, so the context is checked between chunks.
+func NewContextReader(ctx context.Context, r io.Reader) io.Reader {
+	return &contextReader{ctx: ctx, r: r}
+}
+
+// Read implements io.Reader
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+// contextWriter checks its context before every Write
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+// NewContextWriter returns a writer that writes to w until ctx is done,
+// after which every Write fails with ctx.Err()
+func NewContextWriter(ctx context.Context, w io.Writer) io.Writer {
+	return &contextWriter{ctx: ctx, w: w}
+}
+
+// Write implements io.Writer
+func (cw *contextWriter) Write(p []byte) (int, error) {
+	if err := cw.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cw.w.Write(p)
+}
+
+// StreamFile copies the file to w in chunks of up to DefaultChunkSize,
+// checking ctx between chunks. It returns the number of bytes written to
+// w, which is the partial count if ctx is done before the end of the file;
+// the error then matches ctx.Err() with errors.Is.
+//
+// The copy runs in the calling goroutine, so nothing is left running once
+// StreamFile returns.
+func StreamFile(ctx context.Context, filename string, w io.Writer) (int64, error) {
+	return StreamFileFS(ctx, OSFS{}, filename, w)
+}
+
+// StreamFileFS is StreamFile on fsys
+func StreamFileFS(ctx context.Context, fsys fs.FS, filename string, w io.Writer) (int64, error) {
+	// Give fault injection a chance to fail the read
+	if err := faults.Inject(ctx, "fileops.read"); err != nil {
+		return 0, fileError("open", filename, err)
+	}
+
+	file, err := fsys.Open(filename)
+	if err != nil {
+		return 0, fileError("open", filename, err)
+	}
+	defer file.Close()
+
+	buffer := make([]byte, DefaultChunkSize)
+	n, err := io.CopyBuffer(w, NewContextReader(ctx, file), buffer)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil && errors.Is(err, ctxErr) {
+			return n, errors.Wrapf(err, "context cancelled after reading %d bytes", n)
+		}
+		return n, fileError("read", filename, err)
+	}
+	return n, nil
+}
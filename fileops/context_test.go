@@ -0,0 +1,154 @@
+package fileops
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	apperrors "error-handling-demo/errors"
+)
+
+// checkGoroutineLeaks fails the test if it ends with more goroutines than
+// it started with. Goroutines may need a moment to exit, so the count is
+// polled for a while before giving up.
+func checkGoroutineLeaks(t *testing.T) {
+	t.Helper()
+
+	before := runtime.NumGoroutine()
+	t.Cleanup(func() {
+		deadline := time.Now().Add(time.Second)
+		for runtime.NumGoroutine() > before {
+			if time.Now().After(deadline) {
+				buf := make([]byte, 1<<16)
+				buf = buf[:runtime.Stack(buf, true)]
+				t.Errorf("%d goroutines leaked:\n%s", runtime.NumGoroutine()-before, buf)
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+// writeTestFile creates a file of size bytes and returns its path
+func writeTestFile(t *testing.T, size int) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(path, bytes.Repeat([]byte("x"), size), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	return path
+}
+
+// cancelingWriter cancels a context once after bytes have been written
+type cancelingWriter struct {
+	buf    bytes.Buffer
+	after  int
+	cancel context.CancelFunc
+}
+
+func (w *cancelingWriter) Write(p []byte) (int, error) {
+	n, err := w.buf.Write(p)
+	if w.buf.Len() >= w.after {
+		w.cancel()
+	}
+	return n, err
+}
+
+func TestStreamFile(t *testing.T) {
+	checkGoroutineLeaks(t)
+	path := writeTestFile(t, 3*DefaultChunkSize+10)
+
+	var buf bytes.Buffer
+	n, err := StreamFile(context.Background(), path, &buf)
+	if err != nil {
+		t.Fatalf("StreamFile failed: %v", err)
+	}
+	if n != int64(3*DefaultChunkSize+10) || buf.Len() != int(n) {
+		t.Errorf("StreamFile copied %d bytes (buffer %d), want %d", n, buf.Len(), 3*DefaultChunkSize+10)
+	}
+}
+
+func TestStreamFileReturnsPartialCountOnCancel(t *testing.T) {
+	checkGoroutineLeaks(t)
+	path := writeTestFile(t, 10*DefaultChunkSize)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := &cancelingWriter{after: 2 * DefaultChunkSize, cancel: cancel}
+
+	n, err := StreamFile(ctx, path, w)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("StreamFile returned %v, want context.Canceled", err)
+	}
+	if n != int64(2*DefaultChunkSize) || w.buf.Len() != int(n) {
+		t.Errorf("StreamFile reported %d bytes with %d written, want %d", n, w.buf.Len(), 2*DefaultChunkSize)
+	}
+}
+
+func TestReadFileWithContextDoesNotLeak(t *testing.T) {
+	checkGoroutineLeaks(t)
+	path := writeTestFile(t, DefaultChunkSize)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for i := 0; i < 100; i++ {
+		if _, err := ReadFileWithContext(canceled, path); !errors.Is(err, context.Canceled) {
+			t.Fatalf("ReadFileWithContext returned %v, want context.Canceled", err)
+		}
+	}
+
+	content, err := ReadFileWithContext(context.Background(), path)
+	if err != nil || len(content) != DefaultChunkSize {
+		t.Fatalf("ReadFileWithContext = %d bytes, %v", len(content), err)
+	}
+}
+
+func TestContextReaderAndWriter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r := NewContextReader(ctx, strings.NewReader("hello"))
+	var buf bytes.Buffer
+	w := NewContextWriter(ctx, &buf)
+
+	if _, err := io.Copy(w, r); err != nil || buf.String() != "hello" {
+		t.Fatalf("Copy before cancel = %q, %v", buf.String(), err)
+	}
+
+	cancel()
+	if n, err := r.Read(make([]byte, 1)); n != 0 || !errors.Is(err, context.Canceled) {
+		t.Errorf("Read after cancel = %d, %v", n, err)
+	}
+	if n, err := w.Write([]byte("more")); n != 0 || !errors.Is(err, context.Canceled) {
+		t.Errorf("Write after cancel = %d, %v", n, err)
+	}
+}
+
+func TestStreamFileReportsFileErrors(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.txt")
+
+	_, err := StreamFile(context.Background(), missing, io.Discard)
+
+	var fileErr *apperrors.FileError
+	if !errors.As(err, &fileErr) || fileErr.Op != "open" || fileErr.Path != missing {
+		t.Fatalf("StreamFile returned %v, want a FileError for opening %s", err, missing)
+	}
+	if fileErr.Kind != apperrors.KindNotFound || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("FileError has kind %s, want not_found matching fs.ErrNotExist", fileErr.Kind)
+	}
+
+	_, err = StreamFile(context.Background(), t.TempDir(), io.Discard)
+	if !errors.As(err, &fileErr) || fileErr.Kind != apperrors.KindIsDir {
+		t.Errorf("StreamFile on a directory returned %v, want a FileError of kind is_dir", err)
+	}
+}
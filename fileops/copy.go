@@ -0,0 +1,328 @@
+package fileops
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	apperrors "error-handling-demo/errors"
+	"error-handling-demo/faults"
+)
+
+// CopyPhase names the step of a copy that failed
+type CopyPhase string
+
+// Phases of CopyFile, in the order they run
+const (
+	PhaseOpen     CopyPhase = "open"     // opening and checking source and destination
+	PhaseResume   CopyPhase = "resume"   // skipping the part already copied
+	PhaseCopy     CopyPhase = "copy"     // copying data
+	PhaseSync     CopyPhase = "sync"     // flushing the destination to disk
+	PhaseVerify   CopyPhase = "verify"   // comparing SHA-256 checksums
+	PhaseMetadata CopyPhase = "metadata" // setting mode and modification time
+)
+
+// DefaultProgressInterval is the least time between two progress callbacks
+const DefaultProgressInterval = 100 * time.Millisecond
+
+// ErrChecksumMismatch is reported when the destination doesn't have the
+// content of the source after copying
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// CopyError reports a failed copy
+type CopyError struct {
+	Src   string
+	Dst   string
+	Phase CopyPhase
+	// BytesCopied is how much of the source is in the destination,
+	// including a resumed part
+	BytesCopied int64
+	// Partial tells whether the incomplete destination was kept
+	Partial bool
+	Err     error
+}
+
+// Error implements the error interface
+func (e *CopyError) Error() string {
+	return fmt.Sprintf("copy %s to %s failed during %s after %d bytes: %v",
+		e.Src, e.Dst, e.Phase, e.BytesCopied, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *CopyError) Unwrap() error {
+	return e.Err
+}
+
+// CopyOptions configure CopyFile. The zero value copies from scratch
+// without verification and removes the destination if the copy fails.
+type CopyOptions struct {
+	// Resume continues from an existing destination no larger than the
+	// source, assuming it holds the beginning of the source. Use Verify to
+	// catch a destination that doesn't.
+	Resume bool
+	// Verify compares the SHA-256 checksums of source and destination
+	// after copying
+	Verify bool
+	// KeepPartial keeps the destination when the copy fails, so it can be
+	// resumed later. Otherwise, and always after a failed verification, it
+	// is removed.
+	KeepPartial bool
+	// Progress is called with the bytes copied so far and the source size,
+	// at most once per ProgressInterval and once more when done
+	Progress func(copied, total int64)
+	// ProgressInterval defaults to DefaultProgressInterval
+	ProgressInterval time.Duration
+	// FS holds src and, unless DstFS is set, dst. Both default to OSFS.
+	// Resuming without Verify seeks in the source, so a source that can't
+	// seek is read up to the resume point instead.
+	FS    FS
+	DstFS FS
+}
+
+// fileSystems returns the file systems of source and destination
+func (o CopyOptions) fileSystems() (src, dst FS) {
+	src = orOS(o.FS)
+	if o.DstFS == nil {
+		return src, src
+	}
+	return src, o.DstFS
+}
+
+// CopyFile copies src to dst and gives dst the mode and modification time
+// of src. The copy stops with ctx. Failures are reported as a *CopyError
+// naming the failing phase and the bytes copied so far.
+func CopyFile(ctx context.Context, src, dst string, opts CopyOptions) error {
+	c := &copier{ctx: ctx, src: src, dst: dst, opts: opts}
+	c.srcFS, c.dstFS = opts.fileSystems()
+	if opts.Verify {
+		c.hash = sha256.New()
+	}
+	return c.run()
+}
+
+// copier holds the state of one CopyFile call
+type copier struct {
+	ctx          context.Context
+	src, dst     string
+	srcFS, dstFS FS
+	opts         CopyOptions
+	source       fs.File
+	dest         File
+	info         fs.FileInfo // of the source
+	hash         hash.Hash   // of the source, nil without Verify
+	copied       int64
+	progress     progressThrottle
+}
+
+// run copies phase by phase and cleans up after a failure
+func (c *copier) run() error {
+	phase, err := c.copy()
+	if c.source != nil {
+		c.source.Close()
+	}
+	if err == nil {
+		return nil
+	}
+
+	copyErr := &CopyError{Src: c.src, Dst: c.dst, Phase: phase, BytesCopied: c.copied, Err: err}
+	if c.dest == nil {
+		return copyErr
+	}
+
+	if closeErr := c.dest.Close(); closeErr != nil && !errors.Is(closeErr, fs.ErrClosed) {
+		copyErr.Err = apperrors.Join(copyErr.Err, fileError("close", c.dst, closeErr))
+	}
+	// A destination that failed verification can't be resumed, so it is
+	// removed even with KeepPartial
+	if c.opts.KeepPartial && phase != PhaseVerify {
+		copyErr.Partial = true
+	} else if removeErr := c.dstFS.Remove(c.dst); removeErr != nil && !errors.Is(removeErr, fs.ErrNotExist) {
+		copyErr.Err = apperrors.Join(copyErr.Err, fileError("remove", c.dst, removeErr))
+	}
+	return copyErr
+}
+
+// copy does the work, returning the phase that failed
+func (c *copier) copy() (CopyPhase, error) {
+	// Give fault injection a chance to fail the copy
+	if err := faults.Inject(c.ctx, "fileops.copy"); err != nil {
+		return PhaseOpen, fileError("open", c.src, err)
+	}
+
+	if err := c.open(); err != nil {
+		return PhaseOpen, err
+	}
+	if err := c.resume(); err != nil {
+		return PhaseResume, err
+	}
+	if err := c.copyData(); err != nil {
+		return PhaseCopy, err
+	}
+
+	if err := c.dest.Sync(); err != nil {
+		return PhaseSync, fileError("sync", c.dst, err)
+	}
+	if err := c.dest.Close(); err != nil {
+		return PhaseSync, fileError("close", c.dst, err)
+	}
+
+	if err := c.verify(); err != nil {
+		return PhaseVerify, err
+	}
+
+	if err := c.dstFS.Chmod(c.dst, c.info.Mode().Perm()); err != nil {
+		return PhaseMetadata, fileError("chmod", c.dst, err)
+	}
+	if err := c.dstFS.Chtimes(c.dst, time.Now(), c.info.ModTime()); err != nil {
+		return PhaseMetadata, fileError("chtimes", c.dst, err)
+	}
+	return "", nil
+}
+
+// open opens the source and the destination, keeping the destination's
+// content if it can be resumed
+func (c *copier) open() error {
+	source, err := c.srcFS.Open(c.src)
+	if err != nil {
+		return fileError("open", c.src, err)
+	}
+	c.source = source
+
+	if c.info, err = source.Stat(); err != nil {
+		return fileError("stat", c.src, err)
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if destInfo, err := c.dstFS.Stat(c.dst); err == nil {
+		// Truncating the source would destroy the data being copied
+		if sameFile(c.info, destInfo) {
+			return errors.New("source and destination are the same file")
+		}
+		if c.opts.Resume && destInfo.Mode().IsRegular() && destInfo.Size() <= c.info.Size() {
+			flags &^= os.O_TRUNC
+			c.copied = destInfo.Size()
+		}
+	}
+
+	dest, err := c.dstFS.OpenFile(c.dst, flags, c.info.Mode().Perm())
+	if err != nil {
+		return fileError("create", c.dst, err)
+	}
+	c.dest = dest
+	return nil
+}
+
+// resume moves past the part of the source already in the destination,
+// hashing it for Verify
+func (c *copier) resume() error {
+	if c.copied == 0 {
+		return nil
+	}
+
+	seeker, canSeek := c.source.(io.Seeker)
+	if c.hash != nil || !canSeek {
+		var skipped io.Writer = io.Discard
+		if c.hash != nil {
+			skipped = c.hash
+		}
+		if _, err := io.CopyN(skipped, NewContextReader(c.ctx, c.source), c.copied); err != nil {
+			return fileError("read", c.src, err)
+		}
+	} else if _, err := seeker.Seek(c.copied, io.SeekStart); err != nil {
+		return fileError("seek", c.src, err)
+	}
+
+	if _, err := c.dest.Seek(c.copied, io.SeekStart); err != nil {
+		return fileError("seek", c.dst, err)
+	}
+	return nil
+}
+
+// copyData copies the rest of the source chunk by chunk
+func (c *copier) copyData() error {
+	total := c.info.Size()
+	c.progress = progressThrottle{fn: c.opts.Progress, interval: c.opts.ProgressInterval}
+	reader := NewContextReader(c.ctx, c.source)
+	buffer := make([]byte, DefaultChunkSize)
+
+	for {
+		n, err := reader.Read(buffer)
+		if n > 0 {
+			if _, err := c.dest.Write(buffer[:n]); err != nil {
+				return fileError("write", c.dst, err)
+			}
+			if c.hash != nil {
+				c.hash.Write(buffer[:n])
+			}
+			c.copied += int64(n)
+			c.progress.report(c.copied, total, false)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fileError("read", c.src, err)
+		}
+	}
+
+	c.progress.report(c.copied, total, true)
+	return nil
+}
+
+// verify compares the checksum of the destination with that of the source
+func (c *copier) verify() error {
+	if c.hash == nil {
+		return nil
+	}
+
+	dest, err := c.dstFS.Open(c.dst)
+	if err != nil {
+		return fileError("open", c.dst, err)
+	}
+	defer dest.Close()
+
+	destHash := sha256.New()
+	if _, err := io.Copy(destHash, NewContextReader(c.ctx, dest)); err != nil {
+		return fileError("read", c.dst, err)
+	}
+
+	if want, got := c.hash.Sum(nil), destHash.Sum(nil); !bytes.Equal(want, got) {
+		return errors.Wrapf(ErrChecksumMismatch, "source sha256 %x, destination sha256 %x", want, got)
+	}
+	return nil
+}
+
+// progressThrottle limits how often a progress callback is called
+type progressThrottle struct {
+	fn       func(copied, total int64)
+	interval time.Duration
+	last     time.Time
+}
+
+// report calls the callback if the interval has passed since the last
+// call, or unconditionally for the final report
+func (p *progressThrottle) report(copied, total int64, final bool) {
+	if p.fn == nil {
+		return
+	}
+
+	interval := p.interval
+	if interval <= 0 {
+		interval = DefaultProgressInterval
+	}
+
+	now := time.Now()
+	if !final && now.Sub(p.last) < interval {
+		return
+	}
+	p.last = now
+	p.fn(copied, total)
+}
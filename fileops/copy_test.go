@@ -0,0 +1,203 @@
+package fileops
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// randomishData returns size bytes that differ from chunk to chunk
+func randomishData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i*7 + i/DefaultChunkSize)
+	}
+	return data
+}
+
+// copyFixture creates a source file and returns its path, its content and
+// the destination path next to it
+func copyFixture(t *testing.T, size int) (src string, data []byte, dst string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	src = filepath.Join(dir, "src.bin")
+	data = randomishData(size)
+	if err := os.WriteFile(src, data, 0640); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	// An old modification time shows whether it was preserved
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(src, mtime, mtime); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+	return src, data, filepath.Join(dir, "dst.bin")
+}
+
+// expectCopyError returns err as a *CopyError or fails the test
+func expectCopyError(t *testing.T, err error, phase CopyPhase) *CopyError {
+	t.Helper()
+
+	var copyErr *CopyError
+	if !errors.As(err, &copyErr) {
+		t.Fatalf("got error %v, want a *CopyError", err)
+	}
+	if copyErr.Phase != phase {
+		t.Fatalf("copy failed during %s, want %s: %v", copyErr.Phase, phase, err)
+	}
+	return copyErr
+}
+
+func TestCopyFile(t *testing.T) {
+	src, data, dst := copyFixture(t, 5*DefaultChunkSize+123)
+
+	var calls []int64
+	err := CopyFile(context.Background(), src, dst, CopyOptions{
+		Verify:           true,
+		Progress:         func(copied, total int64) { calls = append(calls, copied) },
+		ProgressInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("CopyFile failed: %v", err)
+	}
+
+	if got, _ := os.ReadFile(dst); !bytes.Equal(got, data) {
+		t.Fatal("destination differs from source")
+	}
+
+	srcInfo, _ := os.Stat(src)
+	dstInfo, err := os.Stat(dst)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if dstInfo.Mode() != srcInfo.Mode() || !dstInfo.ModTime().Equal(srcInfo.ModTime()) {
+		t.Errorf("destination has mode %v and mtime %v, want %v and %v",
+			dstInfo.Mode(), dstInfo.ModTime(), srcInfo.Mode(), srcInfo.ModTime())
+	}
+
+	// Throttled to the first chunk and the final report
+	if len(calls) != 2 || calls[0] != DefaultChunkSize || calls[1] != int64(len(data)) {
+		t.Errorf("progress reported %v, want [%d %d]", calls, DefaultChunkSize, len(data))
+	}
+}
+
+func TestCopyFileResumes(t *testing.T) {
+	src, data, dst := copyFixture(t, 4*DefaultChunkSize)
+	half := len(data) / 2
+	if err := os.WriteFile(dst, data[:half], 0600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	var first int64 = -1
+	err := CopyFile(context.Background(), src, dst, CopyOptions{
+		Resume: true,
+		Verify: true,
+		Progress: func(copied, total int64) {
+			if first < 0 {
+				first = copied
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("CopyFile failed: %v", err)
+	}
+
+	if got, _ := os.ReadFile(dst); !bytes.Equal(got, data) {
+		t.Fatal("resumed destination differs from source")
+	}
+	if first != int64(half+DefaultChunkSize) {
+		t.Errorf("first progress report = %d, want %d", first, half+DefaultChunkSize)
+	}
+}
+
+func TestCopyFileResumeDetectsForeignPrefix(t *testing.T) {
+	src, data, dst := copyFixture(t, 2*DefaultChunkSize)
+	if err := os.WriteFile(dst, bytes.Repeat([]byte("?"), len(data)/2), 0600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	err := CopyFile(context.Background(), src, dst, CopyOptions{Resume: true, Verify: true, KeepPartial: true})
+	copyErr := expectCopyError(t, err, PhaseVerify)
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("got %v, want ErrChecksumMismatch", err)
+	}
+	if copyErr.Partial {
+		t.Error("a destination failing verification was kept")
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("destination still exists after a failed verification (stat error %v)", err)
+	}
+}
+
+func TestCopyFileCancelKeepsPartialForResume(t *testing.T) {
+	checkGoroutineLeaks(t)
+	src, data, dst := copyFixture(t, 8*DefaultChunkSize)
+
+	// Cancel after three chunks
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err := CopyFile(ctx, src, dst, CopyOptions{
+		KeepPartial:      true,
+		ProgressInterval: time.Nanosecond,
+		Progress: func(copied, total int64) {
+			if copied >= 3*DefaultChunkSize {
+				cancel()
+			}
+		},
+	})
+
+	copyErr := expectCopyError(t, err, PhaseCopy)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if copyErr.BytesCopied != 3*DefaultChunkSize || !copyErr.Partial {
+		t.Errorf("CopyError = %+v, want 3 chunks copied and kept", copyErr)
+	}
+	if info, err := os.Stat(dst); err != nil || info.Size() != copyErr.BytesCopied {
+		t.Fatalf("partial destination: %v, %v", info, err)
+	}
+
+	// A second attempt picks up where the first one stopped
+	if err := CopyFile(context.Background(), src, dst, CopyOptions{Resume: true, Verify: true}); err != nil {
+		t.Fatalf("resumed CopyFile failed: %v", err)
+	}
+	if got, _ := os.ReadFile(dst); !bytes.Equal(got, data) {
+		t.Fatal("resumed destination differs from source")
+	}
+}
+
+func TestCopyFileRemovesPartialOutput(t *testing.T) {
+	src, _, dst := copyFixture(t, 4*DefaultChunkSize)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err := CopyFile(ctx, src, dst, CopyOptions{
+		ProgressInterval: time.Nanosecond,
+		Progress:         func(copied, total int64) { cancel() },
+	})
+
+	copyErr := expectCopyError(t, err, PhaseCopy)
+	if copyErr.Partial {
+		t.Error("CopyError claims the partial destination was kept")
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("partial destination still exists (stat error %v)", err)
+	}
+}
+
+func TestCopyFileRefusesSameFile(t *testing.T) {
+	src, data, _ := copyFixture(t, 100)
+
+	err := CopyFile(context.Background(), src, src, CopyOptions{})
+	expectCopyError(t, err, PhaseOpen)
+
+	if got, _ := os.ReadFile(src); !bytes.Equal(got, data) {
+		t.Fatal("copying a file onto itself damaged it")
+	}
+}
@@ -0,0 +1,431 @@
+package fileops
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	apperrors "error-handling-demo/errors"
+	"error-handling-demo/faults"
+)
+
+// ErrLocked is matched by the *LockError returned when a lock is held by
+// someone else
+var ErrLocked = errors.New("file is locked")
+
+// LockSuffix is appended to a file's name to get the lock file that
+// WriteFileLocked and utils.FileLogger coordinate on
+const LockSuffix = ".lock"
+
+// Register the lock contention configured rules can inject
+func init() {
+	faults.RegisterError("file_locked", ErrLocked)
+}
+
+// LockError reports a lock that couldn't be acquired
+type LockError struct {
+	Path string
+	PID  int // Process holding a LockFile, 0 if unknown
+}
+
+// Error implements the error interface
+func (e *LockError) Error() string {
+	if e.PID != 0 {
+		return fmt.Sprintf("%s is locked by process %d", e.Path, e.PID)
+	}
+	return fmt.Sprintf("%s is locked", e.Path)
+}
+
+// Is makes a LockError match ErrLocked
+func (e *LockError) Is(target error) bool {
+	return target == ErrLocked
+}
+
+// LockMode selects between a shared and an exclusive FileLock
+type LockMode int
+
+// Lock modes
+const (
+	LockShared    LockMode = iota // Any number of holders, e.g. readers
+	LockExclusive                 // A single holder, e.g. a writer
+)
+
+// String returns "shared" or "exclusive"
+func (m LockMode) String() string {
+	if m == LockExclusive {
+		return "exclusive"
+	}
+	return "shared"
+}
+
+// lockRetryMax caps the wait between two attempts to take a busy lock
+const lockRetryMax = 50 * time.Millisecond
+
+// waitForLock calls try until it succeeds or fails with something other
+// than ErrLocked. A timeout of 0 makes a single attempt, a negative one
+// waits for as long as ctx allows. The last *LockError is returned once
+// the timeout passes, and ctx's error once ctx is done.
+func waitForLock(ctx context.Context, path string, timeout time.Duration, try func() error) error {
+	var deadline time.Time
+	if timeout >= 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	wait := time.Millisecond
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return errors.Wrapf(err, "waiting for lock on %s", path)
+		}
+		err := faults.Inject(ctx, "fileops.lock")
+		if err == nil {
+			err = try()
+		}
+		if !errors.Is(err, ErrLocked) {
+			return err
+		}
+
+		if !deadline.IsZero() {
+			left := time.Until(deadline)
+			if left <= 0 {
+				return err
+			}
+			if wait > left {
+				wait = left
+			}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Wrapf(ctx.Err(), "waiting for lock on %s", path)
+		case <-timer.C:
+		}
+		if wait *= 2; wait > lockRetryMax {
+			wait = lockRetryMax
+		}
+	}
+}
+
+// FileLock is an advisory lock on a file: flock(2) on Unix, LockFileEx on
+// Windows. It only keeps out others taking a FileLock on the same path;
+// plain reads and writes are not blocked. The lock belongs to the open file
+// rather than the process, so two FileLocks on the same path exclude each
+// other even within one process. The operating system releases it when the
+// process exits, however that happens.
+//
+// A FileLock is safe for concurrent use, but holds at most one lock at a
+// time.
+type FileLock struct {
+	path string
+
+	mu     sync.Mutex
+	file   *os.File // Open while the lock is held
+	mode   LockMode
+	kept   *os.File // Open until Close, see OpenFileLock
+	closed bool
+}
+
+// NewFileLock returns an unlocked FileLock on path. The file is created
+// when it is first locked, and left in place when unlocked.
+func NewFileLock(path string) *FileLock {
+	return &FileLock{path: path}
+}
+
+// OpenFileLock returns an unlocked FileLock on path that opens the file
+// once and keeps it open between locks, for callers that lock often, such
+// as a log writer. Close it once done.
+func OpenFileLock(path string) (*FileLock, error) {
+	file, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, fileError("lock", path, err)
+	}
+	return &FileLock{path: path, kept: file}, nil
+}
+
+// Path returns the locked file's name
+func (l *FileLock) Path() string {
+	return l.path
+}
+
+// Lock waits until the lock is acquired in mode or ctx is done
+func (l *FileLock) Lock(ctx context.Context, mode LockMode) error {
+	return l.TryLock(ctx, mode, -1)
+}
+
+// TryLock acquires the lock in mode, waiting up to timeout for whoever
+// holds it. A timeout of 0 makes a single attempt. It returns a *LockError
+// matching ErrLocked if the lock is still held when the timeout passes,
+// and ctx's error if ctx is done first.
+func (l *FileLock) TryLock(ctx context.Context, mode LockMode, timeout time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		return errors.Errorf("%s is already locked (%s)", l.path, l.mode)
+	}
+	if l.closed {
+		return errors.Errorf("%s is closed", l.path)
+	}
+
+	file := l.kept
+	if file == nil {
+		var err error
+		if file, err = os.OpenFile(l.path, os.O_RDONLY|os.O_CREATE, 0666); err != nil {
+			return fileError("lock", l.path, err)
+		}
+	}
+	err := waitForLock(ctx, l.path, timeout, func() error {
+		busy, err := tryLockFile(file, mode)
+		if busy {
+			return &LockError{Path: l.path}
+		}
+		if err != nil {
+			return fileError("lock", l.path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		if file != l.kept {
+			file.Close()
+		}
+		return err
+	}
+	l.file, l.mode = file, mode
+	return nil
+}
+
+// Unlock releases the lock. Unlocking a FileLock that isn't locked is an
+// error.
+func (l *FileLock) Unlock() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return errors.Errorf("%s is not locked", l.path)
+	}
+
+	file := l.file
+	l.file = nil
+	err := unlockFile(file)
+	// Closing the file releases the lock in any case, a kept one is closed
+	// by Close
+	if file != l.kept {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return fileError("unlock", l.path, err)
+	}
+	return nil
+}
+
+// Close releases the lock if it is held and closes the file kept open by
+// OpenFileLock. The FileLock can't be locked again afterwards.
+func (l *FileLock) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+
+	// A held lock is on the kept file if there is one
+	file := l.kept
+	if file == nil {
+		file = l.file
+	}
+	l.file, l.kept = nil, nil
+	if file == nil {
+		return nil
+	}
+	if err := file.Close(); err != nil {
+		return fileError("unlock", l.path, err)
+	}
+	return nil
+}
+
+// lockFileGrace is how long a lock file without a readable owner is
+// assumed to be still being written by its creator
+const lockFileGrace = 5 * time.Second
+
+// LockFile is a lock held by creating a file that names its owner, for
+// file systems where FileLock is unreliable, such as NFS. The file holds
+// the owner's PID and host name. A lock file left behind by a process that
+// is gone is stale: it is removed and taken over. Owners on other hosts
+// are never considered gone.
+//
+// Taking over a stale lock file re-reads it right before removing it, but
+// two processes doing so at the very same moment can still both succeed.
+// Unlike a FileLock, a LockFile is not released when its process exits.
+type LockFile struct {
+	path string
+
+	mu    sync.Mutex
+	owner string // Content written while the lock is held
+}
+
+// NewLockFile returns an unlocked LockFile at path
+func NewLockFile(path string) *LockFile {
+	return &LockFile{path: path}
+}
+
+// Path returns the lock file's name
+func (l *LockFile) Path() string {
+	return l.path
+}
+
+// Lock waits until the lock file is created or ctx is done
+func (l *LockFile) Lock(ctx context.Context) error {
+	return l.TryLock(ctx, -1)
+}
+
+// TryLock creates the lock file, waiting up to timeout for its owner to
+// remove it; a timeout of 0 makes a single attempt. It returns a
+// *LockError with the owner's PID if the file is still there when the
+// timeout passes, and ctx's error if ctx is done first.
+func (l *LockFile) TryLock(ctx context.Context, timeout time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.owner != "" {
+		return errors.Errorf("%s is already locked", l.path)
+	}
+
+	owner := lockOwner(os.Getpid())
+	err := waitForLock(ctx, l.path, timeout, func() error {
+		return l.create(owner)
+	})
+	if err != nil {
+		return err
+	}
+	l.owner = owner
+	return nil
+}
+
+// create makes the lock file, taking it over if it is stale
+func (l *LockFile) create(owner string) error {
+	for {
+		file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = file.WriteString(owner)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(l.path)
+				return fileError("lock", l.path, err)
+			}
+			return nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return fileError("lock", l.path, err)
+		}
+
+		content, info, err := readLockFile(l.path)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Released in the meantime
+			continue
+		}
+		if err != nil {
+			return fileError("lock", l.path, err)
+		}
+		pid, stale := staleOwner(content, info)
+		if !stale {
+			return &LockError{Path: l.path, PID: pid}
+		}
+
+		// Make sure it wasn't taken over since it was read
+		if current, _, err := readLockFile(l.path); err == nil && current != content {
+			continue
+		}
+		if err := os.Remove(l.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fileError("lock", l.path, err)
+		}
+	}
+}
+
+// Unlock removes the lock file. It fails without removing anything if the
+// file was taken over by someone else in the meantime.
+func (l *LockFile) Unlock() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.owner == "" {
+		return errors.Errorf("%s is not locked", l.path)
+	}
+
+	owner := l.owner
+	l.owner = ""
+	content, _, err := readLockFile(l.path)
+	if err != nil {
+		return fileError("unlock", l.path, err)
+	}
+	if content != owner {
+		return errors.Errorf("lock file %s was taken over", l.path)
+	}
+	if err := os.Remove(l.path); err != nil {
+		return fileError("unlock", l.path, err)
+	}
+	return nil
+}
+
+// lockOwner is the content of a lock file owned by pid on this host
+func lockOwner(pid int) string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%d %s\n", pid, host)
+}
+
+// readLockFile returns the content of a lock file and its description
+func readLockFile(path string) (string, fs.FileInfo, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", nil, err
+	}
+	return string(content), info, nil
+}
+
+// staleOwner parses a lock file and reports whether its owner is gone. A
+// file without a readable owner is stale once it is older than
+// lockFileGrace.
+func staleOwner(content string, info fs.FileInfo) (int, bool) {
+	fields := strings.Fields(content)
+	pid := 0
+	if len(fields) > 0 {
+		pid, _ = strconv.Atoi(fields[0])
+	}
+	if pid <= 0 {
+		return 0, time.Since(info.ModTime()) > lockFileGrace
+	}
+
+	host, _ := os.Hostname()
+	if len(fields) < 2 || fields[1] != host {
+		return pid, false
+	}
+	return pid, !processAlive(pid)
+}
+
+// WriteFileLocked is WriteFile while holding an exclusive FileLock on
+// filename+LockSuffix, waiting up to timeout for it. The lock file is used
+// because an atomic write replaces filename, so a lock on filename itself
+// wouldn't outlive the write. Readers that must not see a half-updated
+// set of files take a shared lock on the same lock file.
+func WriteFileLocked(ctx context.Context, filename string, content string, timeout time.Duration) error {
+	lock := NewFileLock(filename + LockSuffix)
+	if err := lock.TryLock(ctx, LockExclusive, timeout); err != nil {
+		return err
+	}
+
+	err := WriteFile(filename, content)
+	if unlockErr := lock.Unlock(); unlockErr != nil {
+		err = apperrors.Join(err, unlockErr)
+	}
+	return err
+}
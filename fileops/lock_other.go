@@ -0,0 +1,24 @@
+//go:build !unix && !windows
+
+package fileops
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// tryLockFile fails: FileLock is only supported on Unix and Windows
+func tryLockFile(file *os.File, mode LockMode) (busy bool, err error) {
+	return false, errors.New("file locking is not supported on this system")
+}
+
+// unlockFile has nothing to release
+func unlockFile(file *os.File) error {
+	return nil
+}
+
+// processAlive can't tell, so lock files are never considered stale
+func processAlive(pid int) bool {
+	return true
+}
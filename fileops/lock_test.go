@@ -0,0 +1,279 @@
+package fileops
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"error-handling-demo/faults"
+)
+
+// expectLocked fails unless err is a *LockError on path
+func expectLocked(t *testing.T, err error, path string) *LockError {
+	t.Helper()
+	var lockErr *LockError
+	if !errors.Is(err, ErrLocked) || !errors.As(err, &lockErr) || lockErr.Path != path {
+		t.Fatalf("got %v, want a LockError on %s", err, path)
+	}
+	return lockErr
+}
+
+func TestFileLockModes(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "data.lock")
+	reader1, reader2, writer := NewFileLock(path), NewFileLock(path), NewFileLock(path)
+
+	// Shared locks go together but keep an exclusive one out
+	if err := reader1.TryLock(ctx, LockShared, 0); err != nil {
+		t.Fatalf("shared lock failed: %v", err)
+	}
+	if err := reader2.TryLock(ctx, LockShared, 0); err != nil {
+		t.Fatalf("second shared lock failed: %v", err)
+	}
+	expectLocked(t, writer.TryLock(ctx, LockExclusive, 0), path)
+	if err := reader1.TryLock(ctx, LockShared, 0); err == nil || errors.Is(err, ErrLocked) {
+		t.Errorf("locking a held FileLock again returned %v", err)
+	}
+
+	for _, reader := range []*FileLock{reader1, reader2} {
+		if err := reader.Unlock(); err != nil {
+			t.Fatalf("Unlock failed: %v", err)
+		}
+	}
+	if err := writer.TryLock(ctx, LockExclusive, 0); err != nil {
+		t.Fatalf("exclusive lock failed once free: %v", err)
+	}
+
+	// An exclusive lock keeps everyone out
+	expectLocked(t, reader1.TryLock(ctx, LockShared, 0), path)
+	expectLocked(t, reader2.TryLock(ctx, LockExclusive, 0), path)
+	if err := writer.Unlock(); err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+	if err := writer.Unlock(); err == nil {
+		t.Error("unlocking twice succeeded")
+	}
+}
+
+func TestFileLockWaits(t *testing.T) {
+	checkGoroutineLeaks(t)
+	path := filepath.Join(t.TempDir(), "data.lock")
+	holder, waiter := NewFileLock(path), NewFileLock(path)
+	if err := holder.Lock(context.Background(), LockExclusive); err != nil {
+		t.Fatal(err)
+	}
+
+	// The timeout passes
+	start := time.Now()
+	expectLocked(t, waiter.TryLock(context.Background(), LockExclusive, 50*time.Millisecond), path)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Errorf("TryLock gave up after %v, want 50ms", elapsed)
+	}
+
+	// The context ends first
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := waiter.TryLock(ctx, LockShared, time.Minute); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("TryLock with an expired context returned %v", err)
+	}
+
+	// The holder lets go
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		holder.Unlock()
+	}()
+	if err := waiter.Lock(context.Background(), LockExclusive); err != nil {
+		t.Fatalf("Lock failed once released: %v", err)
+	}
+	waiter.Unlock()
+}
+
+func TestOpenFileLockKeepsFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "app.log.lock")
+	lock, err := OpenFileLock(path)
+	if err != nil {
+		t.Fatalf("OpenFileLock failed: %v", err)
+	}
+	other := NewFileLock(path)
+
+	// The same file serves every lock and still excludes others
+	kept := lock.kept
+	for i := 0; i < 3; i++ {
+		if err := lock.TryLock(ctx, LockExclusive, 0); err != nil {
+			t.Fatalf("lock %d failed: %v", i, err)
+		}
+		if lock.file != kept {
+			t.Fatal("the lock reopened its file")
+		}
+		expectLocked(t, other.TryLock(ctx, LockShared, 0), path)
+		if err := lock.Unlock(); err != nil {
+			t.Fatalf("Unlock %d failed: %v", i, err)
+		}
+		if _, err := kept.Stat(); err != nil {
+			t.Fatalf("Unlock closed the kept file: %v", err)
+		}
+	}
+
+	// Closing releases a held lock and ends the FileLock
+	if err := lock.TryLock(ctx, LockExclusive, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := other.TryLock(ctx, LockExclusive, 0); err != nil {
+		t.Errorf("lock still held after Close: %v", err)
+	}
+	other.Unlock()
+	if err := lock.TryLock(ctx, LockExclusive, 0); err == nil {
+		t.Error("a closed FileLock was locked again")
+	}
+	if err := lock.Close(); err != nil {
+		t.Errorf("closing twice returned %v", err)
+	}
+}
+
+func TestFileLockInjectedContention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.lock")
+	injector := faults.NewInjector(1, faults.Rule{Op: "fileops.lock", Probability: 1, Err: ErrLocked, Times: 2})
+	faults.Enable(injector)
+	defer faults.Disable()
+	ctx := context.Background()
+
+	lock := NewFileLock(path)
+	if err := lock.TryLock(ctx, LockExclusive, 0); !errors.Is(err, ErrLocked) {
+		t.Errorf("injected contention returned %v", err)
+	}
+	if err := lock.TryLock(ctx, LockExclusive, time.Second); err != nil {
+		t.Fatalf("TryLock didn't retry past injected contention: %v", err)
+	}
+	lock.Unlock()
+}
+
+// deadPID returns the PID of a process that has exited
+func deadPID(t *testing.T) int {
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestLockFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "job.pid")
+	first, second := NewLockFile(path), NewLockFile(path)
+
+	if err := first.TryLock(ctx, 0); err != nil {
+		t.Fatalf("TryLock failed: %v", err)
+	}
+	if content := readFile(t, path); content != lockOwner(os.Getpid()) {
+		t.Errorf("lock file holds %q", content)
+	}
+	if lockErr := expectLocked(t, second.TryLock(ctx, 20*time.Millisecond), path); lockErr.PID != os.Getpid() {
+		t.Errorf("LockError names process %d, want %d", lockErr.PID, os.Getpid())
+	}
+
+	if err := first.Unlock(); err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("lock file left behind: %v", err)
+	}
+	if err := second.TryLock(ctx, 0); err != nil {
+		t.Fatalf("TryLock failed once released: %v", err)
+	}
+
+	// A lock taken over meanwhile isn't removed
+	if err := os.WriteFile(path, []byte(lockOwner(1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Unlock(); err == nil {
+		t.Error("Unlock removed someone else's lock file")
+	}
+	if content := readFile(t, path); content != lockOwner(1) {
+		t.Errorf("lock file holds %q after a failed Unlock", content)
+	}
+}
+
+func TestLockFileStale(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	old := time.Now().Add(-time.Hour)
+	for _, tc := range []struct {
+		name    string
+		content string
+		mtime   time.Time
+		stale   bool
+	}{
+		{"dead process", lockOwner(deadPID(t)), time.Now(), true},
+		{"live process", lockOwner(os.Getpid()), old, false},
+		{"other host", strconv.Itoa(deadPID(t)) + " elsewhere.example\n", old, false},
+		{"being written", "", time.Now(), false},
+		{"abandoned", "", old, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(dir, tc.name+".pid")
+			if err := os.WriteFile(path, []byte(tc.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(path, tc.mtime, tc.mtime); err != nil {
+				t.Fatal(err)
+			}
+
+			lock := NewLockFile(path)
+			err := lock.TryLock(ctx, 0)
+			if !tc.stale {
+				expectLocked(t, err, path)
+				if content := readFile(t, path); content != tc.content {
+					t.Errorf("lock file changed to %q", content)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("stale lock file not taken over: %v", err)
+			}
+			if content := readFile(t, path); content != lockOwner(os.Getpid()) {
+				t.Errorf("lock file holds %q after the takeover", content)
+			}
+			if err := lock.Unlock(); err != nil {
+				t.Errorf("Unlock failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestWriteFileLocked(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "report.txt")
+	holder := NewFileLock(path + LockSuffix)
+	if err := holder.Lock(ctx, LockShared); err != nil {
+		t.Fatal(err)
+	}
+
+	expectLocked(t, WriteFileLocked(ctx, path, "new", 20*time.Millisecond), path+LockSuffix)
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("file written without the lock: %v", err)
+	}
+
+	holder.Unlock()
+	if err := WriteFileLocked(ctx, path, "new", 0); err != nil {
+		t.Fatalf("WriteFileLocked failed: %v", err)
+	}
+	if content := readFile(t, path); content != "new" {
+		t.Errorf("file holds %q", content)
+	}
+
+	// The lock is released afterwards
+	if err := holder.TryLock(ctx, LockExclusive, 0); err != nil {
+		t.Errorf("lock still held after the write: %v", err)
+	}
+	holder.Unlock()
+}
@@ -0,0 +1,41 @@
+//go:build unix
+
+package fileops
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// tryLockFile takes a flock(2) lock on file without waiting. busy reports
+// that someone else holds it.
+func tryLockFile(file *os.File, mode LockMode) (busy bool, err error) {
+	how := unix.LOCK_SH
+	if mode == LockExclusive {
+		how = unix.LOCK_EX
+	}
+	for {
+		err = unix.Flock(int(file.Fd()), how|unix.LOCK_NB)
+		if err != unix.EINTR {
+			break
+		}
+	}
+	if err == unix.EWOULDBLOCK {
+		return true, nil
+	}
+	return false, err
+}
+
+// unlockFile releases the lock taken by tryLockFile
+func unlockFile(file *os.File) error {
+	return unix.Flock(int(file.Fd()), unix.LOCK_UN)
+}
+
+// processAlive reports whether pid is a running process. A process we may
+// not signal still exists.
+func processAlive(pid int) bool {
+	err := unix.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,51 @@
+//go:build windows
+
+package fileops
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockLength is the byte range locked by tryLockFile. Locks on Windows are
+// byte ranges; they may lie past the end of the file.
+const lockLength = 1
+
+// tryLockFile takes a LockFileEx lock on file without waiting. busy reports
+// that someone else holds it.
+func tryLockFile(file *os.File, mode LockMode) (busy bool, err error) {
+	flags := uint32(windows.LOCKFILE_FAIL_IMMEDIATELY)
+	if mode == LockExclusive {
+		flags |= windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+	err = windows.LockFileEx(windows.Handle(file.Fd()), flags, 0, lockLength, 0, &windows.Overlapped{})
+	if err == windows.ERROR_LOCK_VIOLATION {
+		return true, nil
+	}
+	return false, err
+}
+
+// unlockFile releases the lock taken by tryLockFile
+func unlockFile(file *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(file.Fd()), 0, lockLength, 0, &windows.Overlapped{})
+}
+
+// processAlive reports whether pid is a running process. A process we may
+// not query still exists.
+func processAlive(pid int) bool {
+	process, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return err != windows.ERROR_INVALID_PARAMETER
+	}
+	defer windows.CloseHandle(process)
+
+	var code uint32
+	if err := windows.GetExitCodeProcess(process, &code); err != nil {
+		return true
+	}
+	return code == stillActive
+}
+
+// stillActive is the exit code of a process that hasn't exited
+const stillActive = 259
@@ -0,0 +1,581 @@
+package fileops
+
+import (
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxSymlinkHops is how many links MemFS follows in one path before giving
+// up with ELOOP, as Linux does
+const maxSymlinkHops = 40
+
+// MemFS is an FS held in memory, for tests and scratch space. It is safe
+// for concurrent use. Names are io/fs paths, e.g. "docs/readme.md" with "."
+// for the root; on Windows backslashes are accepted too. Modes are recorded
+// but not enforced, as if every caller were root, and a symlink target
+// starting with "/" is relative to the root of the MemFS.
+type MemFS struct {
+	mu   sync.Mutex
+	root *memNode
+}
+
+// memNode is a file, directory or symlink of a MemFS
+type memNode struct {
+	mode     fs.FileMode
+	modTime  time.Time
+	data     []byte              // File content or link target
+	children map[string]*memNode // Directory entries
+}
+
+// NewMemFS returns an empty MemFS
+func NewMemFS() *MemFS {
+	return &MemFS{root: newMemDir(0755)}
+}
+
+// newMemDir returns an empty directory
+func newMemDir(perm fs.FileMode) *memNode {
+	return &memNode{mode: fs.ModeDir | perm&fs.ModePerm, modTime: time.Now(), children: make(map[string]*memNode)}
+}
+
+// info describes n under the base name of name
+func (n *memNode) info(name string) fs.FileInfo {
+	return &memInfo{name: path.Base(name), size: int64(len(n.data)), mode: n.mode, modTime: n.modTime, node: n}
+}
+
+// memLookup is the outcome of resolving a path in a MemFS
+type memLookup struct {
+	dir  *memNode // Directory holding the last element
+	base string   // Name of the last element in dir, "" for the root
+	node *memNode // The last element, nil if it doesn't exist
+	real string   // The path with links resolved
+}
+
+// cleanMemName turns name into a path relative to the root, "" for the root
+func cleanMemName(op, name string) (string, error) {
+	slashed := filepath.ToSlash(name)
+	if !fs.ValidPath(slashed) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if slashed == "." {
+		return "", nil
+	}
+	return slashed, nil
+}
+
+// resolve walks to name, following links in every element but the last,
+// and in the last one too if follow is set. The caller holds m.mu.
+func (m *MemFS) resolve(op, name string, follow bool) (memLookup, error) {
+	clean, err := cleanMemName(op, name)
+	if err != nil {
+		return memLookup{}, err
+	}
+
+	hops := 0
+	elems := splitMemPath(clean)
+	dir, real := m.root, ""
+	for len(elems) > 0 {
+		elem, last := elems[0], len(elems) == 1
+		elems = elems[1:]
+		if !dir.mode.IsDir() {
+			return memLookup{}, &fs.PathError{Op: op, Path: name, Err: syscall.ENOTDIR}
+		}
+
+		node := dir.children[elem]
+		if node == nil {
+			if last {
+				return memLookup{dir: dir, base: elem, real: path.Join(real, elem)}, nil
+			}
+			return memLookup{}, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		if node.mode&fs.ModeSymlink == 0 || (last && !follow) {
+			if last {
+				return memLookup{dir: dir, base: elem, node: node, real: path.Join(real, elem)}, nil
+			}
+			dir, real = node, path.Join(real, elem)
+			continue
+		}
+
+		// Restart from the root with the link replaced by its target
+		if hops++; hops > maxSymlinkHops {
+			return memLookup{}, &fs.PathError{Op: op, Path: name, Err: syscall.ELOOP}
+		}
+		target := string(node.data)
+		if !strings.HasPrefix(target, "/") {
+			target = path.Join(real, target)
+		}
+		elems = append(splitMemPath(strings.TrimPrefix(path.Clean("/"+target), "/")), elems...)
+		dir, real = m.root, ""
+	}
+	return memLookup{node: m.root}, nil
+}
+
+// splitMemPath splits a cleaned relative path into its elements
+func splitMemPath(name string) []string {
+	if name == "" {
+		return nil
+	}
+	return strings.Split(name, "/")
+}
+
+// existing resolves name and fails unless it exists
+func (m *MemFS) existing(op, name string, follow bool) (memLookup, error) {
+	l, err := m.resolve(op, name, follow)
+	if err == nil && l.node == nil {
+		err = &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return l, err
+}
+
+// Open implements fs.FS
+func (m *MemFS) Open(name string) (fs.File, error) {
+	return m.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// OpenFile implements FS
+func (m *MemFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, err := m.resolve("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	switch {
+	case l.node == nil:
+		if flag&os.O_CREATE == 0 {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		l.node = &memNode{mode: perm & fs.ModePerm, modTime: time.Now()}
+		l.dir.children[l.base] = l.node
+		l.dir.modTime = l.node.modTime
+	case flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case l.node.mode.IsDir() && writable:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	case flag&os.O_TRUNC != 0 && writable:
+		l.node.data = nil
+		l.node.modTime = time.Now()
+	}
+	return &memFile{fsys: m, node: l.node, name: name, flag: flag}, nil
+}
+
+// Stat implements fs.StatFS
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	return m.stat("stat", name, true)
+}
+
+// Lstat implements FS
+func (m *MemFS) Lstat(name string) (fs.FileInfo, error) {
+	return m.stat("lstat", name, false)
+}
+
+// stat describes name
+func (m *MemFS) stat(op, name string, follow bool) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, err := m.existing(op, name, follow)
+	if err != nil {
+		return nil, err
+	}
+	return l.node.info(l.real), nil
+}
+
+// ReadDir implements fs.ReadDirFS
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, err := m.existing("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !l.node.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	return l.node.entries(), nil
+}
+
+// entries lists a directory sorted by name. The caller holds the lock.
+func (n *memNode) entries() []fs.DirEntry {
+	names := make([]string, 0, len(n.children))
+	for name := range n.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	entries := make([]fs.DirEntry, len(names))
+	for i, name := range names {
+		entries[i] = fs.FileInfoToDirEntry(n.children[name].info(name))
+	}
+	return entries
+}
+
+// CreateTemp implements FS. The last "*" in pattern is replaced by a random
+// string; without one it is appended.
+func (m *MemFS) CreateTemp(dir, pattern string) (File, error) {
+	prefix, suffix := pattern, ""
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		prefix, suffix = pattern[:i], pattern[i+1:]
+	}
+
+	for try := 0; ; try++ {
+		name := filepath.Join(dir, prefix+strconv.FormatUint(uint64(rand.Uint32()), 10)+suffix)
+		f, err := m.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil || !errors.Is(err, fs.ErrExist) || try == 10000 {
+			return f, err
+		}
+	}
+}
+
+// MkdirAll implements FS
+func (m *MemFS) MkdirAll(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clean, err := cleanMemName("mkdir", name)
+	if err != nil {
+		return err
+	}
+
+	current := ""
+	for _, elem := range splitMemPath(clean) {
+		current = path.Join(current, elem)
+		l, err := m.resolve("mkdir", current, true)
+		if err != nil {
+			return err
+		}
+		switch {
+		case l.node == nil:
+			l.dir.children[l.base] = newMemDir(perm)
+			l.dir.modTime = time.Now()
+		case !l.node.mode.IsDir():
+			return &fs.PathError{Op: "mkdir", Path: current, Err: syscall.ENOTDIR}
+		}
+	}
+	return nil
+}
+
+// Remove implements FS
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, err := m.existing("remove", name, false)
+	if err != nil {
+		return err
+	}
+	if l.dir == nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+	if len(l.node.children) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+	}
+	delete(l.dir.children, l.base)
+	l.dir.modTime = time.Now()
+	return nil
+}
+
+// Rename implements FS. Like rename(2) it replaces an existing file, or an
+// empty directory if the source is a directory too.
+func (m *MemFS) Rename(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	linkErr := func(err error) error {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+
+	from, err := m.existing("rename", oldname, false)
+	if err != nil {
+		return linkErr(errorOf(err))
+	}
+	to, err := m.resolve("rename", newname, false)
+	if err != nil {
+		return linkErr(errorOf(err))
+	}
+	if from.dir == nil || to.dir == nil {
+		return linkErr(fs.ErrInvalid)
+	}
+	if from.node == to.node {
+		return nil
+	}
+	if from.node.mode.IsDir() && (to.real == from.real || strings.HasPrefix(to.real, from.real+"/")) {
+		return linkErr(fs.ErrInvalid)
+	}
+	if to.node != nil {
+		switch {
+		case to.node.mode.IsDir() && !from.node.mode.IsDir():
+			return linkErr(syscall.EISDIR)
+		case !to.node.mode.IsDir() && from.node.mode.IsDir():
+			return linkErr(syscall.ENOTDIR)
+		case len(to.node.children) > 0:
+			return linkErr(syscall.ENOTEMPTY)
+		}
+	}
+
+	delete(from.dir.children, from.base)
+	to.dir.children[to.base] = from.node
+	from.dir.modTime, to.dir.modTime = time.Now(), time.Now()
+	return nil
+}
+
+// errorOf returns the error inside a *fs.PathError
+func errorOf(err error) error {
+	if pathErr, ok := err.(*fs.PathError); ok {
+		return pathErr.Err
+	}
+	return err
+}
+
+// Chmod implements FS
+func (m *MemFS) Chmod(name string, mode fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, err := m.existing("chmod", name, true)
+	if err != nil {
+		return err
+	}
+	l.node.mode = l.node.mode&fs.ModeType | mode&fs.ModePerm
+	return nil
+}
+
+// Chtimes implements FS. MemFS keeps no access time.
+func (m *MemFS) Chtimes(name string, atime, mtime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, err := m.existing("chtimes", name, true)
+	if err != nil {
+		return err
+	}
+	l.node.modTime = mtime
+	return nil
+}
+
+// Symlink implements FS
+func (m *MemFS) Symlink(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, err := m.resolve("symlink", newname, false)
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: errorOf(err)}
+	}
+	if l.node != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: fs.ErrExist}
+	}
+	l.dir.children[l.base] = &memNode{mode: fs.ModeSymlink | 0777, modTime: time.Now(), data: []byte(oldname)}
+	return nil
+}
+
+// Readlink implements FS
+func (m *MemFS) Readlink(name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, err := m.existing("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if l.node.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return string(l.node.data), nil
+}
+
+// EvalSymlinks implements FS
+func (m *MemFS) EvalSymlinks(name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, err := m.existing("lstat", name, true)
+	if err != nil {
+		return "", err
+	}
+	if l.real == "" {
+		return ".", nil
+	}
+	return filepath.FromSlash(l.real), nil
+}
+
+// memFile is an open file of a MemFS
+type memFile struct {
+	fsys   *MemFS
+	node   *memNode
+	name   string
+	flag   int
+	offset int64
+	dirPos int // Entries returned by ReadDir so far
+	closed bool
+}
+
+// check fails if the file is closed or wasn't opened for op
+func (f *memFile) check(op string, write bool) error {
+	switch {
+	case f.closed:
+		return &fs.PathError{Op: op, Path: f.name, Err: fs.ErrClosed}
+	case write && f.flag&(os.O_WRONLY|os.O_RDWR) == 0, !write && f.flag&os.O_WRONLY != 0:
+		return &fs.PathError{Op: op, Path: f.name, Err: syscall.EBADF}
+	case f.node.mode.IsDir() && op != "readdir":
+		return &fs.PathError{Op: op, Path: f.name, Err: syscall.EISDIR}
+	}
+	return nil
+}
+
+// Read implements io.Reader
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	}
+	if f.offset >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[f.offset:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+// Write implements io.Writer
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	if err := f.check("write", true); err != nil {
+		return 0, err
+	}
+	if f.flag&os.O_APPEND != 0 {
+		f.offset = int64(len(f.node.data))
+	}
+	if end := f.offset + int64(len(p)); end > int64(len(f.node.data)) {
+		f.node.data = append(f.node.data, make([]byte, end-int64(len(f.node.data)))...)
+	}
+	copy(f.node.data[f.offset:], p)
+	f.offset += int64(len(p))
+	f.node.modTime = time.Now()
+	return len(p), nil
+}
+
+// Seek implements io.Seeker
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	if f.closed {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrClosed}
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.node.data))
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// ReadDir implements fs.ReadDirFile
+func (f *memFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	if err := f.check("readdir", false); err != nil {
+		return nil, err
+	}
+	if !f.node.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+	}
+
+	entries := f.node.entries()
+	if f.dirPos < len(entries) {
+		entries = entries[f.dirPos:]
+	} else {
+		entries = nil
+	}
+	if n > 0 {
+		if len(entries) == 0 {
+			return nil, io.EOF
+		}
+		if n < len(entries) {
+			entries = entries[:n]
+		}
+	}
+	f.dirPos += len(entries)
+	return entries, nil
+}
+
+// Stat implements fs.File
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	if f.closed {
+		return nil, &fs.PathError{Op: "stat", Path: f.name, Err: fs.ErrClosed}
+	}
+	return f.node.info(f.name), nil
+}
+
+// Name implements File
+func (f *memFile) Name() string {
+	return f.name
+}
+
+// Sync implements File; there is nothing to flush
+func (f *memFile) Sync() error {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	if f.closed {
+		return &fs.PathError{Op: "sync", Path: f.name, Err: fs.ErrClosed}
+	}
+	return nil
+}
+
+// Close implements fs.File
+func (f *memFile) Close() error {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	return nil
+}
+
+// memInfo describes a file of a MemFS
+type memInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	node    *memNode
+}
+
+func (i *memInfo) Name() string       { return i.name }
+func (i *memInfo) Size() int64        { return i.size }
+func (i *memInfo) Mode() fs.FileMode  { return i.mode }
+func (i *memInfo) ModTime() time.Time { return i.modTime }
+func (i *memInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *memInfo) Sys() interface{}   { return i.node }
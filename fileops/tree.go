@@ -0,0 +1,346 @@
+package fileops
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	apperrors "error-handling-demo/errors"
+)
+
+// SymlinkPolicy says what the tree operations do with symbolic links
+type SymlinkPolicy int
+
+const (
+	// SymlinkPreserve handles the link itself: it is copied as a link and
+	// removed without touching what it points to
+	SymlinkPreserve SymlinkPolicy = iota
+	// SymlinkFollow handles what the link points to, walking into linked
+	// directories. Links leading back to a directory being walked are
+	// reported as ErrSymlinkLoop.
+	SymlinkFollow
+	// SymlinkSkip leaves links alone
+	SymlinkSkip
+)
+
+// ErrSymlinkLoop is reported for a followed link that points to one of the
+// directories containing it
+var ErrSymlinkLoop = errors.New("symlink loop")
+
+// TreeOptions configure CopyTree, MoveTree, RemoveTree and SyncTree. Each
+// failure of these is reported as an *errors.FileError naming the
+// operation ("copy", "move", "remove", "sync", "readdir" or "stat") and the
+// path it failed on.
+type TreeOptions struct {
+	// Include limits the files handled to those matching one of these
+	// patterns. Exclude skips files and whole directories matching one of
+	// its patterns. Patterns use path.Match syntax and are matched against
+	// both the slash-separated path relative to the root, e.g. "docs/*.md",
+	// and the base name, e.g. "*.tmp".
+	Include []string
+	Exclude []string
+	// Symlinks defaults to SymlinkPreserve
+	Symlinks SymlinkPolicy
+	// Workers is the most files handled at once, DefaultTreeWorkers if 0
+	Workers int
+	// ContinueOnError handles every file even after failures and returns
+	// an *errors.MultiError listing each of them. Otherwise the first
+	// failure cancels the remaining work and is returned on its own.
+	ContinueOnError bool
+	// Copy configures the copy of each file by CopyTree, MoveTree and
+	// SyncTree; its Progress callback is called per file
+	Copy CopyOptions
+	// FS holds the trees and, unless DstFS is set, the destination of
+	// CopyTree, MoveTree and SyncTree. Both default to OSFS and replace
+	// those of Copy.
+	FS    FS
+	DstFS FS
+}
+
+// fileSystems returns the file systems of source and destination
+func (o TreeOptions) fileSystems() (src, dst FS) {
+	return CopyOptions{FS: o.FS, DstFS: o.DstFS}.fileSystems()
+}
+
+// DefaultTreeWorkers is the number of workers used when TreeOptions.Workers
+// is 0
+var DefaultTreeWorkers = runtime.NumCPU()
+
+// treeOp describes what a tree operation does with each entry. dir runs
+// before the entries of a directory are visited and may return fs.SkipDir;
+// dirDone runs once every file of the whole tree has been handled, deepest
+// directories first. file runs on a worker.
+type treeOp struct {
+	name    string
+	dir     func(rel, path string, info fs.FileInfo) error
+	file    func(ctx context.Context, rel, path string, info fs.FileInfo) error
+	dirDone func(rel, path string, info fs.FileInfo) error
+}
+
+// pendingDir is a directory waiting for its dirDone
+type pendingDir struct {
+	rel, path string
+	info      fs.FileInfo
+}
+
+// treeRunner walks trees and hands their files to a bounded set of workers
+type treeRunner struct {
+	opts   TreeOptions
+	parent context.Context
+	ctx    context.Context
+	cancel context.CancelFunc
+	sem    chan struct{}
+	wg     sync.WaitGroup
+
+	mu    sync.Mutex
+	first error // the failure that cancelled a fail-fast run
+	errs  *apperrors.MultiError
+
+	// State of the current walk, only used by the walking goroutine
+	fsys    FS
+	policy  SymlinkPolicy
+	pending []pendingDir
+	seen    map[string]bool // relative paths visited, if not nil
+}
+
+// newTreeRunner checks opts and prepares a run
+func newTreeRunner(ctx context.Context, opts TreeOptions) (*treeRunner, error) {
+	for _, pattern := range append(append([]string{}, opts.Include...), opts.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.Wrapf(err, "invalid pattern %q", pattern)
+		}
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = DefaultTreeWorkers
+	}
+
+	r := &treeRunner{
+		opts:   opts,
+		parent: ctx,
+		sem:    make(chan struct{}, workers),
+		errs:   apperrors.NewMultiError(),
+	}
+	r.ctx, r.cancel = context.WithCancel(ctx)
+	return r, nil
+}
+
+// walk applies op to the tree under root in fsys, following links as
+// policy says. It returns once every file has been handled and every
+// dirDone has run.
+func (r *treeRunner) walk(fsys FS, root string, op treeOp, policy SymlinkPolicy) {
+	info, err := fsys.Stat(root)
+	if err != nil {
+		r.fail("stat", root, err)
+		return
+	}
+	if !info.IsDir() {
+		r.fail(op.name, root, errors.New("not a directory"))
+		return
+	}
+
+	real, err := fsys.EvalSymlinks(root)
+	if err != nil {
+		r.fail("stat", root, err)
+		return
+	}
+
+	r.fsys = fsys
+	r.policy = policy
+	r.pending = nil
+	r.enterDir(root, "", info, []string{real}, op)
+	r.wg.Wait()
+
+	// A cancelled run leaves the directories as they are
+	if r.ctx.Err() != nil || op.dirDone == nil {
+		return
+	}
+	// Directories are queued after their subdirectories
+	for _, dir := range r.pending {
+		if err := op.dirDone(dir.rel, dir.path, dir.info); err != nil {
+			r.fail(op.name, dir.path, err)
+		}
+	}
+}
+
+// enterDir runs op.dir for a directory, walks it and queues its dirDone.
+// ancestors are the real paths of the directories being walked, dir's own
+// included.
+func (r *treeRunner) enterDir(dir, rel string, info fs.FileInfo, ancestors []string, op treeOp) {
+	if r.seen != nil {
+		r.seen[rel] = true
+	}
+	if op.dir != nil {
+		if err := op.dir(rel, dir, info); err != nil {
+			if err != fs.SkipDir {
+				r.fail(op.name, dir, err)
+			}
+			return
+		}
+	}
+
+	r.walkDir(dir, rel, ancestors, op)
+	r.pending = append(r.pending, pendingDir{rel: rel, path: dir, info: info})
+}
+
+// walkDir visits the entries of dir
+func (r *treeRunner) walkDir(dir, rel string, ancestors []string, op treeOp) {
+	entries, err := r.fsys.ReadDir(dir)
+	if err != nil {
+		r.fail("readdir", dir, err)
+		return
+	}
+
+	for _, entry := range entries {
+		if r.ctx.Err() != nil {
+			return
+		}
+
+		childRel := path.Join(rel, entry.Name())
+		childPath := filepath.Join(dir, entry.Name())
+		if r.matches(r.opts.Exclude, childRel) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			r.fail("stat", childPath, err)
+			continue
+		}
+		real := filepath.Join(ancestors[len(ancestors)-1], entry.Name())
+
+		if info.Mode()&fs.ModeSymlink != 0 {
+			switch r.policy {
+			case SymlinkSkip:
+				continue
+			case SymlinkFollow:
+				if info, err = r.fsys.Stat(childPath); err != nil {
+					r.fail("stat", childPath, err)
+					continue
+				}
+				if real, err = r.fsys.EvalSymlinks(childPath); err != nil {
+					r.fail("stat", childPath, err)
+					continue
+				}
+				if info.IsDir() && contains(ancestors, real) {
+					r.fail(op.name, childPath, ErrSymlinkLoop)
+					continue
+				}
+			}
+		}
+
+		if info.IsDir() {
+			r.enterDir(childPath, childRel, info, append(ancestors[:len(ancestors):len(ancestors)], real), op)
+			continue
+		}
+		if len(r.opts.Include) > 0 && !r.matches(r.opts.Include, childRel) {
+			continue
+		}
+		if r.seen != nil {
+			r.seen[childRel] = true
+		}
+		if op.file != nil {
+			r.do(op, childRel, childPath, info)
+		}
+	}
+}
+
+// do runs op.file on a worker, waiting for one to be free
+func (r *treeRunner) do(op treeOp, rel, path string, info fs.FileInfo) {
+	select {
+	case r.sem <- struct{}{}:
+	case <-r.ctx.Done():
+		return
+	}
+	// Both may have been ready; a cancelled run starts no more work
+	if r.ctx.Err() != nil {
+		<-r.sem
+		return
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer func() { <-r.sem }()
+
+		if err := op.file(r.ctx, rel, path, info); err != nil {
+			r.fail(op.name, path, err)
+		}
+	}()
+}
+
+// fail records a failure. Without ContinueOnError the first one cancels
+// the run.
+func (r *treeRunner) fail(op, path string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Once cancelled, work stops with context errors that aren't worth
+	// listing one by one
+	if ctxErr := r.ctx.Err(); ctxErr != nil && errors.Is(err, ctxErr) {
+		return
+	}
+
+	fileErr := apperrors.NewFileError(op, path, err)
+	if r.opts.ContinueOnError {
+		r.errs.Add(fileErr)
+		return
+	}
+	if r.first == nil {
+		r.first = fileErr
+		r.cancel()
+	}
+}
+
+// result returns the outcome of the run and releases its context
+func (r *treeRunner) result() error {
+	defer r.cancel()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.first != nil {
+		return r.first
+	}
+	if err := r.parent.Err(); err != nil {
+		cancelled := errors.Wrap(err, "tree operation cancelled")
+		if !r.errs.HasErrors() {
+			return cancelled
+		}
+		r.errs.Add(cancelled)
+	}
+	if r.errs.HasErrors() {
+		return r.errs
+	}
+	return nil
+}
+
+// matches reports whether rel or its base name matches one of patterns
+func (r *treeRunner) matches(patterns []string, rel string) bool {
+	base := path.Base(rel)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// contains reports whether list holds s
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
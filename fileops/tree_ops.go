@@ -0,0 +1,242 @@
+package fileops
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// CopyTree copies the directory src to dst, creating dst if needed. Files
+// are copied with CopyFile using opts.Copy, directories get the mode of
+// their source once they are filled.
+func CopyTree(ctx context.Context, src, dst string, opts TreeOptions) error {
+	if err := checkNotNested(src, dst, opts); err != nil {
+		return err
+	}
+
+	r, err := newTreeRunner(ctx, opts)
+	if err != nil {
+		return err
+	}
+	srcFS, _ := opts.fileSystems()
+	r.walk(srcFS, src, copyOp("copy", dst, opts, nil), opts.Symlinks)
+	return r.result()
+}
+
+// MoveTree moves the directory src to dst. Without filters, with
+// SymlinkPreserve and without a DstFS it is a single rename if dst doesn't
+// exist yet and is on the same file system. Otherwise each file is copied
+// and then removed from src, and source directories are removed once they
+// are empty.
+func MoveTree(ctx context.Context, src, dst string, opts TreeOptions) error {
+	if err := checkNotNested(src, dst, opts); err != nil {
+		return err
+	}
+
+	srcFS, _ := opts.fileSystems()
+	if len(opts.Include) == 0 && len(opts.Exclude) == 0 && opts.Symlinks == SymlinkPreserve && opts.DstFS == nil {
+		if _, err := srcFS.Lstat(dst); errors.Is(err, fs.ErrNotExist) && srcFS.Rename(src, dst) == nil {
+			return nil
+		}
+	}
+
+	r, err := newTreeRunner(ctx, opts)
+	if err != nil {
+		return err
+	}
+	remove := func(path string, info fs.FileInfo) error {
+		return removeSource(srcFS, path, info)
+	}
+	r.walk(srcFS, src, copyOp("move", dst, opts, remove), opts.Symlinks)
+	return r.result()
+}
+
+// RemoveTree removes the files under root matching the filters of opts,
+// then every directory left empty, root included. Links are removed
+// without touching what they point to, unless opts.Symlinks is SymlinkSkip.
+func RemoveTree(ctx context.Context, root string, opts TreeOptions) error {
+	r, err := newTreeRunner(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	policy := SymlinkPreserve
+	if opts.Symlinks == SymlinkSkip {
+		policy = SymlinkSkip
+	}
+	fsys, _ := opts.fileSystems()
+	r.walk(fsys, root, removeOp("remove", fsys, nil), policy)
+	return r.result()
+}
+
+// SyncTree makes dst a mirror of src: files missing from dst or differing
+// in size or modification time are copied, and files and directories of
+// dst that don't exist in src are removed. Entries of dst excluded by the
+// filters of opts are left alone.
+func SyncTree(ctx context.Context, src, dst string, opts TreeOptions) error {
+	if err := checkNotNested(src, dst, opts); err != nil {
+		return err
+	}
+
+	r, err := newTreeRunner(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	srcFS, dstFS := opts.fileSystems()
+	seen := make(map[string]bool)
+	r.seen = seen
+	r.walk(srcFS, src, copyOp("sync", dst, opts, nil), opts.Symlinks)
+	r.seen = nil
+
+	// Remove what the source doesn't have, never following links in dst
+	if r.ctx.Err() == nil {
+		r.walk(dstFS, dst, removeOp("sync", dstFS, seen), SymlinkPreserve)
+	}
+	return r.result()
+}
+
+// copyOp copies each entry below dst and calls after with its source once
+// it has been copied
+func copyOp(name, dst string, opts TreeOptions, after func(path string, info fs.FileInfo) error) treeOp {
+	target := func(rel string) string {
+		return filepath.Join(dst, filepath.FromSlash(rel))
+	}
+	copyOpts := opts.Copy
+	copyOpts.FS, copyOpts.DstFS = opts.fileSystems()
+	dstFS := copyOpts.DstFS
+
+	return treeOp{
+		name: name,
+		dir: func(rel, path string, info fs.FileInfo) error {
+			// With Include, directories are only created for matching files
+			if len(opts.Include) > 0 && rel != "" {
+				return nil
+			}
+			return dstFS.MkdirAll(target(rel), 0700)
+		},
+		file: func(ctx context.Context, rel, path string, info fs.FileInfo) error {
+			if name == "sync" && upToDate(dstFS, info, target(rel)) {
+				return nil
+			}
+			if err := copyEntry(ctx, path, target(rel), info, copyOpts); err != nil {
+				return err
+			}
+			if after != nil {
+				return after(path, info)
+			}
+			return nil
+		},
+		dirDone: func(rel, path string, info fs.FileInfo) error {
+			// Modes are set last, so read-only directories can be filled
+			if _, err := dstFS.Stat(target(rel)); errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			if err := dstFS.Chmod(target(rel), info.Mode().Perm()); err != nil {
+				return err
+			}
+			if after != nil {
+				return after(path, info)
+			}
+			return nil
+		},
+	}
+}
+
+// removeOp removes each entry of fsys, skipping those in keep
+func removeOp(name string, fsys FS, keep map[string]bool) treeOp {
+	return treeOp{
+		name: name,
+		file: func(ctx context.Context, rel, path string, info fs.FileInfo) error {
+			if keep[rel] {
+				return nil
+			}
+			return fsys.Remove(path)
+		},
+		dirDone: func(rel, path string, info fs.FileInfo) error {
+			if keep[rel] {
+				return nil
+			}
+			return removeSource(fsys, path, info)
+		},
+	}
+}
+
+// copyEntry copies a file or, for SymlinkPreserve, a link to target. opts
+// name both file systems.
+func copyEntry(ctx context.Context, path, target string, info fs.FileInfo, opts CopyOptions) error {
+	if err := opts.DstFS.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return err
+	}
+
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		link, err := opts.FS.Readlink(path)
+		if err != nil {
+			return err
+		}
+		if err := opts.DstFS.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return opts.DstFS.Symlink(link, target)
+	case info.Mode().IsRegular():
+		return CopyFile(ctx, path, target, opts)
+	default:
+		return errors.Errorf("unsupported file type %s", info.Mode().Type())
+	}
+}
+
+// removeSource removes a moved file, or a directory once it is empty.
+// Directories still holding entries left out by the filters are kept.
+func removeSource(fsys FS, path string, info fs.FileInfo) error {
+	err := fsys.Remove(path)
+	if err != nil && info.IsDir() && isNotEmpty(err) {
+		return nil
+	}
+	return err
+}
+
+// isNotEmpty reports whether err says a directory isn't empty
+func isNotEmpty(err error) bool {
+	return errors.Is(err, syscall.ENOTEMPTY) || errors.Is(err, syscall.EEXIST)
+}
+
+// upToDate reports whether target already has the size and modification
+// time of the source described by info
+func upToDate(fsys FS, info fs.FileInfo, target string) bool {
+	targetInfo, err := fsys.Lstat(target)
+	if err != nil || targetInfo.Mode().Type() != info.Mode().Type() {
+		return false
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		// Links are cheap to recreate
+		return false
+	}
+	return targetInfo.Size() == info.Size() && targetInfo.ModTime().Equal(info.ModTime())
+}
+
+// checkNotNested refuses to copy a tree into itself, which would never end.
+// Trees on different file systems can't be nested.
+func checkNotNested(src, dst string, opts TreeOptions) error {
+	if opts.DstFS != nil {
+		return nil
+	}
+
+	absSrc, err := filepath.Abs(src)
+	if err != nil {
+		return errors.Wrap(err, "failed to resolve source")
+	}
+	absDst, err := filepath.Abs(dst)
+	if err != nil {
+		return errors.Wrap(err, "failed to resolve destination")
+	}
+
+	if absDst == absSrc || strings.HasPrefix(absDst, absSrc+string(filepath.Separator)) {
+		return errors.Errorf("destination %s is inside source %s", dst, src)
+	}
+	return nil
+}
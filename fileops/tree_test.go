@@ -0,0 +1,266 @@
+package fileops
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	apperrors "error-handling-demo/errors"
+	"error-handling-demo/faults"
+)
+
+// makeTree creates files below root; names ending in "/" are directories
+// and "name -> target" creates a symlink
+func makeTree(t *testing.T, root string, entries ...string) {
+	t.Helper()
+
+	for _, entry := range entries {
+		var err error
+		switch {
+		case strings.Contains(entry, " -> "):
+			parts := strings.SplitN(entry, " -> ", 2)
+			err = os.Symlink(parts[1], filepath.Join(root, parts[0]))
+		case strings.HasSuffix(entry, "/"):
+			err = os.MkdirAll(filepath.Join(root, entry), 0755)
+		default:
+			path := filepath.Join(root, entry)
+			if err = os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+				err = os.WriteFile(path, []byte("content of "+entry), 0644)
+			}
+		}
+		if err != nil {
+			t.Fatalf("creating %s failed: %v", entry, err)
+		}
+	}
+}
+
+// listTree returns the entries below root in makeTree notation
+func listTree(t *testing.T, root string) []string {
+	t.Helper()
+
+	var entries []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == root {
+			return err
+		}
+		rel := filepath.ToSlash(strings.TrimPrefix(path, root+string(filepath.Separator)))
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			link, _ := os.Readlink(path)
+			entries = append(entries, rel+" -> "+link)
+		case d.IsDir():
+			entries = append(entries, rel+"/")
+		default:
+			entries = append(entries, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("listing %s failed: %v", root, err)
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+// expectTree fails the test unless root holds exactly want
+func expectTree(t *testing.T, root string, want ...string) {
+	t.Helper()
+
+	sort.Strings(want)
+	if got := listTree(t, root); !reflect.DeepEqual(got, want) {
+		t.Fatalf("%s holds\n  %v\nwant\n  %v", filepath.Base(root), got, want)
+	}
+}
+
+func TestCopyTree(t *testing.T) {
+	checkGoroutineLeaks(t)
+	src, dst := filepath.Join(t.TempDir(), "src"), filepath.Join(t.TempDir(), "dst")
+	makeTree(t, src, "a.txt", "docs/b.md", "docs/deep/c.md", "empty/", "link -> a.txt")
+
+	if err := CopyTree(context.Background(), src, dst, TreeOptions{Workers: 2}); err != nil {
+		t.Fatalf("CopyTree failed: %v", err)
+	}
+	expectTree(t, dst, "a.txt", "docs/", "docs/b.md", "docs/deep/", "docs/deep/c.md", "empty/", "link -> a.txt")
+
+	if got := readFile(t, filepath.Join(dst, "docs/deep/c.md")); got != "content of docs/deep/c.md" {
+		t.Errorf("c.md = %q", got)
+	}
+	if info, err := os.Stat(filepath.Join(dst, "docs")); err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("docs has mode %v (err %v), want 0755", info.Mode().Perm(), err)
+	}
+}
+
+func TestCopyTreeFilters(t *testing.T) {
+	src, dst := filepath.Join(t.TempDir(), "src"), filepath.Join(t.TempDir(), "dst")
+	makeTree(t, src, "a.go", "a.tmp", "cmd/main.go", "cmd/notes.txt", "vendor/lib.go", "docs/readme.md")
+
+	opts := TreeOptions{Include: []string{"*.go", "docs/*"}, Exclude: []string{"vendor", "*.tmp"}}
+	if err := CopyTree(context.Background(), src, dst, opts); err != nil {
+		t.Fatalf("CopyTree failed: %v", err)
+	}
+	expectTree(t, dst, "a.go", "cmd/", "cmd/main.go", "docs/", "docs/readme.md")
+
+	if err := CopyTree(context.Background(), src, dst, TreeOptions{Include: []string{"[a-"}}); err == nil {
+		t.Error("CopyTree accepted an invalid pattern")
+	}
+}
+
+func TestCopyTreeSymlinkPolicies(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	outside := t.TempDir()
+	makeTree(t, outside, "shared/s.txt")
+	makeTree(t, src, "a.txt", "file-link -> a.txt", "dir-link -> "+filepath.Join(outside, "shared"))
+
+	skipped := filepath.Join(t.TempDir(), "skip")
+	if err := CopyTree(context.Background(), src, skipped, TreeOptions{Symlinks: SymlinkSkip}); err != nil {
+		t.Fatalf("CopyTree(SymlinkSkip) failed: %v", err)
+	}
+	expectTree(t, skipped, "a.txt")
+
+	followed := filepath.Join(t.TempDir(), "follow")
+	if err := CopyTree(context.Background(), src, followed, TreeOptions{Symlinks: SymlinkFollow}); err != nil {
+		t.Fatalf("CopyTree(SymlinkFollow) failed: %v", err)
+	}
+	expectTree(t, followed, "a.txt", "file-link", "dir-link/", "dir-link/s.txt")
+}
+
+func TestCopyTreeDetectsSymlinkLoops(t *testing.T) {
+	src, dst := filepath.Join(t.TempDir(), "src"), filepath.Join(t.TempDir(), "dst")
+	makeTree(t, src, "a.txt", "sub/b.txt", "sub/up -> ..")
+
+	err := CopyTree(context.Background(), src, dst, TreeOptions{Symlinks: SymlinkFollow, ContinueOnError: true})
+	if !errors.Is(err, ErrSymlinkLoop) {
+		t.Fatalf("CopyTree returned %v, want ErrSymlinkLoop", err)
+	}
+	expectTree(t, dst, "a.txt", "sub/", "sub/b.txt")
+}
+
+func TestTreeContinueOnErrorCollectsFailures(t *testing.T) {
+	checkGoroutineLeaks(t)
+	src, dst := filepath.Join(t.TempDir(), "src"), filepath.Join(t.TempDir(), "dst")
+	makeTree(t, src, "a", "b", "c", "d/e")
+
+	injector := faults.NewInjector(1, faults.Rule{Op: "fileops.copy", Probability: 1, Err: faults.ErrInjected})
+	faults.Enable(injector)
+	defer faults.Disable()
+
+	err := CopyTree(context.Background(), src, dst, TreeOptions{Workers: 2, ContinueOnError: true})
+
+	var multi *apperrors.MultiError
+	if !errors.As(err, &multi) || len(multi.Errors) != 4 {
+		t.Fatalf("CopyTree returned %v, want a MultiError with 4 errors", err)
+	}
+	var failed []string
+	for _, e := range multi.Errors {
+		var fileErr *apperrors.FileError
+		if !errors.As(e, &fileErr) || fileErr.Op != "copy" {
+			t.Fatalf("error %v is not a FileError for copy", e)
+		}
+		rel, _ := filepath.Rel(src, fileErr.Path)
+		failed = append(failed, filepath.ToSlash(rel))
+	}
+	sort.Strings(failed)
+	if want := []string{"a", "b", "c", "d/e"}; !reflect.DeepEqual(failed, want) {
+		t.Errorf("failed paths = %v, want %v", failed, want)
+	}
+	if !errors.Is(err, faults.ErrInjected) {
+		t.Error("errors.Is does not find the injected error in the MultiError")
+	}
+}
+
+func TestTreeFailFastCancelsRemainingWork(t *testing.T) {
+	checkGoroutineLeaks(t)
+	src, dst := filepath.Join(t.TempDir(), "src"), filepath.Join(t.TempDir(), "dst")
+	makeTree(t, src, "a", "b", "c", "d", "e", "f")
+
+	injector := faults.NewInjector(1, faults.Rule{Op: "fileops.copy", Probability: 1, Err: faults.ErrInjected})
+	faults.Enable(injector)
+	defer faults.Disable()
+
+	err := CopyTree(context.Background(), src, dst, TreeOptions{Workers: 1})
+
+	var fileErr *apperrors.FileError
+	if !errors.As(err, &fileErr) || !errors.Is(err, faults.ErrInjected) {
+		t.Fatalf("CopyTree returned %v, want a single FileError", err)
+	}
+	if n := injector.Injected()["fileops.copy"]; n != 1 {
+		t.Errorf("%d copies were attempted after the first failure, want none", n-1)
+	}
+}
+
+func TestMoveTree(t *testing.T) {
+	src, dst := filepath.Join(t.TempDir(), "src"), filepath.Join(t.TempDir(), "dst")
+	makeTree(t, src, "keep.tmp", "a.txt", "sub/b.txt", "sub/c.tmp")
+
+	// Filters force a file by file move
+	if err := MoveTree(context.Background(), src, dst, TreeOptions{Exclude: []string{"*.tmp"}}); err != nil {
+		t.Fatalf("MoveTree failed: %v", err)
+	}
+	expectTree(t, dst, "a.txt", "sub/", "sub/b.txt")
+	expectTree(t, src, "keep.tmp", "sub/", "sub/c.tmp")
+
+	// Without filters the whole tree is renamed
+	moved := filepath.Join(t.TempDir(), "moved")
+	if err := MoveTree(context.Background(), dst, moved, TreeOptions{}); err != nil {
+		t.Fatalf("MoveTree failed: %v", err)
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("source still exists after MoveTree (stat error %v)", err)
+	}
+	expectTree(t, moved, "a.txt", "sub/", "sub/b.txt")
+
+	if err := MoveTree(context.Background(), moved, filepath.Join(moved, "inner"), TreeOptions{}); err == nil {
+		t.Error("MoveTree into its own subdirectory succeeded")
+	}
+}
+
+func TestRemoveTree(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "root")
+	outside := t.TempDir()
+	makeTree(t, outside, "target.txt")
+	makeTree(t, root, "a.log", "b.txt", "logs/c.log", "logs/old/d.log", "link -> "+filepath.Join(outside, "target.txt"))
+
+	if err := RemoveTree(context.Background(), root, TreeOptions{Include: []string{"*.log"}}); err != nil {
+		t.Fatalf("RemoveTree(*.log) failed: %v", err)
+	}
+	expectTree(t, root, "b.txt", "link -> "+filepath.Join(outside, "target.txt"))
+
+	if err := RemoveTree(context.Background(), root, TreeOptions{}); err != nil {
+		t.Fatalf("RemoveTree failed: %v", err)
+	}
+	if _, err := os.Stat(root); !os.IsNotExist(err) {
+		t.Errorf("root still exists (stat error %v)", err)
+	}
+	expectTree(t, outside, "target.txt")
+}
+
+func TestSyncTree(t *testing.T) {
+	src, dst := filepath.Join(t.TempDir(), "src"), filepath.Join(t.TempDir(), "dst")
+	makeTree(t, src, "a.txt", "sub/b.txt")
+	makeTree(t, dst, "a.txt", "stale.txt", "gone/x.txt", "local.cache")
+
+	opts := TreeOptions{Exclude: []string{"*.cache"}}
+	if err := SyncTree(context.Background(), src, dst, opts); err != nil {
+		t.Fatalf("SyncTree failed: %v", err)
+	}
+	expectTree(t, dst, "a.txt", "sub/", "sub/b.txt", "local.cache")
+
+	// Unchanged files are not copied again
+	injector := faults.NewInjector(1, faults.Rule{Op: "fileops.copy", Probability: 1, Err: faults.ErrInjected})
+	faults.Enable(injector)
+	defer faults.Disable()
+
+	if err := SyncTree(context.Background(), src, dst, opts); err != nil {
+		t.Fatalf("second SyncTree failed: %v", err)
+	}
+	if n := injector.Injected()["fileops.copy"]; n != 0 {
+		t.Errorf("second SyncTree copied %d unchanged files", n)
+	}
+}
@@ -0,0 +1,160 @@
+package fileops
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FS is a file system the fileops functions can read and write. It extends
+// io/fs with the write operations they need, so the same copy, write and
+// tree logic runs against the disk (OSFS), memory (MemFS), or anything
+// wrapped by ReadOnly or Faulty.
+//
+// Names are paths as the implementation understands them: OSFS takes any
+// OS path, while MemFS and io/fs based file systems take io/fs paths such
+// as "data/users.json". Errors are *fs.PathError or *os.LinkError values
+// matching the fs.Err* sentinels, as package os returns them.
+type FS interface {
+	fs.FS
+	fs.StatFS
+	fs.ReadDirFS
+
+	// Lstat is Stat without following a final symlink
+	Lstat(name string) (fs.FileInfo, error)
+	// OpenFile opens name with os.O_* flags, creating it with perm if
+	// os.O_CREATE is given
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+	// CreateTemp creates a new file in dir as os.CreateTemp does
+	CreateTemp(dir, pattern string) (File, error)
+	MkdirAll(name string, perm fs.FileMode) error
+	// Remove removes a file, a link or an empty directory
+	Remove(name string) error
+	Rename(oldname, newname string) error
+	Chmod(name string, mode fs.FileMode) error
+	Chtimes(name string, atime, mtime time.Time) error
+	Symlink(oldname, newname string) error
+	Readlink(name string) (string, error)
+	// EvalSymlinks returns name with every symlink in it resolved
+	EvalSymlinks(name string) (string, error)
+}
+
+// File is an open file of an FS. *os.File implements it.
+type File interface {
+	fs.File
+	io.Writer
+	io.Seeker
+	// Name returns the name the file was opened or created with
+	Name() string
+	Sync() error
+}
+
+// OSFS is the FS of the operating system, a thin layer over package os
+type OSFS struct{}
+
+// Open implements fs.FS
+func (OSFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+// Stat implements fs.StatFS
+func (OSFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// ReadDir implements fs.ReadDirFS
+func (OSFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+// Lstat implements FS
+func (OSFS) Lstat(name string) (fs.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+// OpenFile implements FS
+func (OSFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// CreateTemp implements FS
+func (OSFS) CreateTemp(dir, pattern string) (File, error) {
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// MkdirAll implements FS
+func (OSFS) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+// Remove implements FS
+func (OSFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// Rename implements FS
+func (OSFS) Rename(oldname, newname string) error {
+	return os.Rename(oldname, newname)
+}
+
+// Chmod implements FS
+func (OSFS) Chmod(name string, mode fs.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+// Chtimes implements FS
+func (OSFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+// Symlink implements FS
+func (OSFS) Symlink(oldname, newname string) error {
+	return os.Symlink(oldname, newname)
+}
+
+// Readlink implements FS
+func (OSFS) Readlink(name string) (string, error) {
+	return os.Readlink(name)
+}
+
+// EvalSymlinks implements FS
+func (OSFS) EvalSymlinks(name string) (string, error) {
+	return filepath.EvalSymlinks(name)
+}
+
+// syncDir flushes the directory entry changes of dir, such as a rename
+func (OSFS) syncDir(dir string) error {
+	return syncDir(dir)
+}
+
+// dirSyncer is implemented by file systems whose directories can be synced
+type dirSyncer interface {
+	syncDir(dir string) error
+}
+
+// orOS returns fsys, or OSFS if it is nil
+func orOS(fsys FS) FS {
+	if fsys == nil {
+		return OSFS{}
+	}
+	return fsys
+}
+
+// sameFile reports whether a and b describe the same file
+func sameFile(a, b fs.FileInfo) bool {
+	if nodeA, ok := a.Sys().(*memNode); ok {
+		nodeB, _ := b.Sys().(*memNode)
+		return nodeA == nodeB
+	}
+	return os.SameFile(a, b)
+}
@@ -0,0 +1,293 @@
+package fileops
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/pkg/errors"
+
+	apperrors "error-handling-demo/errors"
+	"error-handling-demo/faults"
+)
+
+// memTree fills a MemFS like makeTree fills a directory
+func memTree(t *testing.T, fsys *MemFS, entries ...string) {
+	t.Helper()
+
+	for _, entry := range entries {
+		var err error
+		switch {
+		case strings.Contains(entry, " -> "):
+			parts := strings.SplitN(entry, " -> ", 2)
+			err = fsys.Symlink(parts[1], parts[0])
+		case strings.HasSuffix(entry, "/"):
+			err = fsys.MkdirAll(strings.TrimSuffix(entry, "/"), 0755)
+		default:
+			if dir := entry[:strings.LastIndex(entry, "/")+1]; dir != "" {
+				err = fsys.MkdirAll(strings.TrimSuffix(dir, "/"), 0755)
+			}
+			if err == nil {
+				err = WriteFileAtomicFS(fsys, entry, []byte("content of "+entry), 0644)
+			}
+		}
+		if err != nil {
+			t.Fatalf("creating %s failed: %v", entry, err)
+		}
+	}
+}
+
+// memList returns the entries below root in makeTree notation
+func memList(t *testing.T, fsys FS, root string) []string {
+	t.Helper()
+
+	var entries []string
+	err := fs.WalkDir(fsys, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || name == root {
+			return err
+		}
+		rel := strings.TrimPrefix(name, root+"/")
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			link, _ := fsys.Readlink(name)
+			entries = append(entries, rel+" -> "+link)
+		case d.IsDir():
+			entries = append(entries, rel+"/")
+		default:
+			entries = append(entries, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("listing %s failed: %v", root, err)
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+// readMemFile returns the content of name
+func readMemFile(t *testing.T, fsys fs.FS, name string) string {
+	t.Helper()
+
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		t.Fatalf("reading %s failed: %v", name, err)
+	}
+	return string(data)
+}
+
+func TestMemFSIsAnIOFS(t *testing.T) {
+	fsys := NewMemFS()
+	memTree(t, fsys, "a.txt", "docs/b.md", "docs/deep/c.md", "empty/")
+
+	if err := fstest.TestFS(fsys, "a.txt", "docs/b.md", "docs/deep/c.md", "empty"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemFSFileSemantics(t *testing.T) {
+	fsys := NewMemFS()
+	memTree(t, fsys, "dir/a.txt", "dir/b.txt", "other/")
+
+	if _, err := fsys.OpenFile("dir/a.txt", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("exclusive create of an existing file returned %v", err)
+	}
+	if _, err := fsys.Open("missing/a.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open of a missing file returned %v", err)
+	}
+	if _, err := fsys.Open("/dir/a.txt"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Open of an invalid path returned %v", err)
+	}
+	if err := fsys.Remove("dir"); !errors.Is(err, syscall.ENOTEMPTY) {
+		t.Errorf("Remove of a full directory returned %v", err)
+	}
+
+	// Rename replaces files, but only empty directories
+	if err := fsys.Rename("dir/a.txt", "dir/b.txt"); err != nil {
+		t.Fatalf("Rename over a file failed: %v", err)
+	}
+	if got := readMemFile(t, fsys, "dir/b.txt"); got != "content of dir/a.txt" {
+		t.Errorf("renamed file holds %q", got)
+	}
+	if err := fsys.Rename("other", "dir"); !errors.Is(err, syscall.ENOTEMPTY) {
+		t.Errorf("Rename over a full directory returned %v", err)
+	}
+	if err := fsys.Rename("dir", "dir/sub"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Rename into itself returned %v", err)
+	}
+
+	// Appends land at the end whatever the offset
+	f, err := fsys.OpenFile("dir/b.txt", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("OpenFile for appending failed: %v", err)
+	}
+	f.Seek(0, io.SeekStart)
+	f.Write([]byte("!"))
+	f.Close()
+	if _, err := f.Write([]byte("?")); !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("Write after Close returned %v", err)
+	}
+	if got := readMemFile(t, fsys, "dir/b.txt"); got != "content of dir/a.txt!" {
+		t.Errorf("appended file holds %q", got)
+	}
+}
+
+func TestMemFSSymlinks(t *testing.T) {
+	fsys := NewMemFS()
+	memTree(t, fsys, "data/v1/config.json", "data/current -> v1", "abs -> /data/current", "loop -> loop")
+
+	if got := readMemFile(t, fsys, "abs/config.json"); got != "content of data/v1/config.json" {
+		t.Errorf("reading through links gave %q", got)
+	}
+	if real, err := fsys.EvalSymlinks("abs/config.json"); err != nil || real != "data/v1/config.json" {
+		t.Errorf("EvalSymlinks = %q, %v", real, err)
+	}
+	if info, err := fsys.Lstat("data/current"); err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		t.Errorf("Lstat of a link = %v, %v", info, err)
+	}
+	if _, err := fsys.Stat("loop"); !errors.Is(err, syscall.ELOOP) {
+		t.Errorf("Stat of a link to itself returned %v", err)
+	}
+}
+
+func TestWriteFileAtomicFS(t *testing.T) {
+	fsys := NewMemFS()
+	memTree(t, fsys, "conf/app.json", "conf/link.json -> app.json")
+	if err := fsys.Chmod("conf/app.json", 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteFileAtomicFS(fsys, "conf/link.json", []byte("new"), 0644); err != nil {
+		t.Fatalf("WriteFileAtomicFS failed: %v", err)
+	}
+	if got := readMemFile(t, fsys, "conf/app.json"); got != "new" {
+		t.Errorf("link target holds %q, want the new content", got)
+	}
+	if info, _ := fsys.Stat("conf/app.json"); info.Mode().Perm() != 0600 {
+		t.Errorf("rewritten file has mode %v, want 0600", info.Mode().Perm())
+	}
+	if got, want := memList(t, fsys, "conf"), []string{"app.json", "link.json -> app.json"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("conf holds %v, want %v", got, want)
+	}
+}
+
+func TestCopyFileAcrossFileSystems(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	archive := fstest.MapFS{
+		"report.csv": {Data: []byte(strings.Repeat("a,b\n", 20000)), Mode: 0640, ModTime: modTime},
+	}
+	dst := NewMemFS()
+
+	opts := CopyOptions{FS: ReadOnly(archive), DstFS: dst, Verify: true}
+	if err := CopyFile(context.Background(), "report.csv", "report.csv", opts); err != nil {
+		t.Fatalf("CopyFile failed: %v", err)
+	}
+	info, err := dst.Stat("report.csv")
+	if err != nil || info.Size() != 80000 || info.Mode().Perm() != 0640 || !info.ModTime().Equal(modTime) {
+		t.Fatalf("copy has info %v (err %v)", info, err)
+	}
+
+	// Resume a partial copy within the same MemFS
+	if err := WriteFileAtomicFS(dst, "partial.csv", []byte("a,b\na,"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts = CopyOptions{FS: dst, Resume: true, Verify: true}
+	if err := CopyFile(context.Background(), "report.csv", "partial.csv", opts); err != nil {
+		t.Fatalf("resumed CopyFile failed: %v", err)
+	}
+	if readMemFile(t, dst, "partial.csv") != string(archive["report.csv"].Data) {
+		t.Error("resumed copy differs from the source")
+	}
+
+	err = CopyFile(context.Background(), "report.csv", "report.csv", CopyOptions{FS: dst})
+	if err == nil || !strings.Contains(err.Error(), "same file") {
+		t.Errorf("copying a file onto itself returned %v", err)
+	}
+}
+
+func TestTreeOperationsInMemory(t *testing.T) {
+	fsys := NewMemFS()
+	memTree(t, fsys, "src/a.txt", "src/docs/b.md", "src/link -> a.txt", "dst/stale.txt")
+
+	if err := SyncTree(context.Background(), "src", "dst", TreeOptions{FS: fsys}); err != nil {
+		t.Fatalf("SyncTree failed: %v", err)
+	}
+	want := []string{"a.txt", "docs/", "docs/b.md", "link -> a.txt"}
+	if got := memList(t, fsys, "dst"); !reflect.DeepEqual(got, want) {
+		t.Errorf("dst holds %v, want %v", got, want)
+	}
+
+	if err := MoveTree(context.Background(), "dst", "moved", TreeOptions{FS: fsys}); err != nil {
+		t.Fatalf("MoveTree failed: %v", err)
+	}
+	if got := memList(t, fsys, "moved"); !reflect.DeepEqual(got, want) {
+		t.Errorf("moved holds %v, want %v", got, want)
+	}
+
+	// Into a second MemFS, which has to copy file by file
+	other := NewMemFS()
+	if err := MoveTree(context.Background(), "moved", "copy", TreeOptions{FS: fsys, DstFS: other}); err != nil {
+		t.Fatalf("MoveTree across file systems failed: %v", err)
+	}
+	if got := memList(t, other, "copy"); !reflect.DeepEqual(got, want) {
+		t.Errorf("copy holds %v, want %v", got, want)
+	}
+	if _, err := fsys.Stat("moved"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("moved still exists (stat error %v)", err)
+	}
+
+	if err := RemoveTree(context.Background(), "copy", TreeOptions{FS: other}); err != nil {
+		t.Fatalf("RemoveTree failed: %v", err)
+	}
+	if entries, _ := other.ReadDir("."); len(entries) != 0 {
+		t.Errorf("RemoveTree left %v", entries)
+	}
+}
+
+func TestReadOnlyFS(t *testing.T) {
+	fsys := ReadOnly(fstest.MapFS{"a.txt": {Data: []byte("a")}})
+
+	if got := readMemFile(t, fsys, "a.txt"); got != "a" {
+		t.Errorf("a.txt holds %q", got)
+	}
+
+	err := WriteFileAtomicFS(fsys, "a.txt", []byte("b"), 0644)
+	var fileErr *apperrors.FileError
+	if !errors.As(err, &fileErr) || fileErr.Kind != apperrors.KindReadOnly {
+		t.Errorf("writing to a read-only FS returned %v, want a FileError of kind read_only", err)
+	}
+	if err := fsys.Remove("a.txt"); !errors.Is(err, syscall.EROFS) {
+		t.Errorf("Remove returned %v", err)
+	}
+}
+
+func TestFaultyFSLeavesTargetIntact(t *testing.T) {
+	mem := NewMemFS()
+	memTree(t, mem, "data.json")
+
+	injector := faults.NewInjector(1, faults.Rule{Op: "fileops.fs.write", Probability: 1, Err: syscall.ENOSPC})
+	fsys := Faulty(mem, injector)
+
+	err := WriteFileAtomicFS(fsys, "data.json", []byte("new content"), 0644)
+	var fileErr *apperrors.FileError
+	if !errors.As(err, &fileErr) || fileErr.Kind != apperrors.KindNoSpace || !errors.Is(err, faults.ErrInjected) {
+		t.Fatalf("WriteFileAtomicFS returned %v, want an injected FileError of kind no_space", err)
+	}
+	if got := readMemFile(t, mem, "data.json"); got != "content of data.json" {
+		t.Errorf("target holds %q after the failed write", got)
+	}
+	if got := memList(t, mem, "."); !reflect.DeepEqual(got, []string{"data.json"}) {
+		t.Errorf("the temporary file was left behind: %v", got)
+	}
+	if n := injector.Injected()["fileops.fs.write"]; n != 1 {
+		t.Errorf("%d writes failed, want 1", n)
+	}
+}
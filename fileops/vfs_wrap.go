@@ -0,0 +1,367 @@
+package fileops
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"syscall"
+	"time"
+
+	"error-handling-demo/faults"
+)
+
+// readOnlyFS serves the read operations of an io/fs file system
+type readOnlyFS struct {
+	fsys fs.FS
+}
+
+// ReadOnly returns an FS that reads from fsys and fails every write with
+// syscall.EROFS, which errors.ClassifyFileError reports as KindReadOnly.
+// fsys may be any io/fs file system, such as an archive opened with
+// archive/zip or os.DirFS; Lstat, Readlink and EvalSymlinks are served by
+// fsys if it has them, otherwise it is treated as having no symlinks.
+func ReadOnly(fsys fs.FS) FS {
+	return readOnlyFS{fsys: fsys}
+}
+
+// readOnly is the error of a write to a read-only FS
+func readOnly(op, name string) error {
+	return &fs.PathError{Op: op, Path: name, Err: syscall.EROFS}
+}
+
+// Open implements fs.FS
+func (r readOnlyFS) Open(name string) (fs.File, error) {
+	return r.fsys.Open(name)
+}
+
+// Stat implements fs.StatFS
+func (r readOnlyFS) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(r.fsys, name)
+}
+
+// ReadDir implements fs.ReadDirFS
+func (r readOnlyFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(r.fsys, name)
+}
+
+// Lstat implements FS
+func (r readOnlyFS) Lstat(name string) (fs.FileInfo, error) {
+	if fsys, ok := r.fsys.(interface {
+		Lstat(name string) (fs.FileInfo, error)
+	}); ok {
+		return fsys.Lstat(name)
+	}
+	return fs.Stat(r.fsys, name)
+}
+
+// OpenFile implements FS, only allowing reads
+func (r readOnlyFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, readOnly("open", name)
+	}
+	f, err := r.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &readOnlyFile{File: f, name: name}, nil
+}
+
+// CreateTemp implements FS
+func (r readOnlyFS) CreateTemp(dir, pattern string) (File, error) {
+	return nil, readOnly("createtemp", path.Join(dir, pattern))
+}
+
+// MkdirAll implements FS
+func (r readOnlyFS) MkdirAll(name string, perm fs.FileMode) error {
+	return readOnly("mkdir", name)
+}
+
+// Remove implements FS
+func (r readOnlyFS) Remove(name string) error {
+	return readOnly("remove", name)
+}
+
+// Rename implements FS
+func (r readOnlyFS) Rename(oldname, newname string) error {
+	return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EROFS}
+}
+
+// Chmod implements FS
+func (r readOnlyFS) Chmod(name string, mode fs.FileMode) error {
+	return readOnly("chmod", name)
+}
+
+// Chtimes implements FS
+func (r readOnlyFS) Chtimes(name string, atime, mtime time.Time) error {
+	return readOnly("chtimes", name)
+}
+
+// Symlink implements FS
+func (r readOnlyFS) Symlink(oldname, newname string) error {
+	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: syscall.EROFS}
+}
+
+// Readlink implements FS
+func (r readOnlyFS) Readlink(name string) (string, error) {
+	if fsys, ok := r.fsys.(interface {
+		Readlink(name string) (string, error)
+	}); ok {
+		return fsys.Readlink(name)
+	}
+	if _, err := fs.Stat(r.fsys, name); err != nil {
+		return "", err
+	}
+	return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+}
+
+// EvalSymlinks implements FS
+func (r readOnlyFS) EvalSymlinks(name string) (string, error) {
+	if fsys, ok := r.fsys.(interface {
+		EvalSymlinks(name string) (string, error)
+	}); ok {
+		return fsys.EvalSymlinks(name)
+	}
+	if _, err := fs.Stat(r.fsys, name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// readOnlyFile is a file of a read-only FS
+type readOnlyFile struct {
+	fs.File
+	name string
+}
+
+// Write implements io.Writer
+func (f *readOnlyFile) Write(p []byte) (int, error) {
+	return 0, readOnly("write", f.name)
+}
+
+// Seek implements io.Seeker if the underlying file does
+func (f *readOnlyFile) Seek(offset int64, whence int) (int64, error) {
+	if seeker, ok := f.File.(io.Seeker); ok {
+		return seeker.Seek(offset, whence)
+	}
+	return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+}
+
+// ReadDir implements fs.ReadDirFile if the underlying file does
+func (f *readOnlyFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if dir, ok := f.File.(fs.ReadDirFile); ok {
+		return dir.ReadDir(n)
+	}
+	return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+}
+
+// Name implements File
+func (f *readOnlyFile) Name() string {
+	return f.name
+}
+
+// Sync implements File; there is nothing to flush
+func (f *readOnlyFile) Sync() error {
+	return nil
+}
+
+// faultyFS consults package faults before each operation
+type faultyFS struct {
+	fsys     FS
+	injector *faults.Injector
+}
+
+// Faulty returns an FS that runs every operation on fsys, but first gives
+// injector the chance to fail it, or the injector installed with
+// faults.Enable if injector is nil. Operations are named "fileops.fs.<op>",
+// e.g. "fileops.fs.rename" or "fileops.fs.write", after the Op of the
+// *fs.PathError an injected fault is returned in.
+func Faulty(fsys FS, injector *faults.Injector) FS {
+	return &faultyFS{fsys: fsys, injector: injector}
+}
+
+// inject fails op on name if a rule says so
+func (f *faultyFS) inject(op, name string) error {
+	var err error
+	if f.injector != nil {
+		err = f.injector.Inject(context.Background(), "fileops.fs."+op)
+	} else {
+		err = faults.Inject(context.Background(), "fileops.fs."+op)
+	}
+	if err != nil {
+		return &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return nil
+}
+
+// Open implements fs.FS
+func (f *faultyFS) Open(name string) (fs.File, error) {
+	return f.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// Stat implements fs.StatFS
+func (f *faultyFS) Stat(name string) (fs.FileInfo, error) {
+	if err := f.inject("stat", name); err != nil {
+		return nil, err
+	}
+	return f.fsys.Stat(name)
+}
+
+// ReadDir implements fs.ReadDirFS
+func (f *faultyFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if err := f.inject("readdir", name); err != nil {
+		return nil, err
+	}
+	return f.fsys.ReadDir(name)
+}
+
+// Lstat implements FS
+func (f *faultyFS) Lstat(name string) (fs.FileInfo, error) {
+	if err := f.inject("lstat", name); err != nil {
+		return nil, err
+	}
+	return f.fsys.Lstat(name)
+}
+
+// OpenFile implements FS
+func (f *faultyFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	if err := f.inject("open", name); err != nil {
+		return nil, err
+	}
+	file, err := f.fsys.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyFile{File: file, fsys: f}, nil
+}
+
+// CreateTemp implements FS
+func (f *faultyFS) CreateTemp(dir, pattern string) (File, error) {
+	if err := f.inject("createtemp", path.Join(dir, pattern)); err != nil {
+		return nil, err
+	}
+	file, err := f.fsys.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyFile{File: file, fsys: f}, nil
+}
+
+// MkdirAll implements FS
+func (f *faultyFS) MkdirAll(name string, perm fs.FileMode) error {
+	if err := f.inject("mkdir", name); err != nil {
+		return err
+	}
+	return f.fsys.MkdirAll(name, perm)
+}
+
+// Remove implements FS
+func (f *faultyFS) Remove(name string) error {
+	if err := f.inject("remove", name); err != nil {
+		return err
+	}
+	return f.fsys.Remove(name)
+}
+
+// Rename implements FS
+func (f *faultyFS) Rename(oldname, newname string) error {
+	if err := f.inject("rename", oldname); err != nil {
+		return err
+	}
+	return f.fsys.Rename(oldname, newname)
+}
+
+// Chmod implements FS
+func (f *faultyFS) Chmod(name string, mode fs.FileMode) error {
+	if err := f.inject("chmod", name); err != nil {
+		return err
+	}
+	return f.fsys.Chmod(name, mode)
+}
+
+// Chtimes implements FS
+func (f *faultyFS) Chtimes(name string, atime, mtime time.Time) error {
+	if err := f.inject("chtimes", name); err != nil {
+		return err
+	}
+	return f.fsys.Chtimes(name, atime, mtime)
+}
+
+// Symlink implements FS
+func (f *faultyFS) Symlink(oldname, newname string) error {
+	if err := f.inject("symlink", newname); err != nil {
+		return err
+	}
+	return f.fsys.Symlink(oldname, newname)
+}
+
+// Readlink implements FS
+func (f *faultyFS) Readlink(name string) (string, error) {
+	if err := f.inject("readlink", name); err != nil {
+		return "", err
+	}
+	return f.fsys.Readlink(name)
+}
+
+// EvalSymlinks implements FS
+func (f *faultyFS) EvalSymlinks(name string) (string, error) {
+	if err := f.inject("lstat", name); err != nil {
+		return "", err
+	}
+	return f.fsys.EvalSymlinks(name)
+}
+
+// syncDir syncs dir if the wrapped FS can, after giving the injector a
+// chance to fail it
+func (f *faultyFS) syncDir(dir string) error {
+	syncer, ok := f.fsys.(dirSyncer)
+	if !ok {
+		return nil
+	}
+	if err := f.inject("sync", dir); err != nil {
+		return err
+	}
+	return syncer.syncDir(dir)
+}
+
+// faultyFile is a file of a faulty FS
+type faultyFile struct {
+	File
+	fsys *faultyFS
+}
+
+// Read implements io.Reader
+func (f *faultyFile) Read(p []byte) (int, error) {
+	if err := f.fsys.inject("read", f.Name()); err != nil {
+		return 0, err
+	}
+	return f.File.Read(p)
+}
+
+// Write implements io.Writer
+func (f *faultyFile) Write(p []byte) (int, error) {
+	if err := f.fsys.inject("write", f.Name()); err != nil {
+		return 0, err
+	}
+	return f.File.Write(p)
+}
+
+// Sync implements File
+func (f *faultyFile) Sync() error {
+	if err := f.fsys.inject("sync", f.Name()); err != nil {
+		return err
+	}
+	return f.File.Sync()
+}
+
+// ReadDir implements fs.ReadDirFile if the wrapped file does
+func (f *faultyFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if err := f.fsys.inject("readdir", f.Name()); err != nil {
+		return nil, err
+	}
+	if dir, ok := f.File.(fs.ReadDirFile); ok {
+		return dir.ReadDir(n)
+	}
+	return nil, &fs.PathError{Op: "readdir", Path: f.Name(), Err: syscall.ENOTDIR}
+}
@@ -0,0 +1,456 @@
+package fileops
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// EventOp is a set of changes to a watched path
+type EventOp uint8
+
+// Changes reported by Watch
+const (
+	OpCreate EventOp = 1 << iota // The path appeared, also by being renamed to
+	OpModify                     // Content or mode changed
+	OpDelete                     // The path was removed
+	OpRename                     // The path was renamed to something else
+)
+
+// String lists the changes, e.g. "create|modify"
+func (op EventOp) String() string {
+	var names []string
+	for _, known := range []struct {
+		op   EventOp
+		name string
+	}{{OpCreate, "create"}, {OpModify, "modify"}, {OpDelete, "delete"}, {OpRename, "rename"}} {
+		if op&known.op != 0 {
+			names = append(names, known.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
+// Event is a change to a watched file or an entry of a watched directory.
+// Op holds every change to Path within the debounce interval, so a file
+// created and written at once is reported as OpCreate|OpModify.
+type Event struct {
+	Path string
+	Op   EventOp
+}
+
+// Has reports whether e includes op
+func (e Event) Has(op EventOp) bool {
+	return e.Op&op != 0
+}
+
+// DefaultWatchDebounce is how long a path has to be quiet before its
+// changes are reported, if WatchOptions.Debounce is 0
+const DefaultWatchDebounce = 100 * time.Millisecond
+
+// DefaultPollInterval is how often the polling watcher looks for changes,
+// if WatchOptions.PollInterval is 0
+const DefaultPollInterval = time.Second
+
+// ErrWatchOverflow is reported when changes came in faster than they could
+// be read and some were lost; rescan what is watched to catch up
+var ErrWatchOverflow = errors.New("watch event queue overflowed")
+
+// WatchOptions configure Watch. The zero value uses the native watcher
+// with the default debounce.
+type WatchOptions struct {
+	// Debounce defaults to DefaultWatchDebounce
+	Debounce time.Duration
+	// Poll compares snapshots every PollInterval instead of using the
+	// native watcher, e.g. for network file systems that don't report
+	// changes. Watching an FS other than OSFS always polls, as does
+	// watching on systems without a native watcher. Polling reports
+	// deletions one interval late, see pollWatcher.diff.
+	Poll         bool
+	PollInterval time.Duration
+	// FS holds the watched paths, OSFS if nil
+	FS FS
+}
+
+// Watcher reports changes to files and directories until the context
+// given to Watch is done; both channels are closed then. Errors must be
+// received as well as Events, or the watcher stalls.
+type Watcher struct {
+	Events <-chan Event
+	Errors <-chan error
+	// Fallback is why the native watcher couldn't be set up, if Watch fell
+	// back to polling because of it
+	Fallback error
+
+	polling bool
+}
+
+// Polling reports whether w polls for changes instead of using the native
+// watcher, either as asked or as a fallback; see Fallback
+func (w *Watcher) Polling() bool {
+	return w.polling
+}
+
+// watchBackend detects changes and sends them unmerged
+type watchBackend interface {
+	// run sends changes and errors until ctx is done, then releases its
+	// resources and closes both channels
+	run(ctx context.Context, events chan<- Event, errs chan<- error)
+}
+
+// Watch reports changes to paths, each of which must exist. For a file,
+// its creation, modification, removal and renames are reported, including
+// it being replaced by an atomic write. For a directory, the same is
+// reported for the directory itself and its direct entries; subdirectories
+// are not watched. A watched directory that is removed is no longer
+// watched by the native watcher.
+//
+// If the native watcher can't be set up, e.g. because the system has
+// none or ran out of inotify watches, Watch polls instead and says why in
+// Watcher.Fallback.
+//
+// Changes are debounced per path: they are reported once the path has
+// been quiet for the debounce interval. Failures while watching, such as
+// ErrWatchOverflow or an *errors.FileError, are sent on Errors and
+// watching goes on.
+func Watch(ctx context.Context, paths []string, opts WatchOptions) (*Watcher, error) {
+	fsys := orOS(opts.FS)
+	for _, path := range paths {
+		if _, err := fsys.Stat(path); err != nil {
+			return nil, fileError("watch", path, err)
+		}
+	}
+
+	var backend watchBackend
+	var fallback error
+	if !opts.Poll && opts.FS == nil {
+		// Without a native watcher, fall back to polling
+		var err error
+		if backend, err = newNativeWatcher(paths); err != nil {
+			fallback = errors.Wrap(err, "native watcher unavailable, polling instead")
+		}
+	}
+	polling := backend == nil
+	if polling {
+		backend = newPollWatcher(fsys, paths, opts.PollInterval)
+	}
+
+	debounce := opts.Debounce
+	if debounce <= 0 {
+		debounce = DefaultWatchDebounce
+	}
+
+	raw := make(chan Event)
+	events := make(chan Event)
+	errs := make(chan error)
+	go backend.run(ctx, raw, errs)
+	go debounceEvents(ctx, raw, events, debounce)
+
+	return &Watcher{Events: events, Errors: errs, Fallback: fallback, polling: polling}, nil
+}
+
+// pendingEvent is a change waiting for its path to be quiet
+type pendingEvent struct {
+	op  EventOp
+	due time.Time
+}
+
+// debounceEvents merges the changes of each path from in until the path
+// has been quiet for wait, then sends them to out. It closes out once in
+// is closed or ctx is done.
+func debounceEvents(ctx context.Context, in <-chan Event, out chan<- Event, wait time.Duration) {
+	defer close(out)
+
+	pending := make(map[string]*pendingEvent)
+	timer := time.NewTimer(wait)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		var fire <-chan time.Time
+		if len(pending) > 0 {
+			fire = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-in:
+			if !ok {
+				// The backend gave up: report what it saw so far
+				for _, p := range pending {
+					p.due = time.Time{}
+				}
+				sendDue(ctx, pending, out)
+				return
+			}
+			p := pending[event.Path]
+			if p == nil {
+				p = &pendingEvent{}
+				pending[event.Path] = p
+			}
+			p.op |= event.Op
+			p.due = time.Now().Add(wait)
+		case <-fire:
+		}
+
+		// Send whatever is due, oldest first, and wait for the next one
+		if !sendDue(ctx, pending, out) {
+			return
+		}
+		if next, ok := nextDue(pending); ok {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(time.Until(next))
+		}
+	}
+}
+
+// sendDue sends and forgets the pending events that are due. It returns
+// false if ctx is done before they could be sent.
+func sendDue(ctx context.Context, pending map[string]*pendingEvent, out chan<- Event) bool {
+	now := time.Now()
+	var due []string
+	for path, p := range pending {
+		if !p.due.After(now) {
+			due = append(due, path)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return pending[due[i]].due.Before(pending[due[j]].due)
+	})
+
+	for _, path := range due {
+		select {
+		case out <- Event{Path: path, Op: pending[path].op}:
+			delete(pending, path)
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+// nextDue returns when the next pending event is due
+func nextDue(pending map[string]*pendingEvent) (time.Time, bool) {
+	var next time.Time
+	for _, p := range pending {
+		if next.IsZero() || p.due.Before(next) {
+			next = p.due
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// sendEvent delivers event unless ctx is done first
+func sendEvent(ctx context.Context, events chan<- Event, event Event) bool {
+	select {
+	case events <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// sendError delivers err unless ctx is done first
+func sendError(ctx context.Context, errs chan<- error, err error) bool {
+	select {
+	case errs <- err:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// pollWatcher finds changes by comparing snapshots of the watched paths
+type pollWatcher struct {
+	fsys     FS
+	paths    []string
+	interval time.Duration
+	state    map[string]fs.FileInfo
+	vanished map[string]fs.FileInfo // Gone since the last scan, see diff
+	failed   map[string]string      // Last error reported per path
+	initErrs []error                // Errors of the first snapshot
+}
+
+// newPollWatcher takes the first snapshot of paths. Changes are reported
+// relative to it, so it is taken before Watch returns.
+func newPollWatcher(fsys FS, paths []string, interval time.Duration) *pollWatcher {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	p := &pollWatcher{fsys: fsys, paths: paths, interval: interval, failed: make(map[string]string)}
+	p.state, p.initErrs = p.scan()
+	return p
+}
+
+// run implements watchBackend
+func (p *pollWatcher) run(ctx context.Context, events chan<- Event, errs chan<- error) {
+	defer close(events)
+	defer close(errs)
+
+	for _, err := range p.initErrs {
+		if !sendError(ctx, errs, err) {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		state, scanErrs := p.scan()
+		for _, err := range scanErrs {
+			if !sendError(ctx, errs, err) {
+				return
+			}
+		}
+		for _, event := range p.diff(state) {
+			if !sendEvent(ctx, events, event) {
+				return
+			}
+		}
+		p.state = state
+	}
+}
+
+// scan describes every watched path and the entries of watched
+// directories. Errors are only returned when they differ from the last
+// scan, so a failing path isn't reported over and over.
+func (p *pollWatcher) scan() (map[string]fs.FileInfo, []error) {
+	state := make(map[string]fs.FileInfo)
+	var errs []error
+	report := func(path string, err error) {
+		if p.failed[path] != err.Error() {
+			p.failed[path] = err.Error()
+			errs = append(errs, fileError("watch", path, err))
+		}
+	}
+
+	for _, path := range p.paths {
+		info, err := p.fsys.Stat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			delete(p.failed, path)
+			continue
+		}
+		if err != nil {
+			report(path, err)
+			continue
+		}
+		delete(p.failed, path)
+		state[path] = info
+		if !info.IsDir() {
+			continue
+		}
+
+		entries, err := p.fsys.ReadDir(path)
+		if err != nil {
+			report(path, err)
+			continue
+		}
+		for _, entry := range entries {
+			if info, err := entry.Info(); err == nil {
+				state[filepath.Join(path, entry.Name())] = info
+			}
+		}
+	}
+	return state, errs
+}
+
+// diff compares state with the last snapshot. A file that disappeared
+// while the same file appeared under another name was renamed. Reading a
+// directory while an entry is renamed may see neither name, so a file
+// that disappeared is only reported as deleted if it doesn't turn up again
+// by the following scan.
+func (p *pollWatcher) diff(state map[string]fs.FileInfo) []Event {
+	var created, events []string
+	ops := make(map[string]EventOp)
+	changed := func(old, info fs.FileInfo) bool {
+		return !old.ModTime().Equal(info.ModTime()) || old.Size() != info.Size() || old.Mode() != info.Mode()
+	}
+
+	for path, info := range state {
+		old, ok := p.state[path]
+		if !ok {
+			// A file missed by the last scan isn't new
+			if old, ok = p.vanished[path]; !ok {
+				created = append(created, path)
+				continue
+			}
+			delete(p.vanished, path)
+		}
+		if changed(old, info) {
+			ops[path] = OpModify
+			events = append(events, path)
+		}
+	}
+	sort.Strings(created)
+
+	// Match what vanished before with what disappeared now against what
+	// appeared; anything left over from the last scan was deleted
+	vanished := make(map[string]fs.FileInfo)
+	for path, info := range p.state {
+		if _, ok := state[path]; !ok {
+			vanished[path] = info
+		}
+	}
+	renamedTo := make(map[string]bool)
+	for _, candidates := range []map[string]fs.FileInfo{p.vanished, vanished} {
+		for _, path := range sortedKeys(candidates) {
+			for _, newPath := range created {
+				if !renamedTo[newPath] && sameFile(candidates[path], state[newPath]) {
+					ops[path] = OpRename
+					renamedTo[newPath] = true
+					events = append(events, path)
+					delete(candidates, path)
+					break
+				}
+			}
+		}
+	}
+	for path := range p.vanished {
+		ops[path] = OpDelete
+		events = append(events, path)
+	}
+	p.vanished = vanished
+
+	for _, path := range created {
+		ops[path] = OpCreate
+		events = append(events, path)
+	}
+
+	sort.Strings(events)
+	result := make([]Event, len(events))
+	for i, path := range events {
+		result[i] = Event{Path: path, Op: ops[path]}
+	}
+	return result
+}
+
+// sortedKeys returns the paths of m in order
+func sortedKeys(m map[string]fs.FileInfo) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
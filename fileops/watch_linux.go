@@ -0,0 +1,204 @@
+//go:build linux
+
+package fileops
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// inotifyMask selects the inotify events the watcher translates
+const inotifyMask = unix.IN_CREATE | unix.IN_MODIFY | unix.IN_ATTRIB | unix.IN_DELETE |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF
+
+// inotifyDir is a directory watched with inotify
+type inotifyDir struct {
+	path  string
+	names map[string]bool // Entries to report, nil for all of them
+	self  bool            // Whether the directory itself is watched
+}
+
+// inotifyWatcher is the native watcher of Linux. Files are watched through
+// their directory, so a file replaced by a rename is still followed.
+type inotifyWatcher struct {
+	fd   int
+	wake [2]int // A pipe that interrupts poll(2) when the watch ends
+	dirs map[int]*inotifyDir
+}
+
+// newNativeWatcher sets up inotify watches for paths
+func newNativeWatcher(paths []string) (watchBackend, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialize inotify")
+	}
+	w := &inotifyWatcher{fd: fd, dirs: make(map[int]*inotifyDir)}
+	if err := unix.Pipe2(w.wake[:], unix.O_CLOEXEC|unix.O_NONBLOCK); err != nil {
+		unix.Close(fd)
+		return nil, errors.Wrap(err, "failed to create wake-up pipe")
+	}
+
+	for _, path := range paths {
+		if err := w.add(path); err != nil {
+			w.close()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// add watches path, or the directory holding it if it is a file
+func (w *inotifyWatcher) add(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileError("watch", path, err)
+	}
+
+	dir, name := path, ""
+	if !info.IsDir() {
+		dir, name = filepath.Dir(path), filepath.Base(path)
+	}
+	wd, err := unix.InotifyAddWatch(w.fd, dir, inotifyMask)
+	if err != nil {
+		return fileError("watch", dir, err)
+	}
+
+	// Watching the same directory twice returns the same descriptor
+	watched := w.dirs[wd]
+	if watched == nil {
+		watched = &inotifyDir{path: dir, names: make(map[string]bool)}
+		w.dirs[wd] = watched
+	}
+	switch {
+	case name == "":
+		watched.self, watched.names = true, nil
+	case watched.names != nil:
+		watched.names[name] = true
+	}
+	return nil
+}
+
+// run implements watchBackend
+func (w *inotifyWatcher) run(ctx context.Context, events chan<- Event, errs chan<- error) {
+	defer close(events)
+	defer close(errs)
+
+	// Wake poll(2) up once ctx is done. The pipe is only closed after this
+	// goroutine is gone, so it never writes to a reused descriptor.
+	stopped := make(chan struct{})
+	var waker sync.WaitGroup
+	waker.Add(1)
+	go func() {
+		defer waker.Done()
+		select {
+		case <-ctx.Done():
+			unix.Write(w.wake[1], []byte{0})
+		case <-stopped:
+		}
+	}()
+	defer w.close()
+	defer waker.Wait()
+	defer close(stopped)
+
+	buffer := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	fds := []unix.PollFd{{Fd: int32(w.fd), Events: unix.POLLIN}, {Fd: int32(w.wake[0]), Events: unix.POLLIN}}
+	for {
+		if _, err := unix.Poll(fds, -1); err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			sendError(ctx, errs, errors.Wrap(err, "failed to wait for inotify events"))
+			return
+		}
+		if fds[1].Revents != 0 || ctx.Err() != nil {
+			return
+		}
+
+		n, err := unix.Read(w.fd, buffer)
+		if err == unix.EAGAIN || err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			sendError(ctx, errs, errors.Wrap(err, "failed to read inotify events"))
+			return
+		}
+		if !w.dispatch(ctx, buffer[:n], events, errs) {
+			return
+		}
+	}
+}
+
+// dispatch translates the inotify events in buffer. It returns false if
+// ctx is done before they could be sent.
+func (w *inotifyWatcher) dispatch(ctx context.Context, buffer []byte, events chan<- Event, errs chan<- error) bool {
+	for offset := 0; offset+unix.SizeofInotifyEvent <= len(buffer); {
+		raw := (*unix.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+		nameStart := offset + unix.SizeofInotifyEvent
+		offset = nameStart + int(raw.Len)
+		name := strings.TrimRight(string(buffer[nameStart:offset]), "\x00")
+
+		if raw.Mask&unix.IN_Q_OVERFLOW != 0 {
+			if !sendError(ctx, errs, ErrWatchOverflow) {
+				return false
+			}
+			continue
+		}
+
+		dir := w.dirs[int(raw.Wd)]
+		if dir == nil {
+			continue
+		}
+		if raw.Mask&unix.IN_IGNORED != 0 {
+			// The directory is gone and so is its watch
+			delete(w.dirs, int(raw.Wd))
+			continue
+		}
+
+		path := dir.path
+		if name != "" {
+			if dir.names != nil && !dir.names[name] {
+				continue
+			}
+			path = filepath.Join(dir.path, name)
+		} else if !dir.self {
+			continue
+		}
+
+		if op := inotifyOp(raw.Mask); op != 0 && !sendEvent(ctx, events, Event{Path: path, Op: op}) {
+			return false
+		}
+	}
+	return true
+}
+
+// inotifyOp translates an inotify event mask
+func inotifyOp(mask uint32) EventOp {
+	var op EventOp
+	if mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+		op |= OpCreate
+	}
+	if mask&(unix.IN_MODIFY|unix.IN_ATTRIB) != 0 {
+		op |= OpModify
+	}
+	if mask&(unix.IN_DELETE|unix.IN_DELETE_SELF) != 0 {
+		op |= OpDelete
+	}
+	if mask&(unix.IN_MOVED_FROM|unix.IN_MOVE_SELF) != 0 {
+		op |= OpRename
+	}
+	return op
+}
+
+// close releases the inotify instance and the wake-up pipe
+func (w *inotifyWatcher) close() {
+	unix.Close(w.fd)
+	unix.Close(w.wake[0])
+	unix.Close(w.wake[1])
+}
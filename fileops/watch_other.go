@@ -0,0 +1,13 @@
+//go:build !linux
+
+package fileops
+
+import (
+	"github.com/pkg/errors"
+)
+
+// newNativeWatcher fails: only Linux has a native watcher, other systems
+// poll
+func newNativeWatcher(paths []string) (watchBackend, error) {
+	return nil, errors.New("no native file watcher on this system")
+}
@@ -0,0 +1,248 @@
+package fileops
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	apperrors "error-handling-demo/errors"
+	"error-handling-demo/faults"
+)
+
+// watchTimeout bounds every wait for an event
+const watchTimeout = 5 * time.Second
+
+// expectEvent waits for an event on path that includes op, skipping others
+func expectEvent(t *testing.T, w *Watcher, path string, op EventOp) Event {
+	t.Helper()
+
+	timeout := time.After(watchTimeout)
+	for {
+		select {
+		case event, ok := <-w.Events:
+			if !ok {
+				t.Fatalf("events closed while waiting for %s on %s", op, path)
+			}
+			if event.Path == path && event.Has(op) {
+				return event
+			}
+		case err := <-w.Errors:
+			t.Fatalf("watch error while waiting for %s on %s: %v", op, path, err)
+		case <-timeout:
+			t.Fatalf("no %s event on %s", op, path)
+		}
+	}
+}
+
+// collectEvents returns the events received within d
+func collectEvents(w *Watcher, d time.Duration) []Event {
+	var events []Event
+	timeout := time.After(d)
+	for {
+		select {
+		case event := <-w.Events:
+			events = append(events, event)
+		case <-timeout:
+			return events
+		}
+	}
+}
+
+func TestWatchDirectory(t *testing.T) {
+	for _, poll := range []bool{false, true} {
+		name := "native"
+		if poll {
+			name = "poll"
+		}
+		t.Run(name, func(t *testing.T) {
+			checkGoroutineLeaks(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			dir := t.TempDir()
+			opts := WatchOptions{Debounce: 20 * time.Millisecond, Poll: poll, PollInterval: 10 * time.Millisecond}
+			w, err := Watch(ctx, []string{dir}, opts)
+			if err != nil {
+				t.Fatalf("Watch failed: %v", err)
+			}
+			// Only Linux has a native watcher, elsewhere Watch says why it polls
+			wantPoll := poll || runtime.GOOS != "linux"
+			if (w.Fallback != nil) != (wantPoll && !poll) {
+				t.Fatalf("Fallback = %v", w.Fallback)
+			}
+			if w.Polling() != wantPoll {
+				t.Fatalf("Polling() = %v, want %v", w.Polling(), wantPoll)
+			}
+
+			a, b := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+			if err := os.WriteFile(a, []byte("one"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			expectEvent(t, w, a, OpCreate)
+
+			if err := os.WriteFile(a, []byte("two, longer"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			expectEvent(t, w, a, OpModify)
+
+			if err := os.Rename(a, b); err != nil {
+				t.Fatal(err)
+			}
+			expectEvent(t, w, a, OpRename)
+			expectEvent(t, w, b, OpCreate)
+
+			if err := os.Remove(b); err != nil {
+				t.Fatal(err)
+			}
+			expectEvent(t, w, b, OpDelete)
+		})
+	}
+}
+
+func TestWatchDebouncesBursts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "data.log")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := Watch(ctx, []string{path}, WatchOptions{Debounce: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for i := 0; i < 20; i++ {
+		f.WriteString("line\n")
+		time.Sleep(time.Millisecond)
+	}
+
+	events := collectEvents(w, 500*time.Millisecond)
+	if len(events) != 1 || events[0].Path != path || events[0].Op != OpModify {
+		t.Errorf("20 quick writes gave events %v, want a single modify", events)
+	}
+}
+
+func TestWatchFileFollowsAtomicWrites(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(config, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := Watch(ctx, []string{config}, WatchOptions{Debounce: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+
+	// Other files of the directory, such as the temporary file, are not
+	// reported, and the replaced file is still watched afterwards
+	for _, content := range []string{`{"a":1}`, `{"a":2}`} {
+		if err := WriteFile(config, content); err != nil {
+			t.Fatal(err)
+		}
+		expectEvent(t, w, config, OpCreate)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "other.json"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range collectEvents(w, 100*time.Millisecond) {
+		if event.Path != config {
+			t.Errorf("unexpected event %v", event)
+		}
+	}
+}
+
+func TestWatchStopsWithContext(t *testing.T) {
+	checkGoroutineLeaks(t)
+
+	for _, poll := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		w, err := Watch(ctx, []string{t.TempDir()}, WatchOptions{Poll: poll, PollInterval: 10 * time.Millisecond})
+		if err != nil {
+			t.Fatalf("Watch failed: %v", err)
+		}
+		cancel()
+
+		for _, closed := range []func() bool{
+			func() bool { _, ok := <-w.Events; return !ok },
+			func() bool { _, ok := <-w.Errors; return !ok },
+		} {
+			done := make(chan bool)
+			go func() { done <- closed() }()
+			select {
+			case ok := <-done:
+				if !ok {
+					t.Error("received from a stopped watcher")
+				}
+			case <-time.After(watchTimeout):
+				t.Fatalf("watcher (poll %v) kept running after cancel", poll)
+			}
+		}
+	}
+
+	if _, err := Watch(context.Background(), []string{filepath.Join(t.TempDir(), "missing")}, WatchOptions{}); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("watching a missing path returned %v", err)
+	}
+}
+
+func TestWatchPollsOtherFileSystems(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mem := NewMemFS()
+	memTree(t, mem, "conf/app.json")
+	injector := faults.NewInjector(1, faults.Rule{Op: "fileops.fs.stat", Probability: 1, Err: syscall.EACCES, Times: 1})
+	opts := WatchOptions{Debounce: 10 * time.Millisecond, PollInterval: 5 * time.Millisecond, FS: Faulty(mem, injector)}
+
+	// The first stat is Watch's own check
+	if _, err := Watch(ctx, []string{"conf"}, opts); err == nil {
+		t.Fatal("Watch ignored the failed stat")
+	}
+	injector = faults.NewInjector(1, faults.Rule{Op: "fileops.fs.readdir", Probability: 1, Err: syscall.EACCES, Times: 1})
+	opts.FS = Faulty(mem, injector)
+	w, err := Watch(ctx, []string{"conf"}, opts)
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	if !w.Polling() || w.Fallback != nil {
+		t.Fatalf("watching a MemFS doesn't poll as asked (fallback %v)", w.Fallback)
+	}
+
+	// A failed scan is reported once on Errors
+	select {
+	case err := <-w.Errors:
+		var fileErr *apperrors.FileError
+		if !errors.As(err, &fileErr) || fileErr.Kind != apperrors.KindPermission || fileErr.Path != "conf" {
+			t.Errorf("watch error %v is not a permission FileError for conf", err)
+		}
+	case <-time.After(watchTimeout):
+		t.Fatal("the failed scan was not reported")
+	}
+
+	// The entries the failed scan missed are reported once they are seen
+	expectEvent(t, w, filepath.Join("conf", "app.json"), OpCreate)
+
+	if err := mem.Rename("conf/app.json", "conf/app.old"); err != nil {
+		t.Fatal(err)
+	}
+	event := expectEvent(t, w, filepath.Join("conf", "app.json"), OpRename)
+	if strings.Contains(event.Op.String(), "delete") {
+		t.Errorf("rename reported as %s", event.Op)
+	}
+	expectEvent(t, w, filepath.Join("conf", "app.old"), OpCreate)
+}
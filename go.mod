@@ -1,10 +1,10 @@
 module error-handling-demo
 
-go 1.19
+go 1.20
 
 require (
-	github.com/mattn/go-sqlite3 v1.14.28 // indirect
-	github.com/pkg/errors v0.9.1 // indirect
-	github.com/sirupsen/logrus v1.9.3 // indirect
-	golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
+	github.com/mattn/go-sqlite3 v1.14.28
+	github.com/pkg/errors v0.9.1
+	github.com/sirupsen/logrus v1.9.3
+	golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8
 )
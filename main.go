@@ -5,6 +5,7 @@ import (
         "fmt"
         "os"
         "os/signal"
+        "sort"
         "sync"
         "syscall"
         "time"
@@ -14,9 +15,11 @@ import (
         "error-handling-demo/config"
         "error-handling-demo/dbops"
         "error-handling-demo/errors"
+        "error-handling-demo/faults"
         "error-handling-demo/fileops"
         "error-handling-demo/netops"
         "error-handling-demo/utils"
+        "error-handling-demo/validation"
 )
 
 func main() {
@@ -30,6 +33,23 @@ func main() {
                 log.WithError(err).Fatal("Failed to load configuration")
         }
 
+        // Turn on fault injection if the configuration or environment asks for it
+        injector, err := faults.Configure(cfg.FaultInjection)
+        if err != nil {
+                log.WithError(err).Fatal("Failed to configure fault injection")
+        }
+        if injector != nil {
+                log.WithField("rules", len(cfg.FaultInjection.Rules)).Warn("Fault injection is enabled, operations will fail on purpose")
+        }
+
+        // Run a maintenance subcommand instead of the demo if one was given
+        if len(os.Args) > 1 {
+                if err := runCommand(cfg, log, os.Args[1:]); err != nil {
+                        log.WithError(err).Fatal("Command failed")
+                }
+                return
+        }
+
         // Create a cancellable context that will be used across operations
         ctx, cancel := context.WithCancel(context.Background())
         defer cancel()
@@ -84,7 +104,7 @@ func main() {
         wg.Add(1)
         go func() {
                 defer wg.Done()
-                demoDatabaseOperations(ctx, log, cfg.DatabasePath)
+                demoDatabaseOperations(ctx, log, cfg)
         }()
 
         // Wait for all operations to complete
@@ -138,52 +158,45 @@ func demoCustomErrorTypes(log *logrus.Logger) {
 
         err := validateUser(user)
         if err != nil {
-                switch e := err.(type) {
-                case *errors.ValidationError:
-                        log.WithFields(logrus.Fields{
-                                "field":   e.Field,
-                                "message": e.Message,
-                        }).Error("Validation error")
-                default:
+                // errors.As finds the ValidationError even if it has been wrapped
+                var validationErr *errors.ValidationError
+                if errors.As(err, &validationErr) {
+                        // Each field error carries its path, rule code and parameters
+                        for _, fieldErr := range validationErr.FieldErrors() {
+                                log.WithFields(logrus.Fields{
+                                        "field":   fieldErr.Path,
+                                        "code":    fieldErr.Code,
+                                        "message": fieldErr.Message,
+                                }).Error("Validation error")
+                        }
+
+                        // A single field error can also be matched directly
+                        if errors.Is(err, &errors.FieldError{Path: "age"}) {
+                                log.Info("The age field was rejected")
+                        }
+
+                        // End users get the message in their language, picked from
+                        // the Accept-Language header of their request
+                        locale := errors.DefaultCatalog.MatchLocale("de-CH, de;q=0.9, en;q=0.5")
+                        log.WithField("locale", locale).Info(errors.Localize(err, locale))
+                } else {
                         log.WithError(err).Error("Unknown error occurred during validation")
                 }
         }
 }
 
-// validateUser demonstrates returning custom error types
+// validateUser demonstrates returning custom error types: every broken rule
+// is collected into a single ValidationError instead of stopping at the first
 func validateUser(user struct {
         Username string
         Email    string
         Age      int
 }) error {
-        if user.Username == "" {
-                return &errors.ValidationError{
-                        Field:   "username",
-                        Message: "username cannot be empty",
-                }
-        }
-
-        if !isValidEmail(user.Email) {
-                return &errors.ValidationError{
-                        Field:   "email",
-                        Message: "email is not valid",
-                }
-        }
-
-        if user.Age < 18 {
-                return &errors.ValidationError{
-                        Field:   "age",
-                        Message: "user must be at least 18 years old",
-                }
-        }
-
-        return nil
-}
-
-// isValidEmail is a simple email validation function
-func isValidEmail(email string) bool {
-        // This is a very simplified check - in real code use a proper validation
-        return len(email) > 5 && (email[len(email)-4:] == ".com" || email[len(email)-4:] == ".org")
+        v := validation.New()
+        v.Field("username", user.Username, validation.Required())
+        v.Field("email", user.Email, validation.Required(), validation.Email())
+        v.Field("age", user.Age, validation.Min(18).WithMessage("must be at least {min} years old"))
+        return v.Err("user validation failed")
 }
 
 // demoErrorWrapping demonstrates error wrapping using github.com/pkg/errors
@@ -318,15 +331,16 @@ func demoNetworkOperations(ctx context.Context, log *logrus.Logger) {
 }
 
 // demoDatabaseOperations demonstrates database operations with error handling
-func demoDatabaseOperations(ctx context.Context, log *logrus.Logger, dbPath string) {
+func demoDatabaseOperations(ctx context.Context, log *logrus.Logger, cfg *config.Config) {
         log.Info("Demonstrating database operations with error handling")
 
         // Initialize database with defer for cleanup
-        db, err := dbops.InitDatabase(dbPath)
+        db, err := dbops.InitDatabase(cfg.DatabasePath)
         if err != nil {
                 log.WithError(err).Error("Failed to initialize database")
                 return
         }
+        db.Configure(cfg, log)
         defer func() {
                 if err := db.Close(); err != nil {
                         log.WithError(err).Error("Failed to close database connection")
@@ -335,6 +349,14 @@ func demoDatabaseOperations(ctx context.Context, log *logrus.Logger, dbPath stri
                 }
         }()
 
+        // Watch the connection in the background for as long as the demo runs
+        if interval := cfg.DatabasePool.HealthCheckInterval; interval > 0 {
+                healthCtx, stopHealth := context.WithCancel(ctx)
+                defer stopHealth()
+                checker := dbops.NewHealthChecker(db, time.Duration(interval)*time.Second, log)
+                go checker.Run(healthCtx)
+        }
+
         // Create a user
         user := struct {
                 ID       int
@@ -375,4 +397,22 @@ func demoDatabaseOperations(ctx context.Context, log *logrus.Logger, dbPath stri
                         log.WithError(err).Error("Unexpected error when retrieving non-existent user")
                 }
         }
+
+        // Report how long each kind of statement took
+        stats := db.QueryStats()
+        operations := make([]string, 0, len(stats))
+        for operation := range stats {
+                operations = append(operations, operation)
+        }
+        sort.Strings(operations)
+        for _, operation := range operations {
+                s := stats[operation]
+                log.WithFields(logrus.Fields{
+                        "operation": operation,
+                        "count":     s.Count,
+                        "errors":    s.Errors,
+                        "mean":      s.Mean(),
+                        "max":       s.Max,
+                }).Info("Database query statistics")
+        }
 }
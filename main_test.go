@@ -0,0 +1,25 @@
+package main
+
+import (
+        "testing"
+
+        "error-handling-demo/errors"
+)
+
+func TestValidateUserAge(t *testing.T) {
+        type user = struct {
+                Username string
+                Email    string
+                Age      int
+        }
+
+        for _, age := range []int{0, 17} {
+                err := validateUser(user{Username: "jane", Email: "jane@example.com", Age: age})
+                if !errors.Is(err, &errors.FieldError{Path: "age"}) {
+                        t.Errorf("validateUser accepted age %d: %v", age, err)
+                }
+        }
+        if err := validateUser(user{Username: "jane", Email: "jane@example.com", Age: 18}); err != nil {
+                t.Errorf("validateUser rejected age 18: %v", err)
+        }
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	apperrors "error-handling-demo/errors"
+)
+
+// Catalog codes of the model errors, see errors.RegisterCode
+const (
+	CodeUserNotFound  = "user_not_found"
+	CodeDuplicateUser = "duplicate_user"
+)
+
+func init() {
+	apperrors.RegisterCode(ErrUserNotFound, CodeUserNotFound)
+	apperrors.RegisterCode(ErrDuplicateUser, CodeDuplicateUser)
+
+	apperrors.DefaultCatalog.AddMessages("en", map[string]string{
+		"code/" + CodeUserNotFound:  "The user does not exist",
+		"code/" + CodeDuplicateUser: "A user with this name already exists",
+	})
+	apperrors.DefaultCatalog.AddMessages("de", map[string]string{
+		"code/" + CodeUserNotFound:  "Der Benutzer existiert nicht",
+		"code/" + CodeDuplicateUser: "Ein Benutzer mit diesem Namen existiert bereits",
+	})
+	apperrors.DefaultCatalog.AddMessages("es", map[string]string{
+		"code/" + CodeUserNotFound:  "El usuario no existe",
+		"code/" + CodeDuplicateUser: "Ya existe un usuario con este nombre",
+	})
+}
@@ -0,0 +1,364 @@
+package modeltest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	apperrors "error-handling-demo/errors"
+	"error-handling-demo/models"
+)
+
+// RunUserRepositoryTests checks that a models.UserRepository keeps the
+// contract documented on the interface. newRepo must return an empty
+// repository; it is called once per subtest.
+//
+// Call it from a test in the package of the implementation:
+//
+//	func TestUserRepository(t *testing.T) {
+//		modeltest.RunUserRepositoryTests(t, func(t *testing.T) models.UserRepository {
+//			return NewMyRepository()
+//		})
+//	}
+func RunUserRepositoryTests(t *testing.T, newRepo func(t *testing.T) models.UserRepository) {
+	t.Helper()
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T, repo models.UserRepository)
+	}{
+		{"CreateAndFind", testCreateAndFind},
+		{"CreateAssignsID", testCreateAssignsID},
+		{"CreateDuplicateUsername", testCreateDuplicateUsername},
+		{"CreateDuplicateID", testCreateDuplicateID},
+		{"CreateInvalid", testCreateInvalid},
+		{"FindMissing", testFindMissing},
+		{"Update", testUpdate},
+		{"UpdateMissing", testUpdateMissing},
+		{"UpdateDuplicateUsername", testUpdateDuplicateUsername},
+		{"UpdateInvalid", testUpdateInvalid},
+		{"Delete", testDelete},
+		{"DeleteMissing", testDeleteMissing},
+		{"DeleteKeepsNamesTaken", testDeleteKeepsNamesTaken},
+		{"ConcurrentCreates", testConcurrentCreates},
+		{"ConcurrentDuplicateCreates", testConcurrentDuplicateCreates},
+		{"ConcurrentUpdates", testConcurrentUpdates},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newRepo(t))
+		})
+	}
+}
+
+// newUser returns a valid user with a username derived from name
+func newUser(name string) *models.User {
+	return &models.User{Username: name, Email: name + "@example.com"}
+}
+
+// mustCreate creates a user and fails the test if that doesn't work
+func mustCreate(t *testing.T, repo models.UserRepository, name string) *models.User {
+	t.Helper()
+
+	user := newUser(name)
+	if err := repo.Create(user); err != nil {
+		t.Fatalf("Create(%q) failed: %v", name, err)
+	}
+	return user
+}
+
+// expectError fails the test unless errors.Is(err, target)
+func expectError(t *testing.T, op string, err, target error) {
+	t.Helper()
+
+	if !errors.Is(err, target) {
+		t.Fatalf("%s: got error %v, want one matching %q", op, err, target)
+	}
+}
+
+// expectValidationError fails the test unless err is a *errors.ValidationError
+func expectValidationError(t *testing.T, op string, err error) {
+	t.Helper()
+
+	var validationErr *apperrors.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("%s: got error %v, want a *errors.ValidationError", op, err)
+	}
+	if len(validationErr.Errors) == 0 {
+		t.Fatalf("%s: validation error lists no failures", op)
+	}
+}
+
+func testCreateAndFind(t *testing.T, repo models.UserRepository) {
+	created := mustCreate(t, repo, "alice")
+
+	byID, err := repo.FindByID(created.ID)
+	if err != nil {
+		t.Fatalf("FindByID(%d) failed: %v", created.ID, err)
+	}
+	if byID.Username != "alice" || byID.Email != "alice@example.com" {
+		t.Fatalf("FindByID(%d) = %+v, want alice", created.ID, byID)
+	}
+	if byID.CreatedAt.IsZero() {
+		t.Fatalf("FindByID(%d) returned no creation time", created.ID)
+	}
+
+	byName, err := repo.FindByUsername("alice")
+	if err != nil {
+		t.Fatalf("FindByUsername(alice) failed: %v", err)
+	}
+	if byName.ID != created.ID {
+		t.Fatalf("FindByUsername(alice) returned ID %d, want %d", byName.ID, created.ID)
+	}
+}
+
+func testCreateAssignsID(t *testing.T, repo models.UserRepository) {
+	first := mustCreate(t, repo, "alice")
+	second := mustCreate(t, repo, "bob")
+
+	if first.ID == 0 || second.ID == 0 {
+		t.Fatalf("Create left IDs unassigned: %d, %d", first.ID, second.ID)
+	}
+	if first.ID == second.ID {
+		t.Fatalf("Create assigned ID %d twice", first.ID)
+	}
+}
+
+func testCreateDuplicateUsername(t *testing.T, repo models.UserRepository) {
+	mustCreate(t, repo, "alice")
+
+	duplicate := &models.User{Username: "alice", Email: "other@example.com"}
+	expectError(t, "Create(duplicate username)", repo.Create(duplicate), models.ErrDuplicateUser)
+}
+
+func testCreateDuplicateID(t *testing.T, repo models.UserRepository) {
+	existing := mustCreate(t, repo, "alice")
+
+	duplicate := newUser("bob")
+	duplicate.ID = existing.ID
+	expectError(t, "Create(duplicate ID)", repo.Create(duplicate), models.ErrDuplicateUser)
+}
+
+func testCreateInvalid(t *testing.T, repo models.UserRepository) {
+	expectValidationError(t, "Create(empty user)", repo.Create(&models.User{}))
+
+	// Nothing may have been stored
+	_, err := repo.FindByUsername("")
+	expectError(t, "FindByUsername after invalid Create", err, models.ErrUserNotFound)
+}
+
+func testFindMissing(t *testing.T, repo models.UserRepository) {
+	_, err := repo.FindByID(12345)
+	expectError(t, "FindByID(missing)", err, models.ErrUserNotFound)
+
+	_, err = repo.FindByUsername("nobody")
+	expectError(t, "FindByUsername(missing)", err, models.ErrUserNotFound)
+}
+
+func testUpdate(t *testing.T, repo models.UserRepository) {
+	user := mustCreate(t, repo, "alice")
+
+	user.Username = "alicia"
+	user.Email = "alicia@example.com"
+	if err := repo.Update(user); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	stored, err := repo.FindByID(user.ID)
+	if err != nil {
+		t.Fatalf("FindByID after Update failed: %v", err)
+	}
+	if stored.Username != "alicia" || stored.Email != "alicia@example.com" {
+		t.Fatalf("FindByID after Update = %+v, want alicia", stored)
+	}
+
+	// The old username is free again
+	_, err = repo.FindByUsername("alice")
+	expectError(t, "FindByUsername(old name)", err, models.ErrUserNotFound)
+}
+
+func testUpdateMissing(t *testing.T, repo models.UserRepository) {
+	user := newUser("ghost")
+	user.ID = 12345
+	expectError(t, "Update(missing)", repo.Update(user), models.ErrUserNotFound)
+}
+
+func testUpdateDuplicateUsername(t *testing.T, repo models.UserRepository) {
+	mustCreate(t, repo, "alice")
+	bob := mustCreate(t, repo, "bob")
+
+	bob.Username = "alice"
+	expectError(t, "Update(taken username)", repo.Update(bob), models.ErrDuplicateUser)
+}
+
+func testUpdateInvalid(t *testing.T, repo models.UserRepository) {
+	user := mustCreate(t, repo, "alice")
+
+	user.Email = ""
+	expectValidationError(t, "Update(empty email)", repo.Update(user))
+
+	stored, err := repo.FindByID(user.ID)
+	if err != nil {
+		t.Fatalf("FindByID after invalid Update failed: %v", err)
+	}
+	if stored.Email != "alice@example.com" {
+		t.Fatalf("invalid Update changed the email to %q", stored.Email)
+	}
+}
+
+func testDelete(t *testing.T, repo models.UserRepository) {
+	user := mustCreate(t, repo, "alice")
+
+	if err := repo.Delete(user.ID); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	_, err := repo.FindByID(user.ID)
+	expectError(t, "FindByID after Delete", err, models.ErrUserNotFound)
+
+	_, err = repo.FindByUsername("alice")
+	expectError(t, "FindByUsername after Delete", err, models.ErrUserNotFound)
+
+	expectError(t, "Delete twice", repo.Delete(user.ID), models.ErrUserNotFound)
+}
+
+func testDeleteMissing(t *testing.T, repo models.UserRepository) {
+	expectError(t, "Delete(missing)", repo.Delete(12345), models.ErrUserNotFound)
+}
+
+func testDeleteKeepsNamesTaken(t *testing.T, repo models.UserRepository) {
+	alice := mustCreate(t, repo, "alice")
+	bob := mustCreate(t, repo, "bob")
+	if err := repo.Delete(alice.ID); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	// Neither the username nor the ID can be used again
+	expectError(t, "Create with a deleted username", repo.Create(newUser("alice")), models.ErrDuplicateUser)
+	reused := newUser("carol")
+	reused.ID = alice.ID
+	expectError(t, "Create with a deleted ID", repo.Create(reused), models.ErrDuplicateUser)
+	bob.Username = "alice"
+	expectError(t, "Update to a deleted username", repo.Update(bob), models.ErrDuplicateUser)
+
+	// A deleted user can't be updated either
+	alice.Email = "alice@new.example.com"
+	expectError(t, "Update after Delete", repo.Update(alice), models.ErrUserNotFound)
+
+	// New users get fresh IDs
+	carol := mustCreate(t, repo, "carol")
+	if carol.ID == alice.ID {
+		t.Fatalf("Create reused the deleted ID %d", alice.ID)
+	}
+}
+
+// concurrency is the number of goroutines used by the concurrent tests
+const concurrency = 16
+
+func testConcurrentCreates(t *testing.T, repo models.UserRepository) {
+	var wg sync.WaitGroup
+	errs := make(chan error, concurrency)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- repo.Create(newUser(fmt.Sprintf("user%02d", i)))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("concurrent Create failed: %v", err)
+		}
+	}
+
+	// Every user is stored under its own ID
+	ids := make(map[int]string)
+	for i := 0; i < concurrency; i++ {
+		name := fmt.Sprintf("user%02d", i)
+		user, err := repo.FindByUsername(name)
+		if err != nil {
+			t.Fatalf("FindByUsername(%s) after concurrent Create failed: %v", name, err)
+		}
+		if other, taken := ids[user.ID]; taken {
+			t.Fatalf("%s and %s were both given ID %d", other, name, user.ID)
+		}
+		ids[user.ID] = name
+	}
+}
+
+func testConcurrentDuplicateCreates(t *testing.T, repo models.UserRepository) {
+	var wg sync.WaitGroup
+	errs := make(chan error, concurrency)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- repo.Create(newUser("alice"))
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	// Exactly one Create wins, the others see a duplicate
+	created := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case errors.Is(err, models.ErrDuplicateUser):
+		default:
+			t.Fatalf("concurrent duplicate Create failed with %v, want ErrDuplicateUser", err)
+		}
+	}
+	if created != 1 {
+		t.Fatalf("%d concurrent creates of the same username succeeded, want 1", created)
+	}
+}
+
+func testConcurrentUpdates(t *testing.T, repo models.UserRepository) {
+	users := make([]*models.User, concurrency)
+	for i := range users {
+		users[i] = mustCreate(t, repo, fmt.Sprintf("user%02d", i))
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, concurrency)
+	for _, user := range users {
+		wg.Add(1)
+		go func(user models.User) {
+			defer wg.Done()
+			user.Email = "new-" + user.Email
+			if err := repo.Update(&user); err != nil {
+				errs <- err
+				return
+			}
+			_, err := repo.FindByID(user.ID)
+			errs <- err
+		}(*user)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("concurrent Update failed: %v", err)
+		}
+	}
+
+	for _, user := range users {
+		stored, err := repo.FindByID(user.ID)
+		if err != nil {
+			t.Fatalf("FindByID(%d) after concurrent Update failed: %v", user.ID, err)
+		}
+		if stored.Email != "new-"+user.Email {
+			t.Fatalf("user %d has email %q after Update, want %q", user.ID, stored.Email, "new-"+user.Email)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+// Package modeltest provides test doubles for the interfaces in package models
+package modeltest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"error-handling-demo/models"
+)
+
+// Names of the FakeUserRepository methods, used to target faults and count calls
+const (
+	OpFindByID       = "FindByID"
+	OpFindByUsername = "FindByUsername"
+	OpCreate         = "Create"
+	OpUpdate         = "Update"
+	OpDelete         = "Delete"
+)
+
+// Fault describes a failure the fake repository injects into its own calls
+type Fault struct {
+	Op      string        // Method to fail, e.g. OpCreate; empty matches every method
+	OnCall  int           // Only fail the Nth call (1-based) of Op; 0 fails every call
+	Latency time.Duration // Delay added before the call runs
+	Err     error         // Error returned instead of running the call; nil only adds latency
+}
+
+// matches reports whether the fault applies to the given call of op
+func (f Fault) matches(op string, call int) bool {
+	if f.Op != "" && f.Op != op {
+		return false
+	}
+	return f.OnCall == 0 || f.OnCall == call
+}
+
+// FakeUserRepository is a thread-safe, in-memory models.UserRepository.
+// It keeps the same contract as the database implementation: usernames are
+// unique, missing users are reported as models.ErrUserNotFound and users are
+// validated before they are stored. Like the database, it keeps deleted
+// users around, so their IDs and usernames can't be reused. Faults can be
+// injected to exercise error paths deterministically.
+type FakeUserRepository struct {
+	mu      sync.Mutex
+	users   map[int]models.User
+	deleted map[int]models.User // Users removed by Delete
+	nextID  int
+	calls   map[string]int
+	faults  []Fault
+
+	// Now returns the time stored as CreatedAt, it defaults to time.Now
+	Now func() time.Time
+}
+
+// Check that FakeUserRepository implements the interface
+var _ models.UserRepository = (*FakeUserRepository)(nil)
+
+// NewFakeUserRepository creates an empty fake repository
+func NewFakeUserRepository() *FakeUserRepository {
+	return &FakeUserRepository{
+		users:   make(map[int]models.User),
+		deleted: make(map[int]models.User),
+		nextID:  1,
+		calls:   make(map[string]int),
+		Now:     time.Now,
+	}
+}
+
+// InjectFault adds a fault. Faults are checked in the order they were added
+// and the first one returning an error wins.
+func (r *FakeUserRepository) InjectFault(fault Fault) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.faults = append(r.faults, fault)
+}
+
+// FailNthCall makes the nth call of op return err
+func (r *FakeUserRepository) FailNthCall(op string, n int, err error) {
+	r.InjectFault(Fault{Op: op, OnCall: n, Err: err})
+}
+
+// ClearFaults removes all injected faults
+func (r *FakeUserRepository) ClearFaults() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.faults = nil
+}
+
+// Calls returns how often op has been called, including failed calls
+func (r *FakeUserRepository) Calls(op string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.calls[op]
+}
+
+// Users returns a copy of every user that hasn't been deleted, ordered by ID
+func (r *FakeUserRepository) Users() []models.User {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	users := make([]models.User, 0, len(r.users))
+	for _, user := range r.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users
+}
+
+// FindByID returns the user with the given ID
+func (r *FakeUserRepository) FindByID(id int) (*models.User, error) {
+	if err := r.enter(OpFindByID); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil, errors.Wrapf(models.ErrUserNotFound, "find user %d", id)
+	}
+	return &user, nil
+}
+
+// FindByUsername returns the user with the given username
+func (r *FakeUserRepository) FindByUsername(username string) (*models.User, error) {
+	if err := r.enter(OpFindByUsername); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, user := range r.users {
+		if user.Username == username {
+			return &user, nil
+		}
+	}
+	return nil, errors.Wrapf(models.ErrUserNotFound, "find user %q", username)
+}
+
+// Create validates and stores a new user, assigning an ID if user.ID is 0
+// and setting CreatedAt if it is zero
+func (r *FakeUserRepository) Create(user *models.User) error {
+	if err := r.enter(OpCreate); err != nil {
+		return err
+	}
+	if err := user.Validate(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if user.ID != 0 {
+		_, exists := r.users[user.ID]
+		_, deleted := r.deleted[user.ID]
+		if exists || deleted {
+			return errors.Wrapf(models.ErrDuplicateUser, "create user %d", user.ID)
+		}
+	}
+	if r.usernameTaken(user.Username, 0) {
+		return errors.Wrapf(models.ErrDuplicateUser, "create user %q", user.Username)
+	}
+
+	// nextID stays above every stored ID, so it is always free
+	if user.ID == 0 {
+		user.ID = r.nextID
+	}
+	if user.ID >= r.nextID {
+		r.nextID = user.ID + 1
+	}
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = r.Now().UTC()
+	}
+
+	r.users[user.ID] = *user
+	return nil
+}
+
+// Update validates and replaces an existing user
+func (r *FakeUserRepository) Update(user *models.User) error {
+	if err := r.enter(OpUpdate); err != nil {
+		return err
+	}
+	if err := user.Validate(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.users[user.ID]
+	if !ok {
+		return errors.Wrapf(models.ErrUserNotFound, "update user %d", user.ID)
+	}
+	if r.usernameTaken(user.Username, user.ID) {
+		return errors.Wrapf(models.ErrDuplicateUser, "update user %d to %q", user.ID, user.Username)
+	}
+
+	// The creation time belongs to the repository, not the caller
+	updated := *user
+	updated.CreatedAt = existing.CreatedAt
+	r.users[user.ID] = updated
+	user.CreatedAt = existing.CreatedAt
+	return nil
+}
+
+// Delete removes the user with the given ID. Its ID and username stay
+// taken, as they do in the database where deleted users are kept.
+func (r *FakeUserRepository) Delete(id int) error {
+	if err := r.enter(OpDelete); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return errors.Wrapf(models.ErrUserNotFound, "delete user %d", id)
+	}
+	delete(r.users, id)
+	r.deleted[id] = user
+	return nil
+}
+
+// enter counts a call of op and applies the matching faults. The latency is
+// slept without holding the lock so that concurrent calls aren't serialized.
+func (r *FakeUserRepository) enter(op string) error {
+	r.mu.Lock()
+	r.calls[op]++
+	call := r.calls[op]
+
+	var latency time.Duration
+	var err error
+	for _, fault := range r.faults {
+		if !fault.matches(op, call) {
+			continue
+		}
+		latency += fault.Latency
+		if fault.Err != nil {
+			err = fault.Err
+			break
+		}
+	}
+	r.mu.Unlock()
+
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	return err
+}
+
+// usernameTaken reports whether a user other than exceptID has username,
+// counting deleted users. The caller must hold r.mu.
+func (r *FakeUserRepository) usernameTaken(username string, exceptID int) bool {
+	for _, users := range []map[int]models.User{r.users, r.deleted} {
+		for id, user := range users {
+			if id != exceptID && user.Username == username {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package modeltest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"error-handling-demo/models"
+)
+
+func TestFakeUserRepository(t *testing.T) {
+	RunUserRepositoryTests(t, func(t *testing.T) models.UserRepository {
+		return NewFakeUserRepository()
+	})
+}
+
+func TestFakeUserRepositoryFailNthCall(t *testing.T) {
+	repo := NewFakeUserRepository()
+	errBoom := errors.New("boom")
+	repo.FailNthCall(OpCreate, 2, errBoom)
+
+	if err := repo.Create(newUser("alice")); err != nil {
+		t.Fatalf("first Create failed: %v", err)
+	}
+	if err := repo.Create(newUser("bob")); !errors.Is(err, errBoom) {
+		t.Fatalf("second Create returned %v, want the injected error", err)
+	}
+	if err := repo.Create(newUser("carol")); err != nil {
+		t.Fatalf("third Create failed: %v", err)
+	}
+
+	// The failed call stored nothing but was still counted
+	if _, err := repo.FindByUsername("bob"); !errors.Is(err, models.ErrUserNotFound) {
+		t.Fatalf("FindByUsername(bob) returned %v, want ErrUserNotFound", err)
+	}
+	if calls := repo.Calls(OpCreate); calls != 3 {
+		t.Fatalf("Calls(OpCreate) = %d, want 3", calls)
+	}
+}
+
+func TestFakeUserRepositoryLatency(t *testing.T) {
+	repo := NewFakeUserRepository()
+	repo.InjectFault(Fault{Op: OpFindByID, Latency: 20 * time.Millisecond})
+
+	start := time.Now()
+	if _, err := repo.FindByID(1); !errors.Is(err, models.ErrUserNotFound) {
+		t.Fatalf("FindByID returned %v, want ErrUserNotFound", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Fatalf("FindByID took %v, want at least the injected 20ms", elapsed)
+	}
+
+	// Faults for other methods don't apply
+	start = time.Now()
+	repo.Delete(1)
+	if elapsed := time.Since(start); elapsed >= 20*time.Millisecond {
+		t.Fatalf("Delete took %v, the FindByID latency leaked into it", elapsed)
+	}
+
+	repo.ClearFaults()
+	start = time.Now()
+	repo.FindByID(1)
+	if elapsed := time.Since(start); elapsed >= 20*time.Millisecond {
+		t.Fatalf("FindByID took %v after ClearFaults", elapsed)
+	}
+}
+
+func TestFakeUserRepositoryUsers(t *testing.T) {
+	repo := NewFakeUserRepository()
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	repo.Now = func() time.Time { return created }
+
+	bob := newUser("bob")
+	bob.ID = 5
+	for _, user := range []*models.User{bob, newUser("alice"), newUser("carol")} {
+		if err := repo.Create(user); err != nil {
+			t.Fatalf("Create(%q) failed: %v", user.Username, err)
+		}
+	}
+	if err := repo.Delete(6); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	// Deleted users are left out, the rest come in ID order
+	users := repo.Users()
+	if len(users) != 2 || users[0].Username != "bob" || users[1].Username != "carol" || users[1].ID != 7 {
+		t.Fatalf("Users() = %+v, want bob (5) and carol (7)", users)
+	}
+	if !users[0].CreatedAt.Equal(created) {
+		t.Errorf("CreatedAt = %v, want the time from Now", users[0].CreatedAt)
+	}
+
+	// Changing the copy leaves the repository alone
+	users[0].Email = "changed@example.com"
+	if stored, _ := repo.FindByID(5); stored.Email != "bob@example.com" {
+		t.Errorf("Users() returned the stored user itself")
+	}
+}
@@ -4,13 +4,15 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+
+	"error-handling-demo/validation"
 )
 
 // User represents a user in the system
 type User struct {
 	ID        int       `json:"id"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
+	Username  string    `json:"username" validate:"required,min=3,max=50"`
+	Email     string    `json:"email" validate:"required,email"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
@@ -19,70 +21,42 @@ type UserValidator interface {
 	Validate() error
 }
 
-// Validate checks if the user data is valid
+// Validate checks if the user data is valid against the validate tags
 func (u *User) Validate() error {
-	// Create a multi-error to collect multiple validation errors
-	var errs []error
-
-	// Validate Username
-	if u.Username == "" {
-		errs = append(errs, errors.New("username cannot be empty"))
-	} else if len(u.Username) < 3 {
-		errs = append(errs, errors.New("username must be at least 3 characters long"))
-	} else if len(u.Username) > 50 {
-		errs = append(errs, errors.New("username must be less than 50 characters long"))
-	}
-
-	// Validate Email
-	if u.Email == "" {
-		errs = append(errs, errors.New("email cannot be empty"))
-	} else if !isValidEmail(u.Email) {
-		errs = append(errs, errors.New("email format is invalid"))
-	}
-
-	// If there are any errors, return them
-	if len(errs) > 0 {
-		return NewValidationError("user validation failed", errs)
+	v := validation.New()
+	if err := v.Struct(u); err != nil {
+		return err
 	}
-
-	return nil
+	return v.Err("user validation failed")
 }
 
-// isValidEmail is a simple function to validate email format
-func isValidEmail(email string) bool {
-	// This is a very simplified check - in real code use a proper validation
-	return len(email) > 5 && (email[len(email)-4:] == ".com" || email[len(email)-4:] == ".org")
-}
-
-// ValidationError represents multiple validation errors
-type ValidationError struct {
-	Message string
-	Errors  []error
-}
-
-// Error implements the error interface
-func (ve *ValidationError) Error() string {
-	if len(ve.Errors) == 0 {
-		return ve.Message
-	}
-
-	errMessages := make([]string, len(ve.Errors))
-	for i, err := range ve.Errors {
-		errMessages[i] = err.Error()
-	}
-
-	return ve.Message + ": " + strings.Join(errMessages, ", ")
-}
+// ValidationError lists every problem found while validating a model.
+// It is the same type as errors.ValidationError, so errors.As works with
+// either name.
+//
+// Deprecated: Use errors.ValidationError.
+type ValidationError = validation.ValidationError
 
 // NewValidationError creates a new ValidationError
+//
+// Deprecated: Use errors.NewValidationError.
 func NewValidationError(message string, errs []error) *ValidationError {
-	return &ValidationError{
-		Message: message,
-		Errors:  errs,
-	}
+	return validation.NewValidationError(message, errs)
 }
 
-// UserRepository defines operations for working with users
+// Errors every UserRepository reports, matchable with errors.Is
+var (
+	ErrUserNotFound  = errors.New("user not found")
+	ErrDuplicateUser = errors.New("user already exists")
+)
+
+// UserRepository defines operations for working with users.
+//
+// Implementations report a missing user as ErrUserNotFound, a username or ID
+// that is already taken as ErrDuplicateUser and an invalid user as a
+// *ValidationError. Create assigns an ID when user.ID is 0. A deleted user is
+// no longer found, but its ID and username stay taken. All methods are safe
+// for concurrent use.
 type UserRepository interface {
 	FindByID(id int) (*User, error)
 	FindByUsername(username string) (*User, error)
@@ -2,11 +2,16 @@ package netops
 
 import (
         "context"
+        "io"
         "io/ioutil"
         "net/http"
+        "net/url"
         "time"
 
         "github.com/pkg/errors"
+
+        apperrors "error-handling-demo/errors"
+        "error-handling-demo/faults"
 )
 
 // FetchWithRetry attempts to fetch data from a URL with retry logic
@@ -22,7 +27,7 @@ func FetchWithRetry(ctx context.Context, url string, maxRetries int) ([]byte, er
                 }
 
                 // Perform the request
-                resp, err := http.DefaultClient.Do(req)
+                resp, err := doRequest(http.DefaultClient, req, "netops.fetch")
                 if err != nil {
                         lastErr = errors.Wrapf(err, "attempt %d: request failed", attempt)
 
@@ -80,31 +85,32 @@ func FetchWithRetry(ctx context.Context, url string, maxRetries int) ([]byte, er
 }
 
 // FetchWithTimeout fetches data from a URL with a specific timeout
-func FetchWithTimeout(url string, timeout time.Duration) ([]byte, error) {
+func FetchWithTimeout(rawURL string, timeout time.Duration) ([]byte, error) {
         // Create a context with a timeout
         ctx, cancel := context.WithTimeout(context.Background(), timeout)
         defer cancel() // Ensure resources are cleaned up
 
         // Create the request with the timeout context
-        req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+        req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
         if err != nil {
                 return nil, errors.Wrap(err, "failed to create request")
         }
 
         // Perform the request
-        resp, err := http.DefaultClient.Do(req)
+        resp, err := doRequest(http.DefaultClient, req, "netops.fetch")
         if err != nil {
-                // Check if the error is due to context timeout
+                // Check if the error is due to context timeout; the URL is
+                // redacted because it may carry credentials or tokens
                 if ctx.Err() == context.DeadlineExceeded {
-                        return nil, errors.Wrapf(err, "request to %s timed out after %v", url, timeout)
+                        return nil, errors.Wrapf(err, "request to %s timed out after %v", apperrors.RedactURL(rawURL), timeout)
                 }
-                return nil, errors.Wrapf(err, "request to %s failed", url)
+                return nil, errors.Wrapf(err, "request to %s failed", apperrors.RedactURL(rawURL))
         }
         defer resp.Body.Close()
 
         // Check for non-successful status code
         if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-                return nil, errors.Errorf("non-successful status code: %d from %s", resp.StatusCode, url)
+                return nil, statusError(http.MethodGet, rawURL, resp)
         }
 
         // Read the response body
@@ -117,14 +123,14 @@ func FetchWithTimeout(url string, timeout time.Duration) ([]byte, error) {
 }
 
 // PostJSON sends a POST request with JSON data and handles errors
-func PostJSON(ctx context.Context, url string, jsonData []byte) ([]byte, error) {
+func PostJSON(ctx context.Context, rawURL string, jsonData []byte) ([]byte, error) {
         // Create a custom HTTP client with sensible defaults
         client := &http.Client{
                 Timeout: 30 * time.Second,
         }
 
         // Create a request with the provided context
-        req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+        req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, nil)
         if err != nil {
                 return nil, errors.Wrap(err, "failed to create request")
         }
@@ -134,23 +140,16 @@ func PostJSON(ctx context.Context, url string, jsonData []byte) ([]byte, error)
         req.Header.Set("Accept", "application/json")
 
         // Send the request
-        resp, err := client.Do(req)
+        resp, err := doRequest(client, req, "netops.post")
         if err != nil {
                 // Add contextual information to the error
-                return nil, errors.Wrapf(err, "failed to send POST request to %s", url)
+                return nil, errors.Wrapf(err, "failed to send POST request to %s", apperrors.RedactURL(rawURL))
         }
         defer resp.Body.Close()
 
         // Check for non-successful status code
         if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-                // Read error response body if available
-                errorBody, readErr := ioutil.ReadAll(resp.Body)
-                if readErr != nil {
-                        errorBody = []byte("[unable to read error response body]")
-                }
-
-                return nil, errors.Errorf("non-successful status code: %d, body: %s",
-                        resp.StatusCode, string(errorBody))
+                return nil, statusError(http.MethodPost, rawURL, resp)
         }
 
         // Read the response body
@@ -161,3 +160,41 @@ func PostJSON(ctx context.Context, url string, jsonData []byte) ([]byte, error)
 
         return responseData, nil
 }
+
+// doRequest sends req with client after consulting package faults, so an
+// injected failure reaches the caller exactly where a failed request would
+func doRequest(client *http.Client, req *http.Request, op string) (*http.Response, error) {
+        if err := faults.Inject(req.Context(), op); err != nil {
+                return nil, err
+        }
+        resp, err := client.Do(req)
+
+        // net/http puts the whole URL, query string included, into its errors
+        var urlErr *url.Error
+        if errors.As(err, &urlErr) {
+                urlErr.URL = apperrors.RedactURL(urlErr.URL)
+        }
+        return resp, err
+}
+
+// maxErrorBodySize is how much of an error response is read for statusError
+const maxErrorBodySize = 4096
+
+// statusError describes a response with a non-successful status code. The
+// body is read for the log message but passed through RedactBody first, so
+// tokens and passwords echoed by the server stay out of the error; API
+// clients only ever see the public message.
+func statusError(method, rawURL string, resp *http.Response) *apperrors.NetworkError {
+        body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+        if err != nil {
+                body = []byte("[unable to read error response body]")
+        }
+
+        // Server errors and rate limiting may go away when retried
+        retriable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+
+        netErr := apperrors.NewNetworkError(rawURL, method, nil, retriable)
+        netErr.StatusCode = resp.StatusCode
+        netErr.Body = apperrors.RedactBody(body)
+        return netErr
+}
@@ -0,0 +1,57 @@
+package netops
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	apperrors "error-handling-demo/errors"
+)
+
+func TestPostJSONDoesNotLeakSecrets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":"bad credentials","echo":{"password":"hunter2"},"session_token":"s3cr3t"}`))
+	}))
+	defer server.Close()
+
+	_, err := PostJSON(context.Background(), server.URL+"/login?api_key=k3y", []byte(`{}`))
+
+	var netErr *apperrors.NetworkError
+	if !errors.As(err, &netErr) {
+		t.Fatalf("PostJSON returned %v, want a *errors.NetworkError", err)
+	}
+	if netErr.StatusCode != http.StatusUnauthorized || netErr.Retriable {
+		t.Errorf("NetworkError = %+v, want a non-retriable 401", netErr)
+	}
+
+	for _, secret := range []string{"hunter2", "s3cr3t", "k3y"} {
+		if strings.Contains(err.Error(), secret) {
+			t.Errorf("Error() = %q leaks %q", err.Error(), secret)
+		}
+	}
+	if !strings.Contains(err.Error(), "bad credentials") {
+		t.Errorf("Error() = %q lost the harmless part of the body", err.Error())
+	}
+}
+
+func TestFetchWithTimeoutRedactsTransportErrors(t *testing.T) {
+	// Nothing listens on the closed server's address
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	_, err := FetchWithTimeout("http://bob:hunter2@"+strings.TrimPrefix(server.URL, "http://")+"/data?token=abc", time.Second)
+	if err == nil {
+		t.Fatal("FetchWithTimeout succeeded against a closed server")
+	}
+	for _, secret := range []string{"hunter2", "abc"} {
+		if strings.Contains(err.Error(), secret) {
+			t.Errorf("Error() = %q leaks %q", err.Error(), secret)
+		}
+	}
+}
@@ -1,14 +1,19 @@
 package utils
 
 import (
+        "context"
         "fmt"
         "os"
         "path/filepath"
         "runtime"
         "strings"
+        "sync/atomic"
+        "time"
 
         "github.com/pkg/errors"
         "github.com/sirupsen/logrus"
+
+        "error-handling-demo/fileops"
 )
 
 // NewLogger creates a new configured logrus logger
@@ -49,12 +54,53 @@ func FileLogger(filePath string) (*logrus.Logger, error) {
                 return nil, fmt.Errorf("could not open log file: %v", err)
         }
 
-        // Set output to the file
-        logger.SetOutput(file)
+        // Set output to the file, coordinating with other processes logging to it
+        lock, err := fileops.OpenFileLock(filePath + fileops.LockSuffix)
+        if err != nil {
+                file.Close()
+                return nil, fmt.Errorf("could not open log lock file: %v", err)
+        }
+        logger.SetOutput(&lockedWriter{file: file, lock: lock})
 
         return logger, nil
 }
 
+// logLockTimeout bounds how long a log write waits for other writers. It
+// is kept short so a stuck process only slows logging down a little.
+const logLockTimeout = 20 * time.Millisecond
+
+// lockedWriter appends to a log file while holding an exclusive lock on
+// its lock file, so lines written by several processes don't interleave.
+// The lock file stays open for as long as the logger is used.
+type lockedWriter struct {
+        file     *os.File
+        lock     *fileops.FileLock
+        unlocked atomic.Int64 // Lines written without the lock
+}
+
+// Write implements io.Writer. A line is written even if the lock can't be
+// taken in time, as losing it would be worse than interleaving. Such
+// writes are counted, see UnlockedLogWrites, and the first one is reported
+// on stderr.
+func (w *lockedWriter) Write(p []byte) (int, error) {
+        err := w.lock.TryLock(context.Background(), fileops.LockExclusive, logLockTimeout)
+        if err == nil {
+                defer w.lock.Unlock()
+        } else if w.unlocked.Add(1) == 1 {
+                fmt.Fprintf(os.Stderr, "writing to %s without its lock, lines may interleave: %v\n", w.file.Name(), err)
+        }
+        return w.file.Write(p)
+}
+
+// UnlockedLogWrites returns how many lines a logger made by FileLogger has
+// written without holding the log file's lock, 0 for other loggers
+func UnlockedLogWrites(logger *logrus.Logger) int64 {
+        if w, ok := logger.Out.(*lockedWriter); ok {
+                return w.unlocked.Load()
+        }
+        return 0
+}
+
 // LogLevelFromString converts a string to a logrus log level
 func LogLevelFromString(level string) (logrus.Level, error) {
         switch strings.ToLower(level) {
@@ -0,0 +1,228 @@
+package validation
+
+import (
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	apperrors "error-handling-demo/errors"
+)
+
+// Rule codes reported in FieldError.Code
+const (
+	CodeRequired  = "required"
+	CodeMinLength = "min_length"
+	CodeMaxLength = "max_length"
+	CodeMin       = "min"
+	CodeMax       = "max"
+	CodePattern   = "pattern"
+	CodeEmail     = "email"
+)
+
+// maxEmailLength is the longest address that fits in an SMTP path (RFC 5321)
+const maxEmailLength = 254
+
+// Rule is a single check applied to a value. Every rule except Required
+// accepts empty values, so optional fields only need Required left out.
+// Empty means a blank string, an empty slice or map, or a nil pointer or
+// interface; numbers are always checked, so Min(18) rejects 0.
+type Rule struct {
+	Code    string                 // Reported as FieldError.Code
+	Params  map[string]interface{} // Reported as FieldError.Params
+	Message string                 // English message, {name} is replaced by Params[name]
+	test    func(value reflect.Value) bool
+	// required makes the rule run on empty values as well
+	required bool
+}
+
+// check tests value and returns the error for a broken rule
+func (r Rule) check(value interface{}) *FieldError {
+	v := indirect(reflect.ValueOf(value))
+	if !r.required && isEmpty(v) {
+		return nil
+	}
+	if r.test(v) {
+		return nil
+	}
+
+	return &FieldError{
+		Code:    r.Code,
+		Params:  r.Params,
+		Message: apperrors.FormatMessage(r.Message, r.Params),
+	}
+}
+
+// WithMessage returns a copy of the rule reporting message instead of the
+// default; {name} placeholders are replaced by the rule's parameters
+func (r Rule) WithMessage(message string) Rule {
+	r.Message = message
+	return r
+}
+
+// Required rejects zero values and strings made only of whitespace
+func Required() Rule {
+	return Rule{
+		Code:     CodeRequired,
+		Message:  "cannot be empty",
+		required: true,
+		test: func(v reflect.Value) bool {
+			return !isEmpty(v) && !v.IsZero()
+		},
+	}
+}
+
+// MinLength requires a string to have at least min characters, or a slice
+// or map at least min elements
+func MinLength(min int) Rule {
+	return Rule{
+		Code:    CodeMinLength,
+		Params:  map[string]interface{}{"min": min},
+		Message: "must be at least {min} characters long",
+		test: func(v reflect.Value) bool {
+			n, ok := length(v)
+			return ok && n >= min
+		},
+	}
+}
+
+// MaxLength allows a string at most max characters, or a slice or map at
+// most max elements
+func MaxLength(max int) Rule {
+	return Rule{
+		Code:    CodeMaxLength,
+		Params:  map[string]interface{}{"max": max},
+		Message: "must be at most {max} characters long",
+		test: func(v reflect.Value) bool {
+			n, ok := length(v)
+			return ok && n <= max
+		},
+	}
+}
+
+// Min requires a number to be at least min
+func Min(min float64) Rule {
+	return Rule{
+		Code:    CodeMin,
+		Params:  map[string]interface{}{"min": min},
+		Message: "must be at least {min}",
+		test: func(v reflect.Value) bool {
+			n, ok := number(v)
+			return ok && n >= min
+		},
+	}
+}
+
+// Max requires a number to be at most max
+func Max(max float64) Rule {
+	return Rule{
+		Code:    CodeMax,
+		Params:  map[string]interface{}{"max": max},
+		Message: "must be at most {max}",
+		test: func(v reflect.Value) bool {
+			n, ok := number(v)
+			return ok && n <= max
+		},
+	}
+}
+
+// Matches requires a string to match re
+func Matches(re *regexp.Regexp) Rule {
+	return Rule{
+		Code:    CodePattern,
+		Params:  map[string]interface{}{"pattern": re.String()},
+		Message: "has an invalid format",
+		test: func(v reflect.Value) bool {
+			return v.Kind() == reflect.String && re.MatchString(v.String())
+		},
+	}
+}
+
+// Email requires a string to be a bare RFC 5322 address such as
+// "jane@example.com", without a display name or angle brackets
+func Email() Rule {
+	return Rule{
+		Code:    CodeEmail,
+		Message: "format is invalid",
+		test: func(v reflect.Value) bool {
+			if v.Kind() != reflect.String {
+				return false
+			}
+			s := v.String()
+			if len(s) > maxEmailLength {
+				return false
+			}
+			addr, err := mail.ParseAddress(s)
+			return err == nil && addr.Address == s
+		},
+	}
+}
+
+// Func turns fn into a rule reported with code and message. fn receives
+// the value as passed to Validator.Field, or the field value for Struct.
+func Func(code, message string, fn func(value interface{}) bool) Rule {
+	return Rule{
+		Code:    code,
+		Message: message,
+		test: func(v reflect.Value) bool {
+			if !v.IsValid() {
+				return fn(nil)
+			}
+			return fn(v.Interface())
+		},
+	}
+}
+
+// indirect follows pointers and interfaces to the value they hold
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// isEmpty reports whether v holds nothing worth validating. Zero numbers,
+// booleans and structs are values like any other.
+func isEmpty(v reflect.Value) bool {
+	if !v.IsValid() {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(v.String()) == ""
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	default:
+		return false
+	}
+}
+
+// length returns the number of characters or elements in v
+func length(v reflect.Value) (int, bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return utf8.RuneCountInString(v.String()), true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len(), true
+	default:
+		return 0, false
+	}
+}
+
+// number returns v as a float64 if it is numeric
+func number(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,245 @@
+package validation
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidTag is returned by Struct for a validate tag it can't parse
+var ErrInvalidTag = errors.New("invalid validate tag")
+
+// TagName is the struct tag read by Struct. A tag lists rules separated by
+// commas, e.g. `validate:"required,min=3,max=50"`:
+//
+//	required        Required()
+//	min=N, max=N    MinLength/MaxLength for strings, slices and maps,
+//	                Min/Max for numbers
+//	email           Email()
+//	pattern=NAME    Matches the pattern registered as NAME
+//	NAME[=PARAM]    A rule registered with RegisterRule
+//
+// Fields are reported under their JSON name. Nested structs, pointers to
+// structs and slices of structs are validated too, giving paths such as
+// "address.city" and "contacts[1].email". A tag of "-" skips the field.
+const TagName = "validate"
+
+// RuleFactory builds a rule from the parameter given in a tag, which is
+// empty if the tag has no "=PARAM"
+type RuleFactory func(param string) (Rule, error)
+
+var (
+	registryMu sync.RWMutex
+	patterns   = map[string]*regexp.Regexp{}
+	factories  = map[string]RuleFactory{}
+)
+
+// RegisterPattern makes re available to tags as pattern=name
+func RegisterPattern(name string, re *regexp.Regexp) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	patterns[name] = re
+}
+
+// RegisterRule makes a custom rule available to tags under name
+func RegisterRule(name string, factory RuleFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	factories[name] = factory
+}
+
+// fieldRules are the parsed rules of one struct field
+type fieldRules struct {
+	index int
+	name  string
+	rules []Rule
+}
+
+// typeRules caches parsed tags per struct type
+var typeRules sync.Map // map[reflect.Type][]fieldRules
+
+// Struct validates v, a struct or pointer to a struct, against its validate
+// tags. It returns nil, a *ValidationError listing every broken rule, or an
+// error matching ErrInvalidTag if a tag can't be parsed.
+func Struct(v interface{}) error {
+	validator := New()
+	if err := validator.Struct(v); err != nil {
+		return err
+	}
+	return validator.Err("validation failed")
+}
+
+// Struct records the broken validate tag rules of v, a struct or pointer to
+// a struct. It only returns an error if v isn't a struct or a tag can't be
+// parsed; validation failures are collected for Err.
+func (v *Validator) Struct(value interface{}) error {
+	rv := indirect(reflect.ValueOf(value))
+	if rv.Kind() != reflect.Struct {
+		return errors.Errorf("validation.Struct needs a struct, got %T", value)
+	}
+	return v.walk("", rv)
+}
+
+// walk validates the fields of the struct value, prefixing paths with prefix
+func (v *Validator) walk(prefix string, value reflect.Value) error {
+	fields, err := rulesFor(value.Type())
+	if err != nil {
+		return err
+	}
+
+	for _, field := range fields {
+		path := field.name
+		if prefix != "" {
+			path = prefix + "." + field.name
+		}
+		fieldValue := value.Field(field.index)
+
+		if !v.Field(path, fieldValue.Interface(), field.rules...) {
+			continue
+		}
+		if err := v.nested(path, fieldValue); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nested descends into struct values held by a field
+func (v *Validator) nested(path string, value reflect.Value) error {
+	value = indirect(value)
+	if !value.IsValid() {
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		return v.walk(path, value)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			if err := v.nested(path+"["+strconv.Itoa(i)+"]", value.Index(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rulesFor parses the tags of a struct type, caching the result
+func rulesFor(t reflect.Type) ([]fieldRules, error) {
+	if cached, ok := typeRules.Load(t); ok {
+		return cached.([]fieldRules), nil
+	}
+
+	var fields []fieldRules
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get(TagName)
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+
+		rules, err := parseTag(field.Type, tag)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s.%s", t.Name(), field.Name)
+		}
+		fields = append(fields, fieldRules{index: i, name: fieldName(field), rules: rules})
+	}
+
+	typeRules.Store(t, fields)
+	return fields, nil
+}
+
+// fieldName returns the JSON name of a field, or its Go name
+func fieldName(field reflect.StructField) string {
+	if name := strings.Split(field.Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+		return name
+	}
+	return field.Name
+}
+
+// parseTag turns a validate tag into rules for a field of type t
+func parseTag(t reflect.Type, tag string) ([]Rule, error) {
+	if tag == "" {
+		return nil, nil
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	var rules []Rule
+	for _, item := range strings.Split(tag, ",") {
+		name, param := item, ""
+		if i := strings.IndexByte(item, '='); i >= 0 {
+			name, param = item[:i], item[i+1:]
+		}
+
+		rule, err := parseRule(t, strings.TrimSpace(name), strings.TrimSpace(param))
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// parseRule builds the rule named in a tag
+func parseRule(t reflect.Type, name, param string) (Rule, error) {
+	switch name {
+	case "required":
+		return Required(), nil
+	case "email":
+		return Email(), nil
+	case "min", "max":
+		return boundRule(t, name, param)
+	case "pattern":
+		registryMu.RLock()
+		re, ok := patterns[param]
+		registryMu.RUnlock()
+		if !ok {
+			return Rule{}, errors.Wrapf(ErrInvalidTag, "unknown pattern %q", param)
+		}
+		return Matches(re), nil
+	}
+
+	registryMu.RLock()
+	factory, ok := factories[name]
+	registryMu.RUnlock()
+	if !ok {
+		return Rule{}, errors.Wrapf(ErrInvalidTag, "unknown rule %q", name)
+	}
+
+	rule, err := factory(param)
+	if err != nil {
+		return Rule{}, errors.Wrapf(ErrInvalidTag, "rule %q: %v", name, err)
+	}
+	return rule, nil
+}
+
+// boundRule builds min or max as a length rule or a numeric rule depending
+// on the field type
+func boundRule(t reflect.Type, name, param string) (Rule, error) {
+	switch t.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		n, err := strconv.Atoi(param)
+		if err != nil {
+			return Rule{}, errors.Wrapf(ErrInvalidTag, "%s needs an integer, got %q", name, param)
+		}
+		if name == "min" {
+			return MinLength(n), nil
+		}
+		return MaxLength(n), nil
+	default:
+		n, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return Rule{}, errors.Wrapf(ErrInvalidTag, "%s needs a number, got %q", name, param)
+		}
+		if name == "min" {
+			return Min(n), nil
+		}
+		return Max(n), nil
+	}
+}
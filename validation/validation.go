@@ -0,0 +1,80 @@
+// Package validation checks values against declarative rules and reports
+// every failure as a *FieldError carrying the field path, a rule code and
+// the rule's parameters, aggregated into a single *ValidationError.
+//
+// Rules can be listed in code:
+//
+//	v := validation.New()
+//	v.Field("username", u.Username, validation.Required(), validation.MinLength(3), validation.MaxLength(50))
+//	v.Field("email", u.Email, validation.Required(), validation.Email())
+//	return v.Err("user validation failed")
+//
+// or declared with struct tags and checked with Struct, see tags.go.
+package validation
+
+import (
+	apperrors "error-handling-demo/errors"
+)
+
+// FieldError reports a value that broke a rule, see errors.FieldError
+type FieldError = apperrors.FieldError
+
+// ValidationError collects every problem found while validating a value,
+// see errors.ValidationError
+type ValidationError = apperrors.ValidationError
+
+// NewValidationError creates a new ValidationError
+func NewValidationError(message string, errs []error) *ValidationError {
+	return apperrors.NewValidationError(message, errs)
+}
+
+// Validator collects field errors while a value is being validated
+type Validator struct {
+	errs []error
+}
+
+// New creates an empty Validator
+func New() *Validator {
+	return &Validator{}
+}
+
+// Field checks value against rules in order and records the first broken
+// rule under path. It returns whether the value passed every rule.
+func (v *Validator) Field(path string, value interface{}, rules ...Rule) bool {
+	for _, rule := range rules {
+		if fieldErr := rule.check(value); fieldErr != nil {
+			fieldErr.Path = path
+			v.errs = append(v.errs, fieldErr)
+			return false
+		}
+	}
+	return true
+}
+
+// Add records an error found by other means, e.g. a cross-field check
+func (v *Validator) Add(err error) {
+	if err == nil {
+		return
+	}
+
+	// Flatten nested validation errors so every failure is listed once
+	if nested, ok := err.(*ValidationError); ok {
+		v.errs = append(v.errs, nested.Errors...)
+		return
+	}
+	v.errs = append(v.errs, err)
+}
+
+// Valid reports whether no errors have been recorded
+func (v *Validator) Valid() bool {
+	return len(v.errs) == 0
+}
+
+// Err returns the recorded errors as a *ValidationError with the given
+// message, or nil if there are none
+func (v *Validator) Err(message string) error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return NewValidationError(message, v.errs)
+}
@@ -0,0 +1,195 @@
+package validation
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+type address struct {
+	City string `json:"city" validate:"required"`
+	Zip  string `json:"zip" validate:"pattern=zip"`
+}
+
+type contact struct {
+	Email string `json:"email" validate:"email"`
+}
+
+type customer struct {
+	Name     string    `json:"name" validate:"required,min=2,max=10"`
+	Age      int       `json:"age" validate:"min=18,max=130"`
+	Tags     []string  `json:"tags" validate:"max=2"`
+	Address  *address  `json:"address" validate:"required"`
+	Contacts []contact `json:"contacts"`
+	Nickname string    `validate:"even"`
+	Ignored  string    `validate:"-"`
+}
+
+func init() {
+	RegisterPattern("zip", regexp.MustCompile(`^\d{5}$`))
+	RegisterRule("even", func(string) (Rule, error) {
+		return Func("even", "must have an even length", func(value interface{}) bool {
+			return len(value.(string))%2 == 0
+		}), nil
+	})
+}
+
+func TestStructValid(t *testing.T) {
+	c := customer{
+		Name:     "Jane",
+		Age:      30,
+		Address:  &address{City: "Springfield", Zip: "12345"},
+		Contacts: []contact{{Email: "jane@example.com"}},
+		Nickname: "jj",
+	}
+	if err := Struct(c); err != nil {
+		t.Fatalf("Struct(valid) = %v", err)
+	}
+}
+
+func TestStructReportsEveryField(t *testing.T) {
+	c := customer{
+		Name:     "J",
+		Age:      12,
+		Tags:     []string{"a", "b", "c"},
+		Address:  &address{Zip: "1234"},
+		Contacts: []contact{{Email: "jane@example.com"}, {Email: "Jane <jane@example.com>"}},
+		Nickname: "odd",
+	}
+
+	err := Struct(&c)
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Struct(invalid) = %v, want a *ValidationError", err)
+	}
+
+	want := map[string]string{
+		"name":              CodeMinLength,
+		"age":               CodeMin,
+		"tags":              CodeMaxLength,
+		"address.city":      CodeRequired,
+		"address.zip":       CodePattern,
+		"contacts[1].email": CodeEmail,
+		"Nickname":          "even",
+	}
+	got := make(map[string]string)
+	for _, fieldErr := range validationErr.FieldErrors() {
+		got[fieldErr.Path] = fieldErr.Code
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("field errors = %v, want %v", got, want)
+	}
+
+	fields := validationErr.Fields()
+	if msg := fields["name"]; len(msg) != 1 || msg[0] != "must be at least 2 characters long" {
+		t.Fatalf(`Fields()["name"] = %q`, msg)
+	}
+}
+
+func TestFieldStopsAtFirstBrokenRule(t *testing.T) {
+	v := New()
+	if v.Field("username", "", Required(), MinLength(3)) {
+		t.Fatal("Field accepted an empty required value")
+	}
+
+	fieldErrs := v.Err("failed").(*ValidationError).FieldErrors()
+	if len(fieldErrs) != 1 || fieldErrs[0].Code != CodeRequired {
+		t.Fatalf("field errors = %v, want only %q", fieldErrs, CodeRequired)
+	}
+	if !v.Field("nickname", "", MinLength(3)) {
+		t.Fatal("MinLength rejected an empty optional value")
+	}
+}
+
+func TestFieldErrorParams(t *testing.T) {
+	v := New()
+	v.Field("username", strings.Repeat("x", 51), MaxLength(50))
+
+	fieldErr := v.Err("failed").(*ValidationError).FieldErrors()[0]
+	if fieldErr.Params["max"] != 50 {
+		t.Fatalf("Params = %v, want max 50", fieldErr.Params)
+	}
+	if fieldErr.Error() != "username must be at most 50 characters long" {
+		t.Fatalf("Error() = %q", fieldErr.Error())
+	}
+}
+
+func TestEmail(t *testing.T) {
+	for _, email := range []string{"jane@example.com", "jane.doe+tag@mail.example.co.uk", "j@example.io"} {
+		if err := Email().check(email); err != nil {
+			t.Errorf("Email rejected %q", email)
+		}
+	}
+	for _, email := range []string{"jane", "jane@", "@example.com", "Jane <jane@example.com>", " jane@example.com"} {
+		if err := Email().check(email); err == nil {
+			t.Errorf("Email accepted %q", email)
+		}
+	}
+}
+
+func TestInvalidTag(t *testing.T) {
+	type broken struct {
+		Name string `validate:"no_such_rule"`
+	}
+	if err := Struct(broken{}); !errors.Is(err, ErrInvalidTag) {
+		t.Fatalf("Struct(broken) = %v, want ErrInvalidTag", err)
+	}
+}
+
+func TestZeroNumbersAreChecked(t *testing.T) {
+	zero, one := 0, 1
+	for _, tc := range []struct {
+		name  string
+		value interface{}
+		rule  Rule
+		code  string // Expected failure, "" if the value passes
+	}{
+		{"min int", 0, Min(18), CodeMin},
+		{"min uint", uint8(0), Min(1), CodeMin},
+		{"min float", 0.0, Min(0.5), CodeMin},
+		{"max negative", 0, Max(-1), CodeMax},
+		{"min zero", 0, Min(0), ""},
+		{"max zero", 0, Max(10), ""},
+		{"pointer to zero", &zero, Min(1), CodeMin},
+		{"pointer to one", &one, Min(1), ""},
+		{"nil pointer", (*int)(nil), Min(1), ""},
+		{"required zero", 0, Required(), CodeRequired},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fieldErr := tc.rule.check(tc.value)
+			switch {
+			case tc.code == "" && fieldErr != nil:
+				t.Errorf("rejected %v: %v", tc.value, fieldErr)
+			case tc.code != "" && (fieldErr == nil || fieldErr.Code != tc.code):
+				t.Errorf("check(%v) = %v, want %s", tc.value, fieldErr, tc.code)
+			}
+		})
+	}
+}
+
+func TestZeroNumberTags(t *testing.T) {
+	type order struct {
+		Quantity int      `json:"quantity" validate:"min=1"`
+		Discount float64  `json:"discount" validate:"max=-0.5"`
+		Priority *int     `json:"priority" validate:"min=1"`
+		Weight   *float64 `json:"weight" validate:"min=0.1"`
+	}
+	zero := 0.0
+
+	err := Struct(order{Weight: &zero})
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Struct(zero values) = %v, want a *ValidationError", err)
+	}
+	got := make(map[string]string)
+	for _, fieldErr := range validationErr.FieldErrors() {
+		got[fieldErr.Path] = fieldErr.Code
+	}
+	want := map[string]string{"quantity": CodeMin, "discount": CodeMax, "weight": CodeMin}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("field errors = %v, want %v", got, want)
+	}
+}